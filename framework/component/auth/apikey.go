@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
+	"gopkg.in/yaml.v2"
+)
+
+type apiKeysFile struct {
+	Keys []struct {
+		Key     string   `yaml:"key"`
+		Subject string   `yaml:"subject"`
+		Roles   []string `yaml:"roles"`
+		Scopes  []string `yaml:"scopes"`
+	} `yaml:"keys"`
+}
+
+// apiKeyStore indexes principals by the digest of their key so that lookups don't leak key contents through timing.
+type apiKeyStore struct {
+	principals map[[sha256.Size]byte]*Principal
+}
+
+func loadApiKeys(file string) (*apiKeyStore, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var config apiKeysFile
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid api keys file %s: %v", file, err)
+	}
+
+	store := &apiKeyStore{
+		principals: make(map[[sha256.Size]byte]*Principal),
+	}
+	for _, entry := range config.Keys {
+		if entry.Key == "" || entry.Subject == "" {
+			return nil, fmt.Errorf("invalid api keys file %s: key and subject are required", file)
+		}
+		store.principals[sha256.Sum256([]byte(entry.Key))] = &Principal{
+			Subject: entry.Subject,
+			Method:  MethodAPIKey,
+			Roles:   entry.Roles,
+			Scopes:  entry.Scopes,
+		}
+	}
+	return store, nil
+}
+
+func (s *apiKeyStore) authenticate(key string) (*Principal, error) {
+	principal, exists := s.principals[sha256.Sum256([]byte(key))]
+	if !exists {
+		return nil, errors.Unauthenticated("invalid_api_key", "invalid api key")
+	}
+	return principal, nil
+}
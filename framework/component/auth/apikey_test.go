@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestApiKeyAuthenticate(t *testing.T) {
+	store, err := loadApiKeys(writeFile(t, "api-keys.yaml", `
+keys:
+  - key: dashboard-key
+    subject: dashboard
+    roles: [reader]
+  - key: publisher-key
+    subject: publisher
+    scopes: [messages:write]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key     string
+		subject string
+	}{
+		{"dashboard-key", "dashboard"},
+		{"publisher-key", "publisher"},
+		{"unknown-key", ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		principal, err := store.authenticate(test.key)
+		if test.subject == "" {
+			if e := errors.From(err); e.Kind != errors.KindUnauthenticated || e.Code != "invalid_api_key" {
+				t.Errorf("%q: expected invalid_api_key, got %v", test.key, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.key, err)
+			continue
+		}
+		if principal.Subject != test.subject || principal.Method != MethodAPIKey {
+			t.Errorf("%q: unexpected principal %+v", test.key, principal)
+		}
+	}
+}
+
+func TestLoadApiKeysInvalid(t *testing.T) {
+	for name, content := range map[string]string{
+		"missing key":     "keys:\n  - subject: dashboard\n",
+		"missing subject": "keys:\n  - key: dashboard-key\n",
+		"invalid yaml":    "keys: [",
+	} {
+		if _, err := loadApiKeys(writeFile(t, "api-keys.yaml", content)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/ubiqueworks/go-clean-architecture/framework"
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
+	"gopkg.in/urfave/cli.v1"
+)
+
+const (
+	Component = "auth"
+
+	MethodJWT    = "jwt"
+	MethodAPIKey = "api_key"
+	MethodMTLS   = "mtls"
+
+	envAuthJwksFile       = "AUTH_JWKS_FILE"
+	envAuthJwtKeys        = "AUTH_JWT_KEYS"
+	envAuthJwtIssuer      = "AUTH_JWT_ISSUER"
+	envAuthJwtAudience    = "AUTH_JWT_AUDIENCE"
+	envAuthApiKeysFile    = "AUTH_API_KEYS_FILE"
+	envAuthMtls           = "AUTH_MTLS"
+	envAuthAllowAnonymous = "AUTH_ALLOW_ANONYMOUS"
+	envAuthPolicyFile     = "AUTH_POLICY_FILE"
+	envAuthPolicyReload   = "AUTH_POLICY_RELOAD_INTERVAL"
+
+	flagAuthJwksFile       = "auth-jwks-file"
+	flagAuthJwtKeys        = "auth-jwt-keys"
+	flagAuthJwtIssuer      = "auth-jwt-issuer"
+	flagAuthJwtAudience    = "auth-jwt-audience"
+	flagAuthApiKeysFile    = "auth-api-keys-file"
+	flagAuthMtls           = "auth-mtls"
+	flagAuthAllowAnonymous = "auth-allow-anonymous"
+	flagAuthPolicyFile     = "auth-policy-file"
+	flagAuthPolicyReload   = "auth-policy-reload-interval"
+)
+
+var cliFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:   flagAuthJwksFile,
+		EnvVar: envAuthJwksFile,
+		Usage:  "jwks file used to verify jwt bearer tokens",
+	},
+	cli.StringSliceFlag{
+		Name:   flagAuthJwtKeys,
+		EnvVar: envAuthJwtKeys,
+		Usage:  "pem public key files used to verify jwt bearer tokens",
+	},
+	cli.StringFlag{
+		Name:   flagAuthJwtIssuer,
+		EnvVar: envAuthJwtIssuer,
+		Usage:  "expected jwt issuer",
+	},
+	cli.StringFlag{
+		Name:   flagAuthJwtAudience,
+		EnvVar: envAuthJwtAudience,
+		Usage:  "expected jwt audience",
+	},
+	cli.StringFlag{
+		Name:   flagAuthApiKeysFile,
+		EnvVar: envAuthApiKeysFile,
+		Usage:  "yaml file of api keys and the principals they identify",
+	},
+	cli.BoolFlag{
+		Name:   flagAuthMtls,
+		EnvVar: envAuthMtls,
+		Usage:  "authenticate clients by their tls certificate",
+	},
+	cli.BoolFlag{
+		Name:   flagAuthAllowAnonymous,
+		EnvVar: envAuthAllowAnonymous,
+		Usage:  "allow requests without credentials",
+	},
+	cli.StringFlag{
+		Name:   flagAuthPolicyFile,
+		EnvVar: envAuthPolicyFile,
+		Usage:  "yaml authorization policy file, its rules take precedence over the ones declared by the service",
+	},
+	cli.DurationFlag{
+		Name:   flagAuthPolicyReload,
+		EnvVar: envAuthPolicyReload,
+		Value:  30 * time.Second,
+		Usage:  "interval between checks for changes to the policy file",
+	},
+}
+
+type contextKey struct{}
+
+// Create returns the auth component, enforcing the access rules declared by the service.
+func Create(rules ...Rule) framework.Component {
+	return &authManager{
+		rules: rules,
+	}
+}
+
+func Get(service framework.Service) (Authenticator, error) {
+	component, err := service.Component(Component)
+	if err != nil {
+		return nil, err
+	}
+	return component.(Authenticator), nil
+}
+
+func GetAuthorizer(service framework.Service) (Authorizer, error) {
+	component, err := service.Component(Component)
+	if err != nil {
+		return nil, err
+	}
+	return component.(Authorizer), nil
+}
+
+type Authenticator interface {
+	// Authenticate returns the principal identified by the credentials, or nil for allowed anonymous requests.
+	Authenticate(*Credentials) (*Principal, error)
+}
+
+type Authorizer interface {
+	AuthorizeHTTP(method, path string, principal *Principal) error
+	AuthorizeRPC(fullMethod string, principal *Principal) error
+}
+
+type Credentials struct {
+	BearerToken      string
+	APIKey           string
+	PeerCertificates []*x509.Certificate
+}
+
+type Principal struct {
+	Subject string   `json:"subject"`
+	Method  string   `json:"method"`
+	Roles   []string `json:"roles,omitempty"`
+	Scopes  []string `json:"scopes,omitempty"`
+}
+
+func NewContext(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, principal)
+}
+
+func FromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(contextKey{}).(*Principal)
+	return principal
+}
+
+type authManager struct {
+	logger         *zerolog.Logger
+	jwt            *jwtVerifier
+	apiKeys        *apiKeyStore
+	mtls           bool
+	allowAnonymous bool
+	rules          []Rule
+	policyFile     string
+	policyReload   time.Duration
+	policyLock     sync.RWMutex
+	policy         *policy
+}
+
+func (a *authManager) Authenticate(creds *Credentials) (*Principal, error) {
+	switch {
+	case creds.BearerToken != "" && a.jwt != nil:
+		return a.jwt.authenticate(creds.BearerToken)
+	case creds.APIKey != "" && a.apiKeys != nil:
+		return a.apiKeys.authenticate(creds.APIKey)
+	case len(creds.PeerCertificates) > 0 && a.mtls:
+		return authenticateCertificate(creds.PeerCertificates[0])
+	case a.allowAnonymous:
+		return nil, nil
+	default:
+		return nil, errors.Unauthenticated("missing_credentials", "missing or unsupported credentials")
+	}
+}
+
+func (a *authManager) AuthorizeHTTP(method, path string, principal *Principal) error {
+	return a.authorize(principal, func(r *Rule) bool {
+		return r.matchHTTP(method, path)
+	})
+}
+
+func (a *authManager) AuthorizeRPC(fullMethod string, principal *Principal) error {
+	return a.authorize(principal, func(r *Rule) bool {
+		return r.matchRPC(fullMethod)
+	})
+}
+
+func (a *authManager) authorize(principal *Principal, match func(*Rule) bool) error {
+	a.policyLock.RLock()
+	p := a.policy
+	a.policyLock.RUnlock()
+
+	var fileRules []Rule
+	if p != nil {
+		fileRules = p.rules
+	}
+
+	// The first matching rule decides, policy file rules first
+	for _, rules := range [][]Rule{fileRules, a.rules} {
+		for i := range rules {
+			if match(&rules[i]) {
+				return rules[i].check(principal)
+			}
+		}
+	}
+
+	if p != nil && p.denyByDefault {
+		return errors.PermissionDenied("access_denied", "access denied by default policy")
+	}
+	return nil
+}
+
+func (a *authManager) ID() string {
+	return Component
+}
+
+func (a *authManager) DependsOn() []string {
+	return nil
+}
+
+func (a *authManager) Flags() []cli.Flag {
+	return cliFlags
+}
+
+func (a *authManager) Logger() *zerolog.Logger {
+	return a.logger
+}
+
+func (a *authManager) Configure(service framework.Service, cliCtx *cli.Context) error {
+	logger := service.Logger().With().Str("component", Component).Logger()
+	a.logger = &logger
+
+	jwksFile := cliCtx.String(flagAuthJwksFile)
+	jwtKeys := cliCtx.StringSlice(flagAuthJwtKeys)
+	if jwksFile != "" || len(jwtKeys) > 0 {
+		verifier, err := newJwtVerifier(jwksFile, jwtKeys, cliCtx.String(flagAuthJwtIssuer), cliCtx.String(flagAuthJwtAudience))
+		if err != nil {
+			return err
+		}
+		a.jwt = verifier
+	}
+
+	if apiKeysFile := cliCtx.String(flagAuthApiKeysFile); apiKeysFile != "" {
+		store, err := loadApiKeys(apiKeysFile)
+		if err != nil {
+			return err
+		}
+		a.apiKeys = store
+	}
+
+	a.mtls = cliCtx.Bool(flagAuthMtls)
+	a.allowAnonymous = cliCtx.Bool(flagAuthAllowAnonymous)
+
+	if policyFile := cliCtx.String(flagAuthPolicyFile); policyFile != "" {
+		p, err := loadPolicy(policyFile)
+		if err != nil {
+			return err
+		}
+		a.policyFile = policyFile
+		a.policy = p
+	}
+	a.policyReload = cliCtx.Duration(flagAuthPolicyReload)
+
+	if a.jwt == nil && a.apiKeys == nil && !a.mtls && !a.allowAnonymous {
+		return fmt.Errorf("no authentication method configured")
+	}
+	return nil
+}
+
+func (a *authManager) Initialize(wg *sync.WaitGroup, startedCh chan<- struct{}, shutdownCh <-chan struct{}, errCh chan<- error) {
+	defer wg.Done()
+
+	a.logger.Info().
+		Bool("jwt", a.jwt != nil).
+		Bool("api_key", a.apiKeys != nil).
+		Bool("mtls", a.mtls).
+		Bool("anonymous", a.allowAnonymous).
+		Str("policy", a.policyFile).
+		Msg("authentication enabled")
+	close(startedCh)
+
+	if a.policyFile == "" || a.policyReload <= 0 {
+		<-shutdownCh
+		return
+	}
+
+	ticker := time.NewTicker(a.policyReload)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			a.reloadPolicy()
+		case <-shutdownCh:
+			return
+		}
+	}
+}
+
+func (a *authManager) reloadPolicy() {
+	info, err := os.Stat(a.policyFile)
+	if err != nil {
+		a.logger.Error().Err(err).Msg("error checking policy file")
+		return
+	}
+
+	a.policyLock.RLock()
+	changed := info.ModTime().After(a.policy.modTime)
+	a.policyLock.RUnlock()
+	if !changed {
+		return
+	}
+
+	// Keep enforcing the current policy if the new one is invalid
+	p, err := loadPolicy(a.policyFile)
+	if err != nil {
+		a.logger.Error().Err(err).Msg("error reloading policy file")
+		return
+	}
+
+	a.policyLock.Lock()
+	a.policy = p
+	a.policyLock.Unlock()
+
+	a.logger.Info().Int("rules", len(p.rules)).Msg("policy reloaded")
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestAuthenticate(t *testing.T) {
+	key := generateKey(t)
+	apiKeys := &apiKeyStore{principals: map[[sha256.Size]byte]*Principal{
+		sha256.Sum256([]byte("api-key")): {Subject: "api-client", Method: MethodAPIKey},
+	}}
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "tls-client", OrganizationalUnit: []string{"writer"}}}
+	verifier := &jwtVerifier{keys: jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &key.PublicKey}}}}
+	token := signToken(t, key, "", testClaims{Claims: jwt.Claims{Subject: "jwt-client", Expiry: jwt.NewNumericDate(time.Now().Add(time.Hour))}})
+
+	tests := []struct {
+		name      string
+		manager   *authManager
+		creds     *Credentials
+		subject   string
+		anonymous bool
+		code      string
+	}{
+		{"jwt", &authManager{jwt: verifier, apiKeys: apiKeys}, &Credentials{BearerToken: token, APIKey: "api-key"}, "jwt-client", false, ""},
+		{"api key", &authManager{jwt: verifier, apiKeys: apiKeys}, &Credentials{APIKey: "api-key"}, "api-client", false, ""},
+		{"mtls", &authManager{mtls: true}, &Credentials{PeerCertificates: []*x509.Certificate{cert}}, "tls-client", false, ""},
+		{"certificate without mtls", &authManager{}, &Credentials{PeerCertificates: []*x509.Certificate{cert}}, "", false, "missing_credentials"},
+		{"certificate without common name", &authManager{mtls: true}, &Credentials{PeerCertificates: []*x509.Certificate{{}}}, "", false, "invalid_certificate"},
+		{"unsupported credentials", &authManager{apiKeys: apiKeys}, &Credentials{BearerToken: token}, "", false, "missing_credentials"},
+		{"anonymous", &authManager{apiKeys: apiKeys, allowAnonymous: true}, &Credentials{}, "", true, ""},
+		{"invalid credentials with anonymous", &authManager{apiKeys: apiKeys, allowAnonymous: true}, &Credentials{APIKey: "unknown"}, "", false, "invalid_api_key"},
+		{"missing credentials", &authManager{apiKeys: apiKeys}, &Credentials{}, "", false, "missing_credentials"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			principal, err := test.manager.Authenticate(test.creds)
+			if test.code != "" {
+				if e := errors.From(err); e.Kind != errors.KindUnauthenticated || e.Code != test.code {
+					t.Errorf("expected %s, got %v", test.code, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.anonymous {
+				if principal != nil {
+					t.Errorf("expected an anonymous request, got %+v", principal)
+				}
+				return
+			}
+			if principal == nil || principal.Subject != test.subject {
+				t.Errorf("expected subject %s, got %+v", test.subject, principal)
+			}
+		})
+	}
+}
+
+func TestAuthenticateCertificateRoles(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "tls-client", OrganizationalUnit: []string{"reader", "writer"}}}
+	principal, err := authenticateCertificate(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if principal.Method != MethodMTLS || !equalStrings(principal.Roles, []string{"reader", "writer"}) {
+		t.Errorf("unexpected principal %+v", principal)
+	}
+}
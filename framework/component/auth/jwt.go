@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+type jwtClaims struct {
+	Scope  string   `json:"scope,omitempty"`
+	Scopes []string `json:"scp,omitempty"`
+	Roles  []string `json:"roles,omitempty"`
+}
+
+type jwtVerifier struct {
+	keys     jose.JSONWebKeySet
+	issuer   string
+	audience string
+}
+
+func newJwtVerifier(jwksFile string, keyFiles []string, issuer, audience string) (*jwtVerifier, error) {
+	v := &jwtVerifier{
+		issuer:   issuer,
+		audience: audience,
+	}
+
+	if jwksFile != "" {
+		data, err := ioutil.ReadFile(jwksFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &v.keys); err != nil {
+			return nil, fmt.Errorf("invalid jwks file %s: %v", jwksFile, err)
+		}
+	}
+
+	for _, keyFile := range keyFiles {
+		key, err := loadPublicKey(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		v.keys.Keys = append(v.keys.Keys, jose.JSONWebKey{Key: key})
+	}
+
+	if len(v.keys.Keys) == 0 {
+		return nil, fmt.Errorf("no jwt verification keys configured")
+	}
+	return v, nil
+}
+
+func (v *jwtVerifier) authenticate(token string) (*Principal, error) {
+	parsed, err := jwt.ParseSigned(token)
+	if err != nil {
+		return nil, errors.Wrap(err, errors.KindUnauthenticated, "invalid_token", "malformed bearer token")
+	}
+
+	// Keys without a kid are tried when the token doesn't match a key by id
+	candidates := v.keys.Keys
+	if len(parsed.Headers) > 0 && parsed.Headers[0].KeyID != "" {
+		if keys := v.keys.Key(parsed.Headers[0].KeyID); len(keys) > 0 {
+			candidates = keys
+		}
+	}
+
+	for _, key := range candidates {
+		var claims jwt.Claims
+		var extra jwtClaims
+		if err := parsed.Claims(key.Key, &claims, &extra); err != nil {
+			continue
+		}
+
+		expected := jwt.Expected{
+			Issuer: v.issuer,
+			Time:   time.Now(),
+		}
+		if v.audience != "" {
+			expected.Audience = jwt.Audience{v.audience}
+		}
+		if err := claims.ValidateWithLeeway(expected, jwt.DefaultLeeway); err != nil {
+			return nil, errors.Wrap(err, errors.KindUnauthenticated, "invalid_token", "invalid bearer token")
+		}
+
+		scopes := extra.Scopes
+		if extra.Scope != "" {
+			scopes = append(scopes, strings.Fields(extra.Scope)...)
+		}
+
+		return &Principal{
+			Subject: claims.Subject,
+			Method:  MethodJWT,
+			Roles:   extra.Roles,
+			Scopes:  scopes,
+		}, nil
+	}
+	return nil, errors.Unauthenticated("invalid_token", "bearer token signature verification failed")
+}
+
+func loadPublicKey(file string) (interface{}, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no pem data found in %s", file)
+	}
+
+	if block.Type == "CERTIFICATE" {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate %s: %v", file, err)
+		}
+		return cert.PublicKey, nil
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key %s: %v", file, err)
+	}
+	return key, nil
+}
@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+type testClaims struct {
+	jwt.Claims
+	jwtClaims
+}
+
+func generateKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// writePublicKey stores the public key of the private key as a pem file.
+func writePublicKey(t *testing.T, key *ecdsa.PrivateKey) string {
+	t.Helper()
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "key.pem")
+	if err := ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func signToken(t *testing.T, key *ecdsa.PrivateKey, keyID string, claims testClaims) string {
+	t.Helper()
+
+	opts := &jose.SignerOptions{}
+	if keyID != "" {
+		opts = opts.WithHeader("kid", keyID)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, opts.WithType("JWT"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestJwtAuthenticate(t *testing.T) {
+	key := generateKey(t)
+	verifier, err := newJwtVerifier("", []string{writePublicKey(t, key)}, "issuer", "audience")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	valid := jwt.Claims{
+		Subject:  "user-1",
+		Issuer:   "issuer",
+		Audience: jwt.Audience{"audience"},
+		Expiry:   jwt.NewNumericDate(now.Add(time.Hour)),
+	}
+	withClaims := func(update func(*jwt.Claims)) jwt.Claims {
+		claims := valid
+		update(&claims)
+		return claims
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		code   string
+		scopes []string
+	}{
+		{"valid", signToken(t, key, "", testClaims{valid, jwtClaims{Roles: []string{"admin"}}}), "", nil},
+		{"scope claims", signToken(t, key, "", testClaims{valid, jwtClaims{Scope: "read write", Scopes: []string{"admin"}}}), "", []string{"admin", "read", "write"}},
+		{"unknown key id", signToken(t, key, "other", testClaims{Claims: valid}), "", nil},
+		{"expired", signToken(t, key, "", testClaims{Claims: withClaims(func(c *jwt.Claims) { c.Expiry = jwt.NewNumericDate(now.Add(-time.Hour)) })}), "invalid_token", nil},
+		{"wrong issuer", signToken(t, key, "", testClaims{Claims: withClaims(func(c *jwt.Claims) { c.Issuer = "other" })}), "invalid_token", nil},
+		{"wrong audience", signToken(t, key, "", testClaims{Claims: withClaims(func(c *jwt.Claims) { c.Audience = jwt.Audience{"other"} })}), "invalid_token", nil},
+		{"signed by another key", signToken(t, generateKey(t), "", testClaims{Claims: valid}), "invalid_token", nil},
+		{"malformed", "not-a-token", "invalid_token", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			principal, err := verifier.authenticate(test.token)
+			if test.code != "" {
+				if e := errors.From(err); e.Kind != errors.KindUnauthenticated || e.Code != test.code {
+					t.Errorf("expected %s, got %v", test.code, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if principal.Subject != "user-1" || principal.Method != MethodJWT {
+				t.Errorf("unexpected principal %+v", principal)
+			}
+			if test.scopes != nil && !equalStrings(principal.Scopes, test.scopes) {
+				t.Errorf("expected scopes %v, got %v", test.scopes, principal.Scopes)
+			}
+		})
+	}
+}
+
+func TestJwtAuthenticateSelectsKeyByID(t *testing.T) {
+	first, second := generateKey(t), generateKey(t)
+	verifier := &jwtVerifier{keys: jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: &first.PublicKey, KeyID: "first"},
+		{Key: &second.PublicKey, KeyID: "second"},
+	}}}
+
+	claims := testClaims{Claims: jwt.Claims{Subject: "user-1", Expiry: jwt.NewNumericDate(time.Now().Add(time.Hour))}}
+	if _, err := verifier.authenticate(signToken(t, second, "second", claims)); err != nil {
+		t.Errorf("expected the token to be verified by its key, got %v", err)
+	}
+	if _, err := verifier.authenticate(signToken(t, second, "first", claims)); err == nil {
+		t.Error("expected a token signed by another key than its key id to be rejected")
+	}
+}
+
+func TestNewJwtVerifierWithoutKeys(t *testing.T) {
+	if _, err := newJwtVerifier("", nil, "", ""); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,20 @@
+package auth
+
+import (
+	"crypto/x509"
+
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
+)
+
+// authenticateCertificate identifies a client by a certificate already verified by the tls handshake.
+func authenticateCertificate(cert *x509.Certificate) (*Principal, error) {
+	if cert.Subject.CommonName == "" {
+		return nil, errors.Unauthenticated("invalid_certificate", "client certificate has no subject common name")
+	}
+
+	return &Principal{
+		Subject: cert.Subject.CommonName,
+		Method:  MethodMTLS,
+		Roles:   cert.Subject.OrganizationalUnit,
+	}, nil
+}
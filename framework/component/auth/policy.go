@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	policyAllow = "allow"
+	policyDeny  = "deny"
+)
+
+// Rule declares the roles and scopes required to access HTTP routes or RPC methods.
+// HTTP patterns are "METHOD /path" with glob path segments, RPC patterns are full method globs.
+type Rule struct {
+	HTTP   string   `yaml:"http,omitempty"`
+	RPC    string   `yaml:"rpc,omitempty"`
+	Roles  []string `yaml:"roles,omitempty"`
+	Scopes []string `yaml:"scopes,omitempty"`
+}
+
+type policyFile struct {
+	Default string `yaml:"default"`
+	Rules   []Rule `yaml:"rules"`
+}
+
+type policy struct {
+	rules         []Rule
+	denyByDefault bool
+	modTime       time.Time
+}
+
+func loadPolicy(file string) (*policy, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var config policyFile
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %v", file, err)
+	}
+
+	p := &policy{
+		rules:   config.Rules,
+		modTime: info.ModTime(),
+	}
+
+	switch config.Default {
+	case "", policyAllow:
+	case policyDeny:
+		p.denyByDefault = true
+	default:
+		return nil, fmt.Errorf("invalid policy file %s: unknown default %s", file, config.Default)
+	}
+
+	for _, rule := range p.rules {
+		if (rule.HTTP == "") == (rule.RPC == "") {
+			return nil, fmt.Errorf("invalid policy file %s: each rule needs exactly one of http or rpc", file)
+		}
+	}
+	return p, nil
+}
+
+func (r *Rule) matchHTTP(method, urlPath string) bool {
+	if r.HTTP == "" {
+		return false
+	}
+
+	parts := strings.Fields(r.HTTP)
+	if len(parts) != 2 || (parts[0] != "*" && !strings.EqualFold(parts[0], method)) {
+		return false
+	}
+	matched, _ := path.Match(parts[1], urlPath)
+	return matched
+}
+
+func (r *Rule) matchRPC(fullMethod string) bool {
+	if r.RPC == "" {
+		return false
+	}
+	matched, _ := path.Match(r.RPC, fullMethod)
+	return matched
+}
+
+// check verifies the principal holds one of the rule roles and all of its scopes.
+func (r *Rule) check(principal *Principal) error {
+	if len(r.Roles) == 0 && len(r.Scopes) == 0 {
+		return nil
+	}
+	if principal == nil {
+		return errors.Unauthenticated("missing_credentials", "authentication required")
+	}
+
+	if len(r.Roles) > 0 && !containsAny(principal.Roles, r.Roles) {
+		return errors.PermissionDenied("missing_role", "one of roles [%s] required", strings.Join(r.Roles, ", "))
+	}
+	for _, scope := range r.Scopes {
+		if !containsAny(principal.Scopes, []string{scope}) {
+			return errors.PermissionDenied("missing_scope", "scope %s required", scope)
+		}
+	}
+	return nil
+}
+
+func containsAny(values []string, candidates []string) bool {
+	for _, v := range values {
+		for _, c := range candidates {
+			if v == c {
+				return true
+			}
+		}
+	}
+	return false
+}
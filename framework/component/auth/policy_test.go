@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
+)
+
+func TestRuleMatch(t *testing.T) {
+	tests := []struct {
+		rule     Rule
+		method   string
+		path     string
+		expected bool
+	}{
+		{Rule{HTTP: "GET /messages/*"}, "GET", "/messages/42", true},
+		{Rule{HTTP: "get /messages/*"}, "GET", "/messages/42", true},
+		{Rule{HTTP: "* /messages/*"}, "DELETE", "/messages/42", true},
+		{Rule{HTTP: "GET /messages/*"}, "POST", "/messages/42", false},
+		{Rule{HTTP: "GET /messages/*"}, "GET", "/messages/42/events", false},
+		{Rule{HTTP: "GET /messages"}, "GET", "/messages/42", false},
+		{Rule{HTTP: "/messages/*"}, "GET", "/messages/42", false},
+		{Rule{RPC: "/producer.Producer/*"}, "", "/producer.Producer/Publish", true},
+		{Rule{RPC: "/producer.Producer/Get*"}, "", "/producer.Producer/Publish", false},
+		{Rule{RPC: "/producer.Producer/*"}, "", "/consumer.Consumer/Publish", false},
+	}
+
+	for _, test := range tests {
+		var matched bool
+		if test.method != "" {
+			matched = test.rule.matchHTTP(test.method, test.path)
+		} else {
+			matched = test.rule.matchRPC(test.path)
+		}
+		if matched != test.expected {
+			t.Errorf("%+v %s %s: expected %v, got %v", test.rule, test.method, test.path, test.expected, matched)
+		}
+	}
+}
+
+func TestRuleCheck(t *testing.T) {
+	principal := &Principal{Subject: "user-1", Roles: []string{"writer"}, Scopes: []string{"messages:read", "messages:write"}}
+
+	tests := []struct {
+		name      string
+		rule      Rule
+		principal *Principal
+		kind      errors.Kind
+		code      string
+	}{
+		{"public", Rule{}, nil, 0, ""},
+		{"anonymous", Rule{Roles: []string{"writer"}}, nil, errors.KindUnauthenticated, "missing_credentials"},
+		{"one of the roles", Rule{Roles: []string{"admin", "writer"}}, principal, 0, ""},
+		{"missing role", Rule{Roles: []string{"admin"}}, principal, errors.KindPermissionDenied, "missing_role"},
+		{"all of the scopes", Rule{Scopes: []string{"messages:read", "messages:write"}}, principal, 0, ""},
+		{"missing scope", Rule{Scopes: []string{"messages:read", "messages:delete"}}, principal, errors.KindPermissionDenied, "missing_scope"},
+		{"role and missing scope", Rule{Roles: []string{"writer"}, Scopes: []string{"messages:delete"}}, principal, errors.KindPermissionDenied, "missing_scope"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.rule.check(test.principal)
+			if test.code == "" {
+				if err != nil {
+					t.Errorf("expected access, got %v", err)
+				}
+				return
+			}
+			if e := errors.From(err); e.Kind != test.kind || e.Code != test.code {
+				t.Errorf("expected %s/%s, got %v", test.kind, test.code, err)
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	reader := &Principal{Subject: "reader", Roles: []string{"reader"}}
+	admin := &Principal{Subject: "admin", Roles: []string{"admin"}}
+
+	a := &authManager{
+		rules: []Rule{
+			{HTTP: "GET /messages/*", Roles: []string{"reader", "admin"}},
+			{RPC: "/producer.Producer/*", Roles: []string{"admin"}},
+		},
+		policy: &policy{
+			rules:         []Rule{{HTTP: "GET /messages/*", Roles: []string{"admin"}}},
+			denyByDefault: true,
+		},
+	}
+
+	// The policy file rule takes precedence over the one of the service
+	if err := a.AuthorizeHTTP("GET", "/messages/42", reader); errors.KindOf(err) != errors.KindPermissionDenied {
+		t.Errorf("expected the policy file rule to deny access, got %v", err)
+	}
+	if err := a.AuthorizeHTTP("GET", "/messages/42", admin); err != nil {
+		t.Errorf("expected access, got %v", err)
+	}
+	if err := a.AuthorizeRPC("/producer.Producer/Publish", admin); err != nil {
+		t.Errorf("expected access, got %v", err)
+	}
+	if err := a.AuthorizeHTTP("DELETE", "/messages/42", admin); errors.From(err).Code != "access_denied" {
+		t.Errorf("expected the default policy to deny access, got %v", err)
+	}
+
+	a.policy = nil
+	if err := a.AuthorizeHTTP("GET", "/messages/42", reader); err != nil {
+		t.Errorf("expected access, got %v", err)
+	}
+	if err := a.AuthorizeHTTP("DELETE", "/messages/42", nil); err != nil {
+		t.Errorf("expected unmatched requests to be allowed, got %v", err)
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	p, err := loadPolicy(writeFile(t, "policy.yaml", `
+default: deny
+rules:
+  - http: GET /messages/*
+    roles: [reader]
+  - rpc: /producer.Producer/*
+    scopes: [messages:write]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.denyByDefault || len(p.rules) != 2 || p.rules[1].Scopes[0] != "messages:write" {
+		t.Errorf("unexpected policy %+v", p)
+	}
+
+	for name, content := range map[string]string{
+		"unknown default":      "default: maybe\n",
+		"http and rpc":         "rules:\n  - http: GET /messages\n    rpc: /producer.Producer/*\n",
+		"neither http nor rpc": "rules:\n  - roles: [reader]\n",
+		"invalid yaml":         "rules: [",
+	} {
+		if _, err := loadPolicy(writeFile(t, "policy.yaml", content)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestReloadPolicy(t *testing.T) {
+	file := writeFile(t, "policy.yaml", "default: allow\n")
+	p, err := loadPolicy(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := zerolog.Nop()
+	a := &authManager{logger: &logger, policyFile: file, policy: p}
+
+	// An invalid policy keeps the current one in place
+	modTime := p.modTime.Add(time.Second)
+	if err := ioutil.WriteFile(file, []byte("default: maybe\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(file, modTime, modTime)
+	a.reloadPolicy()
+	if a.policy != p {
+		t.Fatal("expected the invalid policy to be ignored")
+	}
+
+	modTime = modTime.Add(time.Second)
+	if err := ioutil.WriteFile(file, []byte("default: deny\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(file, modTime, modTime)
+	a.reloadPolicy()
+	if !a.policy.denyByDefault {
+		t.Error("expected the policy to be reloaded")
+	}
+}
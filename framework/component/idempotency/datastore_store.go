@@ -0,0 +1,83 @@
+package idempotency
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/cloudstore"
+)
+
+const maxBatchSize = 500
+
+// datastoreStore keeps the idempotency records in the datastore, shared by all replicas.
+type datastoreStore struct {
+	cloudstore cloudstore.Store
+	kind       string
+}
+
+func newDatastoreStore(cloudstore cloudstore.Store, kind string) *datastoreStore {
+	return &datastoreStore{
+		cloudstore: cloudstore,
+		kind:       kind,
+	}
+}
+
+func (s *datastoreStore) Reserve(record *Record, now time.Time) (*Record, error) {
+	client := s.cloudstore.Client()
+	key := datastore.NameKey(s.kind, record.Key, nil)
+
+	var existing *Record
+	_, err := client.RunInTransaction(context.Background(), func(tx *datastore.Transaction) error {
+		existing = nil
+
+		var current Record
+		err := tx.Get(key, &current)
+		switch {
+		case err == datastore.ErrNoSuchEntity:
+		case err != nil:
+			return err
+		case !current.expired(now):
+			current.Key = record.Key
+			existing = &current
+			return nil
+		}
+
+		_, err = tx.Put(key, record)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return existing, nil
+}
+
+func (s *datastoreStore) Save(record *Record) error {
+	client := s.cloudstore.Client()
+
+	_, err := client.Put(context.Background(), datastore.NameKey(s.kind, record.Key, nil), record)
+	return err
+}
+
+func (s *datastoreStore) Delete(key string) error {
+	client := s.cloudstore.Client()
+	return client.Delete(context.Background(), datastore.NameKey(s.kind, key, nil))
+}
+
+func (s *datastoreStore) Sweep(now time.Time) error {
+	client := s.cloudstore.Client()
+
+	query := datastore.NewQuery(s.kind).
+		Filter("ExpiresAt <=", now).
+		KeysOnly().
+		Limit(maxBatchSize)
+
+	keys, err := client.GetAll(context.Background(), query, nil)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return client.DeleteMulti(context.Background(), keys)
+}
@@ -0,0 +1,264 @@
+package idempotency
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/rs/zerolog"
+	"github.com/ubiqueworks/go-clean-architecture/framework"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/cloudstore"
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
+	"gopkg.in/urfave/cli.v1"
+)
+
+const (
+	Component = "idempotency"
+
+	StoreMemory    = "memory"
+	StoreDatastore = "datastore"
+
+	maxKeyLength  = 255
+	sweepInterval = 5 * time.Minute
+
+	envIdempotencyStore         = "IDEMPOTENCY_STORE"
+	envIdempotencyTTL           = "IDEMPOTENCY_TTL"
+	envIdempotencyLockTimeout   = "IDEMPOTENCY_LOCK_TIMEOUT"
+	envIdempotencyDatastoreKind = "IDEMPOTENCY_DATASTORE_KIND"
+
+	flagIdempotencyStore         = "idempotency-store"
+	flagIdempotencyTTL           = "idempotency-ttl"
+	flagIdempotencyLockTimeout   = "idempotency-lock-timeout"
+	flagIdempotencyDatastoreKind = "idempotency-datastore-kind"
+)
+
+var cliFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:   flagIdempotencyStore,
+		EnvVar: envIdempotencyStore,
+		Value:  StoreMemory,
+		Usage:  "idempotency record store (memory, datastore)",
+	},
+	cli.DurationFlag{
+		Name:   flagIdempotencyTTL,
+		EnvVar: envIdempotencyTTL,
+		Value:  24 * time.Hour,
+		Usage:  "how long responses are replayed for retries with the same idempotency key",
+	},
+	cli.DurationFlag{
+		Name:   flagIdempotencyLockTimeout,
+		EnvVar: envIdempotencyLockTimeout,
+		Value:  time.Minute,
+		Usage:  "how long an idempotency key stays locked by a request that didn't complete",
+	},
+	cli.StringFlag{
+		Name:   flagIdempotencyDatastoreKind,
+		EnvVar: envIdempotencyDatastoreKind,
+		Value:  "IdempotencyRecord",
+		Usage:  "datastore kind of the idempotency records",
+	},
+}
+
+// Create returns the idempotency component for the RPC methods matching the full method globs.
+// The datastore store requires the cloudstore component.
+func Create(methods ...string) framework.Component {
+	return &guard{
+		methods: methods,
+	}
+}
+
+func Get(service framework.Service) (Guard, error) {
+	component, err := service.Component(Component)
+	if err != nil {
+		return nil, err
+	}
+	return component.(Guard), nil
+}
+
+type Guard interface {
+	// Match reports whether calls to the RPC method honour idempotency keys.
+	Match(fullMethod string) bool
+
+	// Execute runs fn once per scope and key. Retries with the same request get the recorded
+	// response back, flagged as replayed, while different requests under the same key conflict.
+	Execute(scope, key string, request []byte, fn func() (proto.Message, error)) (proto.Message, bool, error)
+}
+
+type guard struct {
+	logger      *zerolog.Logger
+	methods     []string
+	storeType   string
+	store       Store
+	ttl         time.Duration
+	lockTimeout time.Duration
+}
+
+func (g *guard) Match(fullMethod string) bool {
+	for _, pattern := range g.methods {
+		if matched, _ := path.Match(pattern, fullMethod); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *guard) Execute(scope, key string, request []byte, fn func() (proto.Message, error)) (proto.Message, bool, error) {
+	if len(key) > maxKeyLength {
+		return nil, false, errors.InvalidArgument("invalid_idempotency_key", "idempotency key longer than %d characters", maxKeyLength)
+	}
+
+	recordKey := hash(scope + "|" + key)
+	fingerprint := hash(string(request))
+
+	now := time.Now()
+	existing, err := g.store.Reserve(&Record{
+		Key:         recordKey,
+		Fingerprint: fingerprint,
+		ExpiresAt:   now.Add(g.lockTimeout),
+	}, now)
+	if err != nil {
+		return nil, false, errors.Wrap(err, errors.KindUnavailable, "idempotency_unavailable", "error reserving idempotency key")
+	}
+
+	if existing != nil {
+		switch {
+		case existing.Fingerprint != fingerprint:
+			return nil, false, errors.Conflict("idempotency_key_reused", "idempotency key already used for a different request")
+		case !existing.Completed:
+			return nil, false, errors.Conflict("request_in_progress", "a request with the same idempotency key is in progress")
+		}
+
+		resp, err := decodeResponse(existing)
+		if err != nil {
+			return nil, false, errors.Wrap(err, errors.KindInternal, "invalid_idempotency_record", "error decoding recorded response")
+		}
+		return resp, true, nil
+	}
+
+	resp, err := fn()
+	if err != nil {
+		// Failed requests release the key so that they can be retried
+		if deleteErr := g.store.Delete(recordKey); deleteErr != nil {
+			g.logger.Error().Err(deleteErr).Msg("error releasing idempotency key")
+		}
+		return nil, false, err
+	}
+
+	record := &Record{
+		Key:         recordKey,
+		Fingerprint: fingerprint,
+		Completed:   true,
+		ExpiresAt:   time.Now().Add(g.ttl),
+	}
+	if resp != nil {
+		data, err := proto.Marshal(resp)
+		if err != nil {
+			return nil, false, err
+		}
+		record.ResponseType = proto.MessageName(resp)
+		record.Response = data
+	}
+
+	// The request succeeded, a failure to record it only affects retries
+	if err := g.store.Save(record); err != nil {
+		g.logger.Error().Err(err).Msg("error recording idempotent response")
+	}
+	return resp, false, nil
+}
+
+func decodeResponse(record *Record) (proto.Message, error) {
+	if record.ResponseType == "" {
+		return nil, nil
+	}
+
+	t := proto.MessageType(record.ResponseType)
+	if t == nil {
+		return nil, fmt.Errorf("unknown response type %s", record.ResponseType)
+	}
+
+	resp := reflect.New(t.Elem()).Interface().(proto.Message)
+	if err := proto.Unmarshal(record.Response, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func hash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+func (g *guard) ID() string {
+	return Component
+}
+
+func (g *guard) DependsOn() []string {
+	if g.storeType == StoreDatastore {
+		return []string{cloudstore.Component}
+	}
+	return nil
+}
+
+func (g *guard) Flags() []cli.Flag {
+	return cliFlags
+}
+
+func (g *guard) Logger() *zerolog.Logger {
+	return g.logger
+}
+
+func (g *guard) Configure(service framework.Service, cliCtx *cli.Context) error {
+	logger := service.Logger().With().Str("component", Component).Logger()
+	g.logger = &logger
+
+	g.ttl = cliCtx.Duration(flagIdempotencyTTL)
+	g.lockTimeout = cliCtx.Duration(flagIdempotencyLockTimeout)
+	if g.ttl <= 0 || g.lockTimeout <= 0 {
+		return fmt.Errorf("invalid idempotency ttl %v or lock timeout %v", g.ttl, g.lockTimeout)
+	}
+
+	g.storeType = cliCtx.String(flagIdempotencyStore)
+	switch g.storeType {
+	case StoreMemory:
+		g.store = newMemoryStore()
+	case StoreDatastore:
+		store, err := cloudstore.Get(service)
+		if err != nil {
+			return fmt.Errorf("datastore idempotency store requires the %s component", cloudstore.Component)
+		}
+		g.store = newDatastoreStore(store, cliCtx.String(flagIdempotencyDatastoreKind))
+	default:
+		return fmt.Errorf("invalid idempotency store: %s", g.storeType)
+	}
+	return nil
+}
+
+func (g *guard) Initialize(wg *sync.WaitGroup, startedCh chan<- struct{}, shutdownCh <-chan struct{}, errCh chan<- error) {
+	defer wg.Done()
+
+	g.logger.Info().
+		Strs("methods", g.methods).
+		Str("store", g.storeType).
+		Dur("ttl", g.ttl).
+		Msg("idempotency keys enabled")
+	close(startedCh)
+
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := g.store.Sweep(time.Now()); err != nil {
+				g.logger.Error().Err(err).Msg("error deleting expired idempotency records")
+			}
+		case <-shutdownCh:
+			return
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package idempotency
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/duration"
+	"github.com/rs/zerolog"
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
+)
+
+func newTestGuard(methods ...string) *guard {
+	logger := zerolog.Nop()
+	return &guard{
+		logger:      &logger,
+		methods:     methods,
+		store:       newMemoryStore(),
+		ttl:         time.Hour,
+		lockTimeout: time.Minute,
+	}
+}
+
+func TestMatch(t *testing.T) {
+	g := newTestGuard("/producer.Producer/Publish*")
+
+	for method, expected := range map[string]bool{
+		"/producer.Producer/Publish":      true,
+		"/producer.Producer/PublishBatch": true,
+		"/producer.Producer/GetMessage":   false,
+		"/consumer.Consumer/Publish":      false,
+	} {
+		if matched := g.Match(method); matched != expected {
+			t.Errorf("%s: expected %v, got %v", method, expected, matched)
+		}
+	}
+}
+
+func TestExecute(t *testing.T) {
+	g := newTestGuard()
+
+	calls := 0
+	fn := func() (proto.Message, error) {
+		calls++
+		return &duration.Duration{Seconds: int64(calls)}, nil
+	}
+
+	tests := []struct {
+		name     string
+		scope    string
+		key      string
+		request  string
+		seconds  int64
+		replayed bool
+		code     string
+	}{
+		{"first request", "user-1", "key-1", "request-1", 1, false, ""},
+		{"retry", "user-1", "key-1", "request-1", 1, true, ""},
+		{"different request", "user-1", "key-1", "request-2", 0, false, "idempotency_key_reused"},
+		{"other key", "user-1", "key-2", "request-1", 2, false, ""},
+		{"other scope", "user-2", "key-1", "request-1", 3, false, ""},
+		{"key too long", "user-1", strings.Repeat("k", maxKeyLength+1), "request-1", 0, false, "invalid_idempotency_key"},
+	}
+
+	for _, test := range tests {
+		resp, replayed, err := g.Execute(test.scope, test.key, []byte(test.request), fn)
+		if test.code != "" {
+			if errors.From(err).Code != test.code {
+				t.Errorf("%s: expected %s, got %v", test.name, test.code, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if resp.(*duration.Duration).Seconds != test.seconds || replayed != test.replayed {
+			t.Errorf("%s: expected %d replayed %v, got %v replayed %v", test.name, test.seconds, test.replayed, resp, replayed)
+		}
+	}
+	if calls != 3 {
+		t.Errorf("expected 3 calls, got %d", calls)
+	}
+}
+
+func TestExecuteInProgress(t *testing.T) {
+	g := newTestGuard()
+
+	_, _, err := g.Execute("user-1", "key-1", []byte("request-1"), func() (proto.Message, error) {
+		_, _, err := g.Execute("user-1", "key-1", []byte("request-1"), func() (proto.Message, error) {
+			t.Error("concurrent request executed")
+			return nil, nil
+		})
+		if e := errors.From(err); e.Kind != errors.KindConflict || e.Code != "request_in_progress" {
+			t.Errorf("expected the request to be in progress, got %v", err)
+		}
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExecuteReleasesFailedRequests(t *testing.T) {
+	g := newTestGuard()
+
+	_, _, err := g.Execute("user-1", "key-1", []byte("request-1"), func() (proto.Message, error) {
+		return nil, errors.Unavailable("down", "temporarily unavailable")
+	})
+	if errors.From(err).Code != "down" {
+		t.Fatalf("expected the request error, got %v", err)
+	}
+
+	resp, replayed, err := g.Execute("user-1", "key-1", []byte("request-1"), func() (proto.Message, error) {
+		return &duration.Duration{Seconds: 1}, nil
+	})
+	if err != nil || replayed || resp == nil {
+		t.Errorf("expected the failed request to be executed again, got %v %v %v", resp, replayed, err)
+	}
+}
+
+func TestExecuteReplaysEmptyResponses(t *testing.T) {
+	g := newTestGuard()
+
+	for n := 0; n < 2; n++ {
+		resp, replayed, err := g.Execute("user-1", "key-1", []byte("request-1"), func() (proto.Message, error) {
+			return nil, nil
+		})
+		if err != nil || resp != nil || replayed != (n == 1) {
+			t.Errorf("request %d: unexpected %v %v %v", n, resp, replayed, err)
+		}
+	}
+}
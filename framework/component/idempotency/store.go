@@ -0,0 +1,79 @@
+package idempotency
+
+import (
+	"sync"
+	"time"
+)
+
+// Record tracks a request made with an idempotency key, pending until its response is recorded.
+type Record struct {
+	Key          string `datastore:"-"`
+	Fingerprint  string `datastore:",noindex"`
+	Completed    bool   `datastore:",noindex"`
+	ResponseType string `datastore:",noindex"`
+	Response     []byte `datastore:",noindex"`
+	ExpiresAt    time.Time
+}
+
+func (r *Record) expired(now time.Time) bool {
+	return !now.Before(r.ExpiresAt)
+}
+
+type Store interface {
+	// Reserve atomically saves the record unless an unexpired one exists for its key, which is returned instead.
+	Reserve(record *Record, now time.Time) (*Record, error)
+	Save(record *Record) error
+	Delete(key string) error
+	// Sweep deletes the records expired at now.
+	Sweep(now time.Time) error
+}
+
+type memoryStore struct {
+	lock    sync.Mutex
+	records map[string]Record
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		records: make(map[string]Record),
+	}
+}
+
+func (s *memoryStore) Reserve(record *Record, now time.Time) (*Record, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if existing, exists := s.records[record.Key]; exists && !existing.expired(now) {
+		return &existing, nil
+	}
+	s.records[record.Key] = *record
+	return nil, nil
+}
+
+func (s *memoryStore) Save(record *Record) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.records[record.Key] = *record
+	return nil
+}
+
+func (s *memoryStore) Delete(key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.records, key)
+	return nil
+}
+
+func (s *memoryStore) Sweep(now time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for key, record := range s.records {
+		if record.expired(now) {
+			delete(s.records, key)
+		}
+	}
+	return nil
+}
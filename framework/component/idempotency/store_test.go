@@ -0,0 +1,51 @@
+package idempotency
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	store := newMemoryStore()
+	now := time.Now()
+
+	pending := &Record{Key: "key", Fingerprint: "request-1", ExpiresAt: now.Add(time.Minute)}
+	if existing, err := store.Reserve(pending, now); err != nil || existing != nil {
+		t.Fatalf("expected the key to be reserved, got %+v %v", existing, err)
+	}
+
+	existing, err := store.Reserve(&Record{Key: "key", Fingerprint: "request-2", ExpiresAt: now.Add(time.Minute)}, now)
+	if err != nil || existing == nil || existing.Fingerprint != "request-1" {
+		t.Fatalf("expected the pending record, got %+v %v", existing, err)
+	}
+
+	// Expired records are replaced
+	if existing, _ := store.Reserve(&Record{Key: "key", Fingerprint: "request-2", ExpiresAt: now.Add(time.Hour)}, now.Add(time.Minute)); existing != nil {
+		t.Errorf("expected the expired record to be replaced, got %+v", existing)
+	}
+
+	if err := store.Delete("key"); err != nil {
+		t.Fatal(err)
+	}
+	if existing, _ := store.Reserve(pending, now); existing != nil {
+		t.Errorf("expected the deleted key to be reserved again, got %+v", existing)
+	}
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	store := newMemoryStore()
+	now := time.Now()
+
+	store.Save(&Record{Key: "expired", ExpiresAt: now})
+	store.Save(&Record{Key: "pending", ExpiresAt: now.Add(time.Minute)})
+
+	if err := store.Sweep(now); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := store.records["expired"]; exists {
+		t.Error("expected the expired record to be deleted")
+	}
+	if _, exists := store.records["pending"]; !exists {
+		t.Error("expected the pending record to be kept")
+	}
+}
@@ -0,0 +1,116 @@
+package inbox
+
+import (
+	"flag"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/duration"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/rs/zerolog"
+	"github.com/ubiqueworks/go-clean-architecture/framework"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/natsbroker"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// testService provides the logger and name components are configured with.
+type testService struct {
+	framework.Service
+	logger zerolog.Logger
+}
+
+func (s *testService) Logger() *zerolog.Logger {
+	return &s.logger
+}
+
+func (s *testService) Name() string {
+	return "test"
+}
+
+// startBroker configures and starts a broker connected to an embedded server, with the given command line arguments.
+func startBroker(t *testing.T, args ...string) natsbroker.Broker {
+	t.Helper()
+
+	srv, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server not ready")
+	}
+	t.Cleanup(srv.Shutdown)
+
+	component := natsbroker.Create()
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	for _, f := range component.Flags() {
+		f.Apply(set)
+	}
+	if err := set.Parse(append([]string{"--nats-url", srv.ClientURL()}, args...)); err != nil {
+		t.Fatal(err)
+	}
+	if err := component.Configure(&testService{logger: zerolog.Nop()}, cli.NewContext(nil, set, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	startedCh := make(chan struct{})
+	shutdownCh := make(chan struct{})
+	errCh := make(chan error, 1)
+	wg.Add(1)
+	go component.Initialize(&wg, startedCh, shutdownCh, errCh)
+	select {
+	case <-startedCh:
+	case err := <-errCh:
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		close(shutdownCh)
+		wg.Wait()
+	})
+	return component.(natsbroker.Broker)
+}
+
+func TestConcurrentDuplicatesAreNotDeadLettered(t *testing.T) {
+	broker := startBroker(t, "--nats-max-attempts", "3", "--nats-retry-backoff", "10ms", "--nats-retry-max-backoff", "20ms")
+	i := newTestInbox(newMemoryStore(10))
+
+	dlq, err := broker.Client().SubscribeSync(natsbroker.DeadLetterSubject("test.events"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handled := make(chan struct{}, 2)
+	handler := i.Wrap(func(msg *natsbroker.Message) error {
+		// Outlasts the retries of the duplicate
+		time.Sleep(300 * time.Millisecond)
+		handled <- struct{}{}
+		return nil
+	}, nil)
+	factory := func() proto.Message { return &duration.Duration{} }
+	if _, err := broker.Subscribe("test.events", factory, handler, natsbroker.WithWorkers(2)); err != nil {
+		t.Fatal(err)
+	}
+
+	for n := 0; n < 2; n++ {
+		if err := broker.Publish("test.events", &duration.Duration{Seconds: 1}, natsbroker.WithEventID("event-1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not handled")
+	}
+	select {
+	case <-handled:
+		t.Error("duplicate handled")
+	case <-time.After(500 * time.Millisecond):
+	}
+	if msg, err := dlq.NextMsg(100 * time.Millisecond); err == nil {
+		t.Errorf("duplicate dead-lettered: %v", msg.Header)
+	}
+}
@@ -0,0 +1,205 @@
+package inbox
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/ubiqueworks/go-clean-architecture/framework"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/natsbroker"
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
+	"gopkg.in/urfave/cli.v1"
+)
+
+const (
+	Component = "inbox"
+
+	StoreMemory = "memory"
+	StoreNats   = "nats"
+
+	envInboxStore      = "INBOX_STORE"
+	envInboxTTL        = "INBOX_TTL"
+	envInboxLease      = "INBOX_LEASE"
+	envInboxSize       = "INBOX_SIZE"
+	envInboxNatsBucket = "INBOX_NATS_BUCKET"
+
+	flagInboxStore      = "inbox-store"
+	flagInboxTTL        = "inbox-ttl"
+	flagInboxLease      = "inbox-lease"
+	flagInboxSize       = "inbox-size"
+	flagInboxNatsBucket = "inbox-nats-bucket"
+)
+
+var cliFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:   flagInboxStore,
+		EnvVar: envInboxStore,
+		Value:  StoreMemory,
+		Usage:  "processed message store (memory, nats), nats shares the inbox across replicas",
+	},
+	cli.DurationFlag{
+		Name:   flagInboxTTL,
+		EnvVar: envInboxTTL,
+		Value:  24 * time.Hour,
+		Usage:  "how long processed message ids are remembered",
+	},
+	cli.DurationFlag{
+		Name:   flagInboxLease,
+		EnvVar: envInboxLease,
+		Value:  time.Minute,
+		Usage:  "how long a message id stays locked by a handler that didn't complete",
+	},
+	cli.IntFlag{
+		Name:   flagInboxSize,
+		EnvVar: envInboxSize,
+		Value:  100000,
+		Usage:  "maximum number of message ids remembered by the memory store",
+	},
+	cli.StringFlag{
+		Name:   flagInboxNatsBucket,
+		EnvVar: envInboxNatsBucket,
+		Value:  "inbox",
+		Usage:  "nats key-value bucket holding the processed message ids",
+	},
+}
+
+// KeyFunc returns the id identifying the duplicates of a message.
+type KeyFunc func(msg *natsbroker.Message) string
+
+// Create returns the inbox component. The nats store requires the nats broker component.
+func Create() framework.Component {
+	return &inbox{}
+}
+
+func Get(service framework.Service) (Inbox, error) {
+	component, err := service.Component(Component)
+	if err != nil {
+		return nil, err
+	}
+	return component.(Inbox), nil
+}
+
+type Inbox interface {
+	// Wrap skips the messages already processed by the handler. Message ids are returned by the key function,
+	// or the event id of the envelope when it returns an empty id. Messages without id are always handled.
+	Wrap(handler natsbroker.MessageHandler, key KeyFunc) natsbroker.MessageHandler
+}
+
+type inbox struct {
+	logger    *zerolog.Logger
+	scope     string
+	storeType string
+	store     Store
+	ttl       time.Duration
+	lease     time.Duration
+}
+
+func (i *inbox) Wrap(handler natsbroker.MessageHandler, key KeyFunc) natsbroker.MessageHandler {
+	return func(msg *natsbroker.Message) error {
+		id := messageID(msg, key)
+		if id == "" {
+			return handler(msg)
+		}
+
+		// Ids are scoped to the service and the subject, services consuming the same message each process it once
+		scoped := i.scope + "|" + msg.Subject + "|" + id
+
+		reserved, completed, err := i.store.Reserve(scoped, i.lease)
+		switch {
+		case err != nil:
+			// Delivery is at least once anyway, the message is handled rather than failed while the store is down
+			i.logger.Warn().Err(err).Str("subject", msg.Subject).Str("message_id", id).Msg("inbox unavailable, handling message without deduplication")
+			return handler(msg)
+		case completed:
+			i.logger.Debug().Str("subject", msg.Subject).Str("message_id", id).Msg("duplicate message skipped")
+			return nil
+		case !reserved:
+			// Waiting for the duplicate being handled doesn't use up the attempts of the message
+			return natsbroker.Defer(errors.Unavailable("message_in_progress", "message %s is being processed", id))
+		}
+
+		if err := handler(msg); err != nil {
+			if releaseErr := i.store.Release(scoped); releaseErr != nil {
+				i.logger.Warn().Err(releaseErr).Str("message_id", id).Msg("error releasing message id")
+			}
+			return err
+		}
+
+		if err := i.store.Complete(scoped, i.ttl); err != nil {
+			i.logger.Warn().Err(err).Str("message_id", id).Msg("error recording processed message")
+		}
+		return nil
+	}
+}
+
+func messageID(msg *natsbroker.Message, key KeyFunc) string {
+	if key != nil {
+		if id := key(msg); id != "" {
+			return id
+		}
+	}
+	return msg.Event.ID
+}
+
+func (i *inbox) ID() string {
+	return Component
+}
+
+func (i *inbox) DependsOn() []string {
+	if i.storeType == StoreNats {
+		return []string{natsbroker.Component}
+	}
+	return nil
+}
+
+func (i *inbox) Flags() []cli.Flag {
+	return cliFlags
+}
+
+func (i *inbox) Logger() *zerolog.Logger {
+	return i.logger
+}
+
+func (i *inbox) Configure(service framework.Service, cliCtx *cli.Context) error {
+	logger := service.Logger().With().Str("component", Component).Logger()
+	i.logger = &logger
+	i.scope = service.Name()
+
+	i.ttl = cliCtx.Duration(flagInboxTTL)
+	i.lease = cliCtx.Duration(flagInboxLease)
+	if i.ttl <= 0 || i.lease <= 0 {
+		return fmt.Errorf("invalid inbox ttl %v or lease %v", i.ttl, i.lease)
+	}
+
+	i.storeType = cliCtx.String(flagInboxStore)
+	switch i.storeType {
+	case StoreMemory:
+		size := cliCtx.Int(flagInboxSize)
+		if size < 1 {
+			return fmt.Errorf("invalid inbox size: %d", size)
+		}
+		i.store = newMemoryStore(size)
+	case StoreNats:
+		broker, err := natsbroker.Get(service)
+		if err != nil {
+			return fmt.Errorf("nats inbox store requires the %s component", natsbroker.Component)
+		}
+		i.store = newNatsStore(broker, cliCtx.String(flagInboxNatsBucket), i.ttl)
+	default:
+		return fmt.Errorf("invalid inbox store: %s", i.storeType)
+	}
+	return nil
+}
+
+func (i *inbox) Initialize(wg *sync.WaitGroup, startedCh chan<- struct{}, shutdownCh <-chan struct{}, errCh chan<- error) {
+	defer wg.Done()
+
+	i.logger.Info().
+		Str("store", i.storeType).
+		Dur("ttl", i.ttl).
+		Msg("message deduplication enabled")
+	close(startedCh)
+
+	<-shutdownCh
+}
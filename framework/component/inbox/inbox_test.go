@@ -0,0 +1,257 @@
+package inbox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/natsbroker"
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
+)
+
+// testBroker hands the connection to an embedded JetStream server to the nats store.
+type testBroker struct {
+	natsbroker.Broker
+	client *nats.Conn
+}
+
+func (b *testBroker) Client() *nats.Conn {
+	return b.client
+}
+
+func newTestNatsStore(t *testing.T) *natsStore {
+	t.Helper()
+
+	srv, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		NoLog:     true,
+		NoSigs:    true,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server not ready")
+	}
+	t.Cleanup(srv.Shutdown)
+
+	client, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+
+	return newNatsStore(&testBroker{client: client}, "inbox", time.Hour)
+}
+
+func newTestInbox(store Store) *inbox {
+	logger := zerolog.Nop()
+	return &inbox{
+		logger: &logger,
+		scope:  "test",
+		store:  store,
+		ttl:    time.Hour,
+		lease:  time.Minute,
+	}
+}
+
+func testMessage(id string) *natsbroker.Message {
+	return &natsbroker.Message{
+		Subject: "test.events",
+		Event:   natsbroker.Event{ID: id},
+	}
+}
+
+func TestStores(t *testing.T) {
+	stores := []struct {
+		name  string
+		store func(t *testing.T) Store
+	}{
+		{"memory", func(t *testing.T) Store { return newMemoryStore(10) }},
+		{"nats", func(t *testing.T) Store { return newTestNatsStore(t) }},
+	}
+
+	for _, test := range stores {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			store := test.store(t)
+
+			reserved, completed, err := store.Reserve("key", time.Minute)
+			if err != nil || !reserved || completed {
+				t.Fatalf("expected the key to be reserved, got %v %v %v", reserved, completed, err)
+			}
+
+			// A key locked by another handler can't be reserved until released
+			if reserved, completed, _ := store.Reserve("key", time.Minute); reserved || completed {
+				t.Errorf("expected the key to be in progress, got %v %v", reserved, completed)
+			}
+			if err := store.Release("key"); err != nil {
+				t.Fatal(err)
+			}
+			if reserved, _, _ := store.Reserve("key", time.Minute); !reserved {
+				t.Error("expected the released key to be reserved again")
+			}
+
+			if err := store.Complete("key", time.Hour); err != nil {
+				t.Fatal(err)
+			}
+			if reserved, completed, _ := store.Reserve("key", time.Minute); reserved || !completed {
+				t.Errorf("expected the key to be completed, got %v %v", reserved, completed)
+			}
+
+			// Expired leases are taken over
+			if reserved, _, _ := store.Reserve("expired", time.Millisecond); !reserved {
+				t.Fatal("expected the key to be reserved")
+			}
+			time.Sleep(10 * time.Millisecond)
+			if reserved, _, _ := store.Reserve("expired", time.Minute); !reserved {
+				t.Error("expected the expired lease to be taken over")
+			}
+		})
+	}
+}
+
+func TestMemoryStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	store := newMemoryStore(2)
+	for _, key := range []string{"a", "b"} {
+		store.Reserve(key, time.Minute)
+		store.Complete(key, time.Hour)
+	}
+
+	// Looking up a makes b the least recently used key
+	store.Reserve("a", time.Minute)
+	store.Reserve("c", time.Minute)
+
+	if _, completed, _ := store.Reserve("a", time.Minute); !completed {
+		t.Error("expected a to be kept")
+	}
+	if reserved, _, _ := store.Reserve("b", time.Minute); !reserved {
+		t.Error("expected b to be evicted")
+	}
+}
+
+func TestWrapSkipsDuplicateEvents(t *testing.T) {
+	i := newTestInbox(newMemoryStore(10))
+
+	var handled []string
+	handler := i.Wrap(func(msg *natsbroker.Message) error {
+		handled = append(handled, msg.Event.ID)
+		return nil
+	}, nil)
+
+	for _, id := range []string{"event-1", "event-2", "event-1"} {
+		if err := handler(testMessage(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(handled) != 2 || handled[0] != "event-1" || handled[1] != "event-2" {
+		t.Errorf("expected each event to be handled once, got %v", handled)
+	}
+
+	// The same event received on another subject is handled again
+	msg := testMessage("event-1")
+	msg.Subject = "test.other"
+	if err := handler(msg); err != nil {
+		t.Fatal(err)
+	}
+	if len(handled) != 3 {
+		t.Errorf("expected the event to be handled on another subject, got %v", handled)
+	}
+}
+
+func TestWrapHandlesMessagesWithoutID(t *testing.T) {
+	i := newTestInbox(newMemoryStore(10))
+
+	calls := 0
+	handler := i.Wrap(func(msg *natsbroker.Message) error {
+		calls++
+		return nil
+	}, nil)
+
+	handler(testMessage(""))
+	handler(testMessage(""))
+	if calls != 2 {
+		t.Errorf("expected 2 calls, got %d", calls)
+	}
+}
+
+func TestWrapReleasesFailedMessages(t *testing.T) {
+	i := newTestInbox(newMemoryStore(10))
+
+	calls := 0
+	handler := i.Wrap(func(msg *natsbroker.Message) error {
+		calls++
+		if calls == 1 {
+			return errors.Unavailable("down", "temporarily unavailable")
+		}
+		return nil
+	}, nil)
+
+	if err := handler(testMessage("event-1")); err == nil {
+		t.Fatal("expected the handler error")
+	}
+	if err := handler(testMessage("event-1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := handler(testMessage("event-1")); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("expected the failed message to be handled again once, got %d calls", calls)
+	}
+}
+
+func TestWrapRetriesMessagesInProgress(t *testing.T) {
+	i := newTestInbox(newMemoryStore(10))
+	i.store.Reserve("test|test.events|event-1", time.Minute)
+
+	handler := i.Wrap(func(msg *natsbroker.Message) error {
+		t.Error("message in progress handled")
+		return nil
+	}, nil)
+
+	err := errors.From(handler(testMessage("event-1")))
+	if err.Kind != errors.KindUnavailable || err.Code != "message_in_progress" {
+		t.Errorf("expected the message to be retried, got %+v", err)
+	}
+}
+
+// failingStore is an inbox store that can't be reached.
+type failingStore struct{}
+
+func (failingStore) Reserve(string, time.Duration) (bool, bool, error) {
+	return false, false, errors.Unavailable("down", "store unavailable")
+}
+
+func (failingStore) Complete(string, time.Duration) error {
+	return errors.Unavailable("down", "store unavailable")
+}
+
+func (failingStore) Release(string) error {
+	return errors.Unavailable("down", "store unavailable")
+}
+
+func TestWrapHandlesMessagesWhenStoreUnavailable(t *testing.T) {
+	i := newTestInbox(failingStore{})
+
+	calls := 0
+	handler := i.Wrap(func(msg *natsbroker.Message) error {
+		calls++
+		return nil
+	}, nil)
+
+	for n := 0; n < 2; n++ {
+		if err := handler(testMessage("event-1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("expected the messages to be handled without deduplication, got %d calls", calls)
+	}
+}
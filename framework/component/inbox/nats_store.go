@@ -0,0 +1,139 @@
+package inbox
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/natsbroker"
+)
+
+// natsStore keeps the processed keys in a NATS key-value bucket shared by all replicas. The bucket
+// ttl expires the keys, processing locks are taken over by revision once their lease expired.
+type natsStore struct {
+	broker natsbroker.Broker
+	bucket string
+	ttl    time.Duration
+	lock   sync.Mutex
+	kv     nats.KeyValue
+}
+
+type natsEntry struct {
+	Completed bool      `json:"completed"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func newNatsStore(broker natsbroker.Broker, bucket string, ttl time.Duration) *natsStore {
+	return &natsStore{
+		broker: broker,
+		bucket: bucket,
+		ttl:    ttl,
+	}
+}
+
+// keyValue binds the key-value bucket on first use, once the broker is connected.
+func (s *natsStore) keyValue() (nats.KeyValue, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.kv != nil {
+		return s.kv, nil
+	}
+
+	client := s.broker.Client()
+	if client == nil {
+		return nil, fmt.Errorf("nats broker not connected")
+	}
+
+	js, err := client.JetStream()
+	if err != nil {
+		return nil, err
+	}
+
+	kv, err := js.KeyValue(s.bucket)
+	if err == nats.ErrBucketNotFound {
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{
+			Bucket: s.bucket,
+			TTL:    s.ttl,
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.kv = kv
+	return kv, nil
+}
+
+// entryKey hashes the key since key-value keys only allow a restricted character set.
+func entryKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:16])
+}
+
+func (s *natsStore) Reserve(key string, lease time.Duration) (bool, bool, error) {
+	kv, err := s.keyValue()
+	if err != nil {
+		return false, false, err
+	}
+
+	k := entryKey(key)
+	data, err := json.Marshal(&natsEntry{ExpiresAt: time.Now().Add(lease)})
+	if err != nil {
+		return false, false, err
+	}
+
+	if _, err := kv.Create(k, data); err == nil {
+		return true, false, nil
+	}
+
+	current, err := kv.Get(k)
+	if err == nats.ErrKeyNotFound {
+		// Released in the meantime
+		_, err = kv.Create(k, data)
+		return err == nil, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+
+	var e natsEntry
+	if err := json.Unmarshal(current.Value(), &e); err != nil {
+		return false, false, fmt.Errorf("invalid inbox entry %s: %v", k, err)
+	}
+	if e.Completed || time.Now().Before(e.ExpiresAt) {
+		return false, e.Completed, nil
+	}
+
+	// The lease of the previous consumer expired, another replica may take it over first
+	if _, err := kv.Update(k, data, current.Revision()); err != nil {
+		return false, false, nil
+	}
+	return true, false, nil
+}
+
+func (s *natsStore) Complete(key string, ttl time.Duration) error {
+	kv, err := s.keyValue()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(&natsEntry{Completed: true, ExpiresAt: time.Now().Add(ttl)})
+	if err != nil {
+		return err
+	}
+	_, err = kv.Put(entryKey(key), data)
+	return err
+}
+
+func (s *natsStore) Release(key string) error {
+	kv, err := s.keyValue()
+	if err != nil {
+		return err
+	}
+	return kv.Delete(entryKey(key))
+}
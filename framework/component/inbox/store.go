@@ -0,0 +1,95 @@
+package inbox
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Store records the messages processed by the consumers.
+type Store interface {
+	// Reserve locks the key for processing until the lease expires. It returns false when the key
+	// was processed, or is being processed, in which case completed tells which.
+	Reserve(key string, lease time.Duration) (reserved bool, completed bool, err error)
+	// Complete records the key as processed until the ttl expires.
+	Complete(key string, ttl time.Duration) error
+	// Release unlocks the key so that the message can be processed again.
+	Release(key string) error
+}
+
+type entry struct {
+	key       string
+	completed bool
+	expiresAt time.Time
+}
+
+// memoryStore is a least recently used cache of the processed keys, bounded in size.
+type memoryStore struct {
+	lock     sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List
+}
+
+func newMemoryStore(capacity int) *memoryStore {
+	return &memoryStore{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (s *memoryStore) Reserve(key string, lease time.Duration) (bool, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	if element, exists := s.entries[key]; exists {
+		e := element.Value.(*entry)
+		if now.Before(e.expiresAt) {
+			s.order.MoveToFront(element)
+			return false, e.completed, nil
+		}
+		s.remove(element)
+	}
+
+	s.entries[key] = s.order.PushFront(&entry{
+		key:       key,
+		expiresAt: now.Add(lease),
+	})
+	for s.order.Len() > s.capacity {
+		s.remove(s.order.Back())
+	}
+	return true, false, nil
+}
+
+func (s *memoryStore) Complete(key string, ttl time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	element, exists := s.entries[key]
+	if !exists {
+		element = s.order.PushFront(&entry{key: key})
+		s.entries[key] = element
+	}
+
+	e := element.Value.(*entry)
+	e.completed = true
+	e.expiresAt = time.Now().Add(ttl)
+	return nil
+}
+
+func (s *memoryStore) Release(key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if element, exists := s.entries[key]; exists {
+		s.remove(element)
+	}
+	return nil
+}
+
+func (s *memoryStore) remove(element *list.Element) {
+	s.order.Remove(element)
+	delete(s.entries, element.Value.(*entry).key)
+}
@@ -0,0 +1,218 @@
+package natsbroker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/nats-io/nats.go"
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
+	"github.com/vmihailenco/msgpack"
+)
+
+const (
+	ContentTypeProtobuf = "application/protobuf"
+	ContentTypeJSON     = "application/json"
+	ContentTypeMsgpack  = "application/msgpack"
+)
+
+// Codec encodes messages into payloads of its content type.
+type Codec interface {
+	ContentType() string
+	Marshal(msg proto.Message) ([]byte, error)
+	Unmarshal(data []byte, msg proto.Message) error
+}
+
+var (
+	codecsLock sync.RWMutex
+	codecs     = make(map[string]Codec)
+)
+
+func init() {
+	RegisterCodec(protobufCodec{})
+	RegisterCodec(jsonCodec{})
+	RegisterCodec(msgpackCodec{})
+}
+
+// RegisterCodec makes the codec available to publishers and subscriptions, replacing the codec of the same content type.
+func RegisterCodec(codec Codec) {
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+	codecs[codec.ContentType()] = codec
+}
+
+// CodecFor returns the codec registered for the content type, ignoring its parameters.
+func CodecFor(contentType string) (Codec, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, errors.Wrap(err, errors.KindInvalidArgument, "invalid_content_type", "invalid content type %s", contentType)
+	}
+
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+	codec, exists := codecs[mediaType]
+	if !exists {
+		return nil, errors.InvalidArgument("unsupported_content_type", "unsupported content type %s", contentType)
+	}
+	return codec, nil
+}
+
+// headerCodec returns the codec of the Content-Type header, messages without header being protobuf.
+func headerCodec(header nats.Header) (Codec, error) {
+	contentType := header.Get(HeaderContentType)
+	if contentType == "" {
+		contentType = ContentTypeProtobuf
+	}
+	return CodecFor(contentType)
+}
+
+func decode(header nats.Header, data []byte, msg proto.Message) error {
+	codec, err := headerCodec(header)
+	if err != nil {
+		return err
+	}
+	return codec.Unmarshal(data, msg)
+}
+
+// topicCodec selects the content type of the messages published on the subjects matching the pattern.
+type topicCodec struct {
+	pattern     string
+	contentType string
+}
+
+// parseTopicCodecs parses a comma separated list of subject=content-type pairs, subjects can contain wildcards.
+func parseTopicCodecs(value string) ([]topicCodec, error) {
+	var topicCodecs []topicCodec
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid topic codec %s: expected subject=content-type", pair)
+		}
+		if _, err := CodecFor(parts[1]); err != nil {
+			return nil, fmt.Errorf("invalid topic codec %s: %v", pair, err)
+		}
+		topicCodecs = append(topicCodecs, topicCodec{
+			pattern:     strings.TrimSpace(parts[0]),
+			contentType: strings.TrimSpace(parts[1]),
+		})
+	}
+	return topicCodecs, nil
+}
+
+// contentTypeFor returns the content type of the messages published on the topic.
+func (b *natsBroker) contentTypeFor(topic string) string {
+	for _, c := range b.topicCodecs {
+		if subjectMatches(c.pattern, topic) {
+			return c.contentType
+		}
+	}
+	return b.contentType
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string {
+	return ContentTypeProtobuf
+}
+
+func (protobufCodec) Marshal(msg proto.Message) ([]byte, error) {
+	return proto.Marshal(msg)
+}
+
+func (protobufCodec) Unmarshal(data []byte, msg proto.Message) error {
+	return proto.Unmarshal(data, msg)
+}
+
+// jsonCodec uses the canonical JSON mapping of protobuf, ignoring unknown fields so that messages can evolve.
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return ContentTypeJSON
+}
+
+func (jsonCodec) Marshal(msg proto.Message) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := (&jsonpb.Marshaler{}).Marshal(&buf, msg); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (jsonCodec) Unmarshal(data []byte, msg proto.Message) error {
+	return (&jsonpb.Unmarshaler{AllowUnknownFields: true}).Unmarshal(bytes.NewReader(data), msg)
+}
+
+// msgpackCodec encodes the JSON mapping of the message, so that payloads have the same field names in both formats.
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string {
+	return ContentTypeMsgpack
+}
+
+func (msgpackCodec) Marshal(msg proto.Message) ([]byte, error) {
+	data, err := jsonCodec{}.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return msgpack.Marshal(jsonValue(value))
+}
+
+func (msgpackCodec) Unmarshal(data []byte, msg proto.Message) error {
+	var value interface{}
+	if err := msgpack.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(jsonValue(value))
+	if err != nil {
+		return err
+	}
+	return jsonCodec{}.Unmarshal(data, msg)
+}
+
+// jsonValue converts decoded values to the types shared by the JSON and MessagePack encoders,
+// with integers kept as such and map keys as strings.
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		n, _ := v.Float64()
+		return n
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = jsonValue(item)
+		}
+		return v
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[fmt.Sprint(key)] = jsonValue(item)
+		}
+		return m
+	case []interface{}:
+		for i, item := range v {
+			v[i] = jsonValue(item)
+		}
+		return v
+	}
+	return value
+}
@@ -0,0 +1,178 @@
+package natsbroker
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/golang/protobuf/ptypes/duration"
+	"github.com/nats-io/nats.go"
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
+)
+
+// testDescriptor has nested, repeated, enum, 64-bit and floating point fields.
+func testDescriptor() *descriptor.FileDescriptorProto {
+	return &descriptor.FileDescriptorProto{
+		Name:       proto.String("test.proto"),
+		Package:    proto.String("test"),
+		Dependency: []string{"a.proto", "b.proto"},
+		MessageType: []*descriptor.DescriptorProto{{
+			Name: proto.String("Test"),
+			Field: []*descriptor.FieldDescriptorProto{{
+				Name:   proto.String("id"),
+				Number: proto.Int32(1),
+				Type:   descriptor.FieldDescriptorProto_TYPE_INT64.Enum(),
+			}},
+		}},
+		Options: &descriptor.FileOptions{
+			JavaMultipleFiles: proto.Bool(true),
+			UninterpretedOption: []*descriptor.UninterpretedOption{{
+				PositiveIntValue: proto.Uint64(1<<64 - 1),
+				NegativeIntValue: proto.Int64(-1 << 62),
+				DoubleValue:      proto.Float64(0.25),
+			}},
+		},
+		SourceCodeInfo: &descriptor.SourceCodeInfo{
+			Location: []*descriptor.SourceCodeInfo_Location{{
+				Path: []int32{4, 0, 2, 0},
+				Span: []int32{-1, 0, 1 << 30},
+			}},
+		},
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, contentType := range []string{ContentTypeProtobuf, ContentTypeJSON, ContentTypeMsgpack} {
+		contentType := contentType
+		t.Run(contentType, func(t *testing.T) {
+			codec, err := CodecFor(contentType)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, msg := range []proto.Message{testDescriptor(), &duration.Duration{Seconds: 90, Nanos: 5}} {
+				data, err := codec.Marshal(msg)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				decoded := proto.Clone(msg)
+				decoded.Reset()
+				if err := codec.Unmarshal(data, decoded); err != nil {
+					t.Fatal(err)
+				}
+				if !proto.Equal(msg, decoded) {
+					t.Errorf("expected %v, got %v", msg, decoded)
+				}
+			}
+		})
+	}
+}
+
+func TestCodecIgnoresUnknownFields(t *testing.T) {
+	msg := &descriptor.FileDescriptorProto{}
+	if err := (jsonCodec{}).Unmarshal([]byte(`{"name":"test.proto","unknown":1}`), msg); err != nil {
+		t.Fatalf("expected unknown fields to be ignored, got %v", err)
+	}
+	if msg.GetName() != "test.proto" {
+		t.Errorf("unexpected name %s", msg.GetName())
+	}
+}
+
+func TestCodecFor(t *testing.T) {
+	tests := []struct {
+		contentType string
+		expected    string
+		code        string
+	}{
+		{"application/json", ContentTypeJSON, ""},
+		{"application/json; charset=utf-8", ContentTypeJSON, ""},
+		{"Application/MsgPack", ContentTypeMsgpack, ""},
+		{"application/xml", "", "unsupported_content_type"},
+		{"", "", "invalid_content_type"},
+	}
+
+	for _, test := range tests {
+		codec, err := CodecFor(test.contentType)
+		if test.code != "" {
+			if e := errors.From(err); e.Kind != errors.KindInvalidArgument || e.Code != test.code {
+				t.Errorf("%q: expected %s, got %v", test.contentType, test.code, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.contentType, err)
+			continue
+		}
+		if codec.ContentType() != test.expected {
+			t.Errorf("%q: expected %s, got %s", test.contentType, test.expected, codec.ContentType())
+		}
+	}
+}
+
+func TestParseTopicCodecs(t *testing.T) {
+	topicCodecs, err := parseTopicCodecs(" user.* = application/json ,, audit.> =application/msgpack")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &natsBroker{contentType: ContentTypeProtobuf, topicCodecs: topicCodecs}
+	for topic, expected := range map[string]string{
+		"user.message":     ContentTypeJSON,
+		"user.message.dlq": ContentTypeProtobuf,
+		"audit.user.login": ContentTypeMsgpack,
+		"billing":          ContentTypeProtobuf,
+	} {
+		if contentType := b.contentTypeFor(topic); contentType != expected {
+			t.Errorf("%s: expected %s, got %s", topic, expected, contentType)
+		}
+	}
+
+	for _, value := range []string{"user.message", "=application/json", "user.message=application/xml"} {
+		if _, err := parseTopicCodecs(value); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
+
+func TestPublishJSON(t *testing.T) {
+	b := newTestBroker(t, runServer(t, false))
+	b.topicCodecs = []topicCodec{{pattern: "test.json", contentType: ContentTypeJSON}}
+
+	received := make(chan *Message, 2)
+	_, err := b.Subscribe("test.json", testFactory, func(msg *Message) error {
+		received <- msg
+		return nil
+	}, WithWorkers(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := subscribeSync(t, b, "test.json")
+
+	if err := b.Publish("test.json", testMessage(42)); err != nil {
+		t.Fatal(err)
+	}
+	if data := string(receive(t, raw).Data); data != `"42s"` {
+		t.Errorf("expected a JSON payload, got %s", data)
+	}
+
+	// Messages published by other clients are decoded by their content type
+	err = b.client.PublishMsg(&nats.Msg{
+		Subject: "test.json",
+		Data:    []byte(`"7s"`),
+		Header:  nats.Header{HeaderContentType: []string{"application/json; charset=utf-8"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := expectMessages(t, received, 2)
+	for i, expected := range []int64{42, 7} {
+		if seconds := messages[i].Payload.(*duration.Duration).Seconds; seconds != expected {
+			t.Errorf("message %d: expected %d, got %d", i, expected, seconds)
+		}
+	}
+	if contentType := messages[0].Event.ContentType; contentType != ContentTypeJSON {
+		t.Errorf("expected the content type of the event to be %s, got %s", ContentTypeJSON, contentType)
+	}
+}
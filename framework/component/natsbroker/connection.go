@@ -0,0 +1,229 @@
+package natsbroker
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// ConnectionState is the state of the connection to the NATS servers.
+type ConnectionState string
+
+const (
+	StateConnecting   ConnectionState = "connecting"
+	StateConnected    ConnectionState = "connected"
+	StateReconnecting ConnectionState = "reconnecting"
+	StateClosed       ConnectionState = "closed"
+)
+
+// ConnectionStatus reports the state of the connection and the last connection error.
+type ConnectionStatus struct {
+	State      ConnectionState
+	Since      time.Time
+	Server     string
+	Reconnects uint64
+	LastError  error
+}
+
+// Healthy returns true when messages can be published and received.
+func (s ConnectionStatus) Healthy() bool {
+	return s.State == StateConnected
+}
+
+type connectionStatus struct {
+	sync.RWMutex
+	status ConnectionStatus
+}
+
+func (c *connectionStatus) get() ConnectionStatus {
+	c.RLock()
+	defer c.RUnlock()
+	return c.status
+}
+
+func (c *connectionStatus) set(state ConnectionState, conn *nats.Conn, err error) {
+	c.Lock()
+	defer c.Unlock()
+	c.status.State = state
+	c.status.Since = time.Now()
+	if conn != nil {
+		c.status.Server = conn.ConnectedUrl()
+		c.status.Reconnects = conn.Stats().Reconnects
+	}
+	if err != nil {
+		c.status.LastError = err
+	}
+}
+
+func (b *natsBroker) Status() ConnectionStatus {
+	return b.status.get()
+}
+
+// Health reports the service as unhealthy while the connection is not established.
+func (b *natsBroker) Health() error {
+	status := b.Status()
+	if status.Healthy() {
+		return nil
+	}
+	if status.LastError != nil {
+		return fmt.Errorf("nats %s: %v", status.State, status.LastError)
+	}
+	return fmt.Errorf("nats %s", status.State)
+}
+
+func (b *natsBroker) closing() bool {
+	select {
+	case <-b.closingCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// connectWithRetry opens the connection, retrying with an exponential backoff up to the configured number of attempts.
+func (b *natsBroker) connectWithRetry(shutdownCh <-chan struct{}) (*nats.Conn, <-chan struct{}, error) {
+	b.status.set(StateConnecting, nil, nil)
+
+	delay := b.connectBackoff
+	for attempt := 1; ; attempt++ {
+		client, closedCh, err := b.connect()
+		if err == nil {
+			return client, closedCh, nil
+		}
+		b.status.set(StateConnecting, nil, err)
+
+		if b.connectAttempts > 0 && attempt >= b.connectAttempts {
+			return nil, nil, fmt.Errorf("error connecting to nats after %d attempts: %v", attempt, err)
+		}
+
+		b.logger.Warn().
+			Err(err).
+			Int("attempt", attempt).
+			Dur("retry_in", delay).
+			Msg("connection failed, retrying")
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-shutdownCh:
+			timer.Stop()
+			return nil, nil, fmt.Errorf("shutdown while connecting to nats: %v", err)
+		}
+
+		delay *= 2
+		if delay > b.connectMaxBackoff {
+			delay = b.connectMaxBackoff
+		}
+	}
+}
+
+// connect opens the connection with the options of the component, the returned channel is closed with the connection.
+// Callbacks set by the options of the component are still called.
+func (b *natsBroker) connect() (*nats.Conn, <-chan struct{}, error) {
+	opts := nats.GetDefaultOptions()
+	opts.Name = b.source
+	opts.DrainTimeout = b.drainTimeout
+	opts.MaxReconnect = b.maxReconnects
+	opts.ReconnectWait = b.reconnectWait
+	opts.ReconnectBufSize = b.reconnectBufSize
+	for _, url := range strings.Split(b.natsUrl, ",") {
+		opts.Servers = append(opts.Servers, strings.TrimSpace(url))
+	}
+	for _, option := range b.natsOptions {
+		if err := option(&opts); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	disconnectedCb := opts.DisconnectedErrCB
+	opts.DisconnectedErrCB = func(conn *nats.Conn, err error) {
+		// Disconnections while shutting down are expected
+		if !b.closing() {
+			b.logger.Warn().Err(err).Str("server", conn.ConnectedUrl()).Msg("disconnected, reconnecting...")
+			b.status.set(StateReconnecting, conn, err)
+		}
+		if disconnectedCb != nil {
+			disconnectedCb(conn, err)
+		}
+	}
+
+	reconnectedCb := opts.ReconnectedCB
+	opts.ReconnectedCB = func(conn *nats.Conn) {
+		b.logger.Info().
+			Str("server", conn.ConnectedUrl()).
+			Uint64("reconnects", conn.Stats().Reconnects).
+			Msg("reconnected")
+		b.status.set(StateConnected, conn, nil)
+		if reconnectedCb != nil {
+			reconnectedCb(conn)
+		}
+	}
+
+	errorCb := opts.AsyncErrorCB
+	opts.AsyncErrorCB = func(conn *nats.Conn, sub *nats.Subscription, err error) {
+		event := b.logger.Warn().Err(err)
+		if sub != nil {
+			event = event.Str("subject", sub.Subject)
+		}
+		event.Msg("asynchronous error")
+
+		// Only the first fatal error is reported, it shuts the service down
+		if fatal := b.fatalAsyncError(sub, err); fatal != nil {
+			select {
+			case b.asyncErrCh <- fatal:
+			default:
+			}
+		}
+		if errorCb != nil {
+			errorCb(conn, sub, err)
+		}
+	}
+
+	closedCh := make(chan struct{})
+	closedCb := opts.ClosedCB
+	opts.ClosedCB = func(conn *nats.Conn) {
+		b.status.set(StateClosed, conn, conn.LastError())
+		if closedCb != nil {
+			closedCb(conn)
+		}
+		close(closedCh)
+	}
+
+	client, err := opts.Connect()
+	if err != nil {
+		return nil, nil, err
+	}
+	b.status.set(StateConnected, client, nil)
+	return client, closedCh, nil
+}
+
+// fatalAsyncError returns the asynchronous errors that lose messages or deny the subscriptions, nil for the ones only logged.
+func (b *natsBroker) fatalAsyncError(sub *nats.Subscription, err error) error {
+	switch {
+	case err == nats.ErrSlowConsumer && sub != nil && !b.redelivered(sub):
+		return fmt.Errorf("slow consumer on %s, messages dropped", sub.Subject)
+	case strings.Contains(err.Error(), "permissions violation"):
+		return err
+	}
+	return nil
+}
+
+// redelivered reports whether the messages dropped by the subscription are delivered again,
+// as JetStream does for the messages not acknowledged by typed subscriptions.
+func (b *natsBroker) redelivered(sub *nats.Subscription) bool {
+	if !b.jetStream {
+		return false
+	}
+
+	b.subscriptionsLock.Lock()
+	defer b.subscriptionsLock.Unlock()
+	for s := range b.subscriptions {
+		if s.sub == sub {
+			return true
+		}
+	}
+	return false
+}
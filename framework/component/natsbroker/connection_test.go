@@ -0,0 +1,27 @@
+package natsbroker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHealth(t *testing.T) {
+	srv := runServer(t, false)
+	b := newTestBroker(t, srv)
+
+	if err := b.Health(); err != nil {
+		t.Fatalf("expected a connected broker to be healthy, got %v", err)
+	}
+
+	srv.Shutdown()
+	deadline := time.Now().Add(testTimeout)
+	for b.Health() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the broker to be unhealthy once disconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if state := b.Status().State; state == StateConnected {
+		t.Errorf("unexpected state %s", state)
+	}
+}
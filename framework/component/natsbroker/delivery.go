@@ -0,0 +1,183 @@
+package natsbroker
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
+)
+
+const (
+	deadLetterSuffix = ".dlq"
+
+	HeaderDeadLetterSubject    = "Dlq-Original-Subject"
+	HeaderDeadLetterOriginalID = "Dlq-Original-Id"
+	HeaderDeadLetterError      = "Dlq-Error"
+	HeaderDeadLetterCode       = "Dlq-Error-Code"
+	HeaderDeadLetterAttempts   = "Dlq-Attempts"
+	HeaderDeadLetterFailedAt   = "Dlq-Failed-At"
+)
+
+// WithMaxAttempts sets the number of times a message is handled before being dead-lettered.
+func WithMaxAttempts(attempts int) SubscribeOption {
+	return func(s *subscription) {
+		s.maxAttempts = attempts
+	}
+}
+
+// WithRetryBackoff sets the delay before the first retry, doubled on each attempt up to max.
+func WithRetryBackoff(initial, max time.Duration) SubscribeOption {
+	return func(s *subscription) {
+		s.retryBackoff = initial
+		s.retryMaxBackoff = max
+	}
+}
+
+// WithDeadLetter sets the subject failed messages are published to, an empty subject discards them.
+func WithDeadLetter(subject string) SubscribeOption {
+	return func(s *subscription) {
+		s.deadLetter = subject
+	}
+}
+
+// DeadLetterSubject returns the default dead-letter subject of a topic.
+func DeadLetterSubject(topic string) string {
+	return topic + deadLetterSuffix
+}
+
+// deferredError asks for a message to be handled again later, without counting as a failed attempt.
+type deferredError struct {
+	cause error
+}
+
+func (e *deferredError) Error() string {
+	return e.cause.Error()
+}
+
+func (e *deferredError) Unwrap() error {
+	return e.cause
+}
+
+// Defer returns an error handling the message again after a backoff without using up its attempts,
+// e.g. while a duplicate of the message is being handled elsewhere.
+func Defer(err error) error {
+	return &deferredError{cause: err}
+}
+
+func deferred(err error) bool {
+	_, ok := err.(*deferredError)
+	return ok
+}
+
+// retryable tells whether a handler error may go away by itself, invalid or unauthorized messages fail the same way every time.
+func retryable(err error) bool {
+	switch errors.KindOf(err) {
+	case errors.KindInternal, errors.KindUnavailable, errors.KindResourceExhausted:
+		return true
+	}
+	return false
+}
+
+func (s *subscription) backoff(attempt int, err error) time.Duration {
+	d := s.retryBackoff
+	for i := 1; i < attempt && d < s.retryMaxBackoff; i++ {
+		d *= 2
+	}
+	if d > s.retryMaxBackoff {
+		d = s.retryMaxBackoff
+	}
+	if e := errors.From(err); e.RetryAfter > d {
+		d = e.RetryAfter
+	}
+	return d
+}
+
+// handle runs the handler until it succeeds, the error is permanent or the attempts are exhausted,
+// in which case the message is dead-lettered. On shutdown the pending retry runs right away, and the message
+// is dropped if it fails again.
+func (s *subscription) handle(raw *nats.Msg, msg *Message) {
+	closing := false
+	deferrals := 0
+	for attempt := 1; ; attempt++ {
+		msg.Attempt = attempt
+		err := s.handler(msg)
+		if err == nil {
+			return
+		}
+
+		logger := s.broker.logger.With().
+			Str("topic", s.topic).
+			Int("attempt", attempt).
+			Logger()
+
+		// Deferred messages are handled again with the same attempt
+		if deferred(err) {
+			if closing {
+				logger.Warn().Err(err).Msg("deferred message dropped on shutdown")
+				return
+			}
+			deferrals++
+			delay := s.backoff(deferrals, err)
+			logger.Debug().Err(err).Dur("retry_in", delay).Msg("message deferred")
+			closing = !s.broker.wait(delay)
+			attempt--
+			continue
+		}
+
+		if !retryable(err) || attempt >= s.maxAttempts {
+			s.deadLetterMessage(raw, err, attempt)
+			return
+		}
+
+		if closing {
+			logger.Error().Err(err).Msg("message dropped on shutdown")
+			return
+		}
+
+		delay := s.backoff(attempt, err)
+		logger.Warn().Err(err).Dur("retry_in", delay).Msg("error handling message")
+		closing = !s.broker.wait(delay)
+	}
+}
+
+// deadLetterMessage publishes the original message to the dead-letter subject, along with the error and the attempt count.
+// It returns false when the message couldn't be published.
+func (s *subscription) deadLetterMessage(raw *nats.Msg, err error, attempts int) bool {
+	logger := s.broker.logger.With().
+		Str("topic", s.topic).
+		Int("attempts", attempts).
+		Logger()
+
+	if s.deadLetter == "" {
+		logger.Error().Err(err).Msg("message discarded")
+		return true
+	}
+
+	header := nats.Header{}
+	for key, values := range raw.Header {
+		header[key] = values
+	}
+	// JetStream would discard the dead-lettered message as a duplicate of the original one
+	if id := header.Get(nats.MsgIdHdr); id != "" {
+		header.Del(nats.MsgIdHdr)
+		header.Set(HeaderDeadLetterOriginalID, id)
+	}
+	header.Set(HeaderDeadLetterSubject, raw.Subject)
+	header.Set(HeaderDeadLetterError, err.Error())
+	header.Set(HeaderDeadLetterCode, errors.From(err).Code)
+	header.Set(HeaderDeadLetterAttempts, strconv.Itoa(attempts))
+	header.Set(HeaderDeadLetterFailedAt, time.Now().UTC().Format(time.RFC3339Nano))
+
+	dlq := &nats.Msg{
+		Subject: s.deadLetter,
+		Data:    raw.Data,
+		Header:  header,
+	}
+	if pubErr := s.broker.publishMsg(dlq); pubErr != nil {
+		logger.Error().Err(err).AnErr("publish_error", pubErr).Msg("error publishing to dead-letter subject")
+		return false
+	}
+	logger.Error().Err(err).Str("dead_letter", s.deadLetter).Msg("message dead-lettered")
+	return true
+}
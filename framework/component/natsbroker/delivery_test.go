@@ -0,0 +1,175 @@
+package natsbroker
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/duration"
+	"github.com/nats-io/nats.go"
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
+)
+
+func TestRetryTransientErrors(t *testing.T) {
+	b := newTestBroker(t, runServer(t, false))
+	dlq := subscribeSync(t, b, DeadLetterSubject("test.retry"))
+
+	var calls int32
+	done := make(chan *Message, 1)
+	_, err := b.Subscribe("test.retry", testFactory, func(msg *Message) error {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			return errors.Unavailable("down", "temporarily unavailable")
+		}
+		done <- msg
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Publish("test.retry", testMessage(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := expectMessages(t, done, 1)[0]
+	if msg.Attempt != 3 {
+		t.Errorf("expected attempt 3, got %d", msg.Attempt)
+	}
+	if seconds := msg.Payload.(*duration.Duration).Seconds; seconds != 1 {
+		t.Errorf("unexpected payload %d", seconds)
+	}
+	expectNone(t, dlq, 100*time.Millisecond)
+}
+
+func TestDeadLetter(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		attempts string
+		code     string
+	}{
+		{"permanent error", errors.InvalidArgument("invalid_name", "invalid name"), "1", "invalid_name"},
+		{"exhausted attempts", errors.Unavailable("down", "still unavailable"), "3", "down"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			b := newTestBroker(t, runServer(t, false))
+			dlq := subscribeSync(t, b, DeadLetterSubject("test.dlq"))
+
+			var calls int32
+			_, err := b.Subscribe("test.dlq", testFactory, func(msg *Message) error {
+				atomic.AddInt32(&calls, 1)
+				return test.err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := b.Publish("test.dlq", testMessage(1), WithEventID("event-1")); err != nil {
+				t.Fatal(err)
+			}
+
+			msg := receive(t, dlq)
+			if subject := msg.Header.Get(HeaderDeadLetterSubject); subject != "test.dlq" {
+				t.Errorf("unexpected original subject %s", subject)
+			}
+			if attempts := msg.Header.Get(HeaderDeadLetterAttempts); attempts != test.attempts {
+				t.Errorf("expected %s attempts, got %s", test.attempts, attempts)
+			}
+			if code := msg.Header.Get(HeaderDeadLetterCode); code != test.code {
+				t.Errorf("expected code %s, got %s", test.code, code)
+			}
+			if id := msg.Header.Get(HeaderID); id != "event-1" {
+				t.Errorf("expected the event id to be kept, got %s", id)
+			}
+			if id := msg.Header.Get(nats.MsgIdHdr); id != "" {
+				t.Errorf("expected the message id to be removed, got %s", id)
+			}
+			if id := msg.Header.Get(HeaderDeadLetterOriginalID); id != "event-1" {
+				t.Errorf("expected the original message id, got %s", id)
+			}
+
+			payload := &duration.Duration{}
+			if err := decode(msg.Header, msg.Data, payload); err != nil || payload.Seconds != 1 {
+				t.Errorf("expected the original payload, got %v (%v)", payload, err)
+			}
+			if n := atomic.LoadInt32(&calls); fmt.Sprint(n) != test.attempts {
+				t.Errorf("expected %s calls, got %d", test.attempts, n)
+			}
+		})
+	}
+}
+
+func TestShutdownRunsPendingRetry(t *testing.T) {
+	tests := []struct {
+		name      string
+		lastError error
+	}{
+		{"retry succeeds", nil},
+		{"retry fails", errors.Unavailable("down", "still unavailable")},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			b := newTestBroker(t, runServer(t, false))
+			b.retryBackoff, b.retryMaxBackoff = time.Hour, time.Hour
+			dlq := subscribeSync(t, b, DeadLetterSubject("test.shutdown"))
+
+			attempts := make(chan *Message, 2)
+			_, err := b.Subscribe("test.shutdown", testFactory, func(msg *Message) error {
+				attempts <- msg
+				if msg.Attempt == 1 {
+					return errors.Unavailable("down", "temporarily unavailable")
+				}
+				return test.lastError
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := b.Publish("test.shutdown", testMessage(1)); err != nil {
+				t.Fatal(err)
+			}
+			expectMessages(t, attempts, 1)
+
+			// The retry waits for an hour, shutting down runs it right away
+			b.shutdown()
+			if msg := expectMessages(t, attempts, 1)[0]; msg.Attempt != 2 {
+				t.Errorf("expected attempt 2, got %d", msg.Attempt)
+			}
+			expectNone(t, dlq, 100*time.Millisecond)
+		})
+	}
+}
+
+func TestDeferDoesNotUseUpAttempts(t *testing.T) {
+	b := newTestBroker(t, runServer(t, false))
+	dlq := subscribeSync(t, b, DeadLetterSubject("test.defer"))
+
+	var calls int32
+	done := make(chan *Message, 1)
+	_, err := b.Subscribe("test.defer", testFactory, func(msg *Message) error {
+		// Deferred more times than the message has attempts
+		if atomic.AddInt32(&calls, 1) <= int32(2*b.maxAttempts) {
+			return Defer(errors.Unavailable("in_progress", "in progress"))
+		}
+		done <- msg
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Publish("test.defer", testMessage(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	if msg := expectMessages(t, done, 1)[0]; msg.Attempt != 1 {
+		t.Errorf("expected attempt 1, got %d", msg.Attempt)
+	}
+	expectNone(t, dlq, 100*time.Millisecond)
+}
@@ -0,0 +1,124 @@
+package natsbroker
+
+import (
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nats-io/nats.go"
+	"github.com/ubiqueworks/go-clean-architecture/framework/util"
+)
+
+// Envelope attributes follow the binary mode of CloudEvents, carried in the message headers.
+const (
+	SpecVersion          = "1.0"
+	DefaultSchemaVersion = "1"
+
+	HeaderSpecVersion   = "ce-specversion"
+	HeaderID            = "ce-id"
+	HeaderSource        = "ce-source"
+	HeaderType          = "ce-type"
+	HeaderTime          = "ce-time"
+	HeaderSchemaVersion = "ce-schemaversion"
+	HeaderContentType   = "Content-Type"
+)
+
+// Event holds the envelope attributes of a message, empty for messages published without envelope.
+type Event struct {
+	ID            string
+	Source        string
+	Type          string
+	SpecVersion   string
+	Time          time.Time
+	ContentType   string
+	SchemaVersion string
+}
+
+// PublishOption customizes the envelope of a published message.
+type PublishOption func(*Event)
+
+// WithEventID sets the id of the event, which defaults to a random UUID. Messages published again
+// with the same id, e.g. on retries, are recognized as duplicates.
+func WithEventID(id string) PublishOption {
+	return func(e *Event) {
+		e.ID = id
+	}
+}
+
+// WithEventTime sets the time the event occurred, which defaults to the publish time.
+func WithEventTime(t time.Time) PublishOption {
+	return func(e *Event) {
+		e.Time = t
+	}
+}
+
+// WithContentType sets the content type of the message, selecting the codec encoding it. It defaults to the codec of the topic.
+func WithContentType(contentType string) PublishOption {
+	return func(e *Event) {
+		e.ContentType = contentType
+	}
+}
+
+// WithSchemaVersion sets the version of the message schema, consumers can decode each version into a different type.
+func WithSchemaVersion(version string) PublishOption {
+	return func(e *Event) {
+		e.SchemaVersion = version
+	}
+}
+
+// WithSchemaFactory decodes the messages published with the given schema version into the messages returned by factory.
+func WithSchemaFactory(version string, factory MessageFactory) SubscribeOption {
+	return func(s *subscription) {
+		if s.factories == nil {
+			s.factories = make(map[string]MessageFactory)
+		}
+		s.factories[version] = factory
+	}
+}
+
+func (b *natsBroker) newEvent(topic string, msg proto.Message, options []PublishOption) *Event {
+	event := &Event{
+		ID:            util.NewUUID(),
+		Source:        b.source,
+		Type:          proto.MessageName(msg),
+		SpecVersion:   SpecVersion,
+		Time:          time.Now(),
+		ContentType:   b.contentTypeFor(topic),
+		SchemaVersion: DefaultSchemaVersion,
+	}
+	for _, option := range options {
+		option(event)
+	}
+	return event
+}
+
+// header returns the headers carrying the envelope, the id doubling as JetStream deduplication id.
+func (e *Event) header() nats.Header {
+	header := nats.Header{}
+	header.Set(HeaderSpecVersion, e.SpecVersion)
+	header.Set(HeaderID, e.ID)
+	header.Set(HeaderSource, e.Source)
+	header.Set(HeaderType, e.Type)
+	header.Set(HeaderTime, e.Time.UTC().Format(time.RFC3339Nano))
+	header.Set(HeaderSchemaVersion, e.SchemaVersion)
+	header.Set(HeaderContentType, e.ContentType)
+	header.Set(nats.MsgIdHdr, e.ID)
+	return header
+}
+
+func eventFromHeader(header nats.Header) Event {
+	event := Event{
+		ID:            header.Get(HeaderID),
+		Source:        header.Get(HeaderSource),
+		Type:          header.Get(HeaderType),
+		SpecVersion:   header.Get(HeaderSpecVersion),
+		ContentType:   header.Get(HeaderContentType),
+		SchemaVersion: header.Get(HeaderSchemaVersion),
+	}
+	if event.ID == "" {
+		event.ID = header.Get(nats.MsgIdHdr)
+	}
+	if t, err := time.Parse(time.RFC3339Nano, header.Get(HeaderTime)); err == nil {
+		event.Time = t
+	}
+	return event
+}
@@ -0,0 +1,375 @@
+package natsbroker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	deliverAll      = "all"
+	deliverNew      = "new"
+	deliverLast     = "last"
+	deliverSequence = "sequence"
+	deliverTime     = "time"
+
+	// deadLetterDeliveries are the deliveries left to dead-letter a message once its attempts are exhausted
+	deadLetterDeliveries = 5
+)
+
+// streamsConfig declares the JetStream streams created on connect and the settings of the durable consumers.
+type streamsConfig struct {
+	Streams   []*streamConfig   `yaml:"streams"`
+	Consumers []*consumerConfig `yaml:"consumers"`
+}
+
+type streamConfig struct {
+	Name      string        `yaml:"name"`
+	Subjects  []string      `yaml:"subjects"`
+	Storage   string        `yaml:"storage"`
+	Retention string        `yaml:"retention"`
+	MaxAge    time.Duration `yaml:"max_age"`
+	MaxMsgs   int64         `yaml:"max_msgs"`
+	MaxBytes  int64         `yaml:"max_bytes"`
+	Replicas  int           `yaml:"replicas"`
+}
+
+// consumerConfig overrides the options of the subscriptions using the durable consumer. The delivery
+// policy only applies when the consumer is created, replaying an existing consumer requires a new durable name.
+type consumerConfig struct {
+	Durable       string        `yaml:"durable"`
+	AckWait       time.Duration `yaml:"ack_wait"`
+	Deliver       string        `yaml:"deliver"`
+	StartSequence uint64        `yaml:"start_sequence"`
+	StartTime     time.Time     `yaml:"start_time"`
+}
+
+func loadStreamsConfig(file string) (*streamsConfig, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &streamsConfig{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("invalid nats streams file %s: %v", file, err)
+	}
+
+	for _, stream := range config.Streams {
+		if _, err := stream.natsConfig(); err != nil {
+			return nil, fmt.Errorf("invalid nats streams file %s: %v", file, err)
+		}
+	}
+	for _, consumer := range config.Consumers {
+		if consumer.Durable == "" {
+			return nil, fmt.Errorf("invalid nats streams file %s: consumer without durable name", file)
+		}
+		if err := validateDeliver(consumer.Deliver); err != nil {
+			return nil, fmt.Errorf("invalid nats streams file %s: consumer %s: %v", file, consumer.Durable, err)
+		}
+	}
+	return config, nil
+}
+
+func (c *streamConfig) natsConfig() (*nats.StreamConfig, error) {
+	if c.Name == "" || len(c.Subjects) == 0 {
+		return nil, fmt.Errorf("streams require a name and subjects")
+	}
+
+	config := &nats.StreamConfig{
+		Name:     c.Name,
+		Subjects: c.Subjects,
+		MaxAge:   c.MaxAge,
+		MaxMsgs:  c.MaxMsgs,
+		MaxBytes: c.MaxBytes,
+		Replicas: c.Replicas,
+	}
+	if config.MaxMsgs == 0 {
+		config.MaxMsgs = -1
+	}
+	if config.MaxBytes == 0 {
+		config.MaxBytes = -1
+	}
+
+	switch c.Storage {
+	case "", "file":
+		config.Storage = nats.FileStorage
+	case "memory":
+		config.Storage = nats.MemoryStorage
+	default:
+		return nil, fmt.Errorf("stream %s: unknown storage %s", c.Name, c.Storage)
+	}
+
+	switch c.Retention {
+	case "", "limits":
+		config.Retention = nats.LimitsPolicy
+	case "interest":
+		config.Retention = nats.InterestPolicy
+	case "work_queue":
+		config.Retention = nats.WorkQueuePolicy
+	default:
+		return nil, fmt.Errorf("stream %s: unknown retention %s", c.Name, c.Retention)
+	}
+	return config, nil
+}
+
+func validateDeliver(deliver string) error {
+	switch deliver {
+	case "", deliverAll, deliverNew, deliverLast, deliverSequence, deliverTime:
+		return nil
+	}
+	return fmt.Errorf("unknown delivery policy %s", deliver)
+}
+
+// WithDurable binds the subscription to a durable consumer, which keeps track of the acknowledged messages
+// across restarts. Queue subscriptions default to a durable consumer named after the queue group.
+func WithDurable(name string) SubscribeOption {
+	return func(s *subscription) {
+		s.durable = name
+	}
+}
+
+// WithStartSequence replays the stream from the given sequence number when the consumer is created.
+func WithStartSequence(seq uint64) SubscribeOption {
+	return func(s *subscription) {
+		s.deliver = deliverSequence
+		s.startSequence = seq
+	}
+}
+
+// WithStartTime replays the stream from the given time when the consumer is created.
+func WithStartTime(t time.Time) SubscribeOption {
+	return func(s *subscription) {
+		s.deliver = deliverTime
+		s.startTime = t
+	}
+}
+
+// WithAckWait sets how long the server waits for an acknowledgement before redelivering a message.
+func WithAckWait(d time.Duration) SubscribeOption {
+	return func(s *subscription) {
+		s.ackWait = d
+	}
+}
+
+// declareStreams creates the configured streams, or updates them when they already exist.
+func (b *natsBroker) declareStreams() error {
+	for _, stream := range b.streams.Streams {
+		config, _ := stream.natsConfig()
+
+		if _, err := b.js.StreamInfo(config.Name); err == nil {
+			if _, err := b.js.UpdateStream(config); err != nil {
+				return fmt.Errorf("error updating stream %s: %v", config.Name, err)
+			}
+		} else if _, err := b.js.AddStream(config); err != nil {
+			return fmt.Errorf("error creating stream %s: %v", config.Name, err)
+		}
+		b.logger.Info().Str("stream", config.Name).Strs("subjects", config.Subjects).Msg("stream declared")
+	}
+	return nil
+}
+
+// streamFor returns the declared stream capturing the topic.
+func (b *natsBroker) streamFor(topic string) (string, error) {
+	for _, stream := range b.streams.Streams {
+		for _, subject := range stream.Subjects {
+			if subjectMatches(subject, topic) {
+				return stream.Name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no stream declared for %s", topic)
+}
+
+// subjectMatches tells whether the subject is matched by the pattern, which may contain the * and > wildcards.
+func subjectMatches(pattern, subject string) bool {
+	patternTokens := strings.Split(pattern, ".")
+	subjectTokens := strings.Split(subject, ".")
+
+	for i, token := range patternTokens {
+		switch {
+		case token == ">":
+			return len(subjectTokens) > i
+		case i >= len(subjectTokens):
+			return false
+		case token != "*" && token != subjectTokens[i]:
+			return false
+		}
+	}
+	return len(patternTokens) == len(subjectTokens)
+}
+
+// subscribeJetStream subscribes to the topic through a JetStream consumer. Durable consumers are created
+// beforehand and bound to, so that draining the subscription on shutdown doesn't delete them.
+func (s *subscription) subscribeJetStream() error {
+	js := s.broker.js
+
+	if s.durable == "" && s.queue != "" {
+		s.durable = durableName(s.queue)
+	}
+	for _, consumer := range s.broker.streams.Consumers {
+		if consumer.Durable != s.durable {
+			continue
+		}
+		if consumer.AckWait > 0 {
+			s.ackWait = consumer.AckWait
+		}
+		if consumer.Deliver != "" {
+			s.deliver, s.startSequence, s.startTime = consumer.Deliver, consumer.StartSequence, consumer.StartTime
+		}
+	}
+
+	var err error
+	if s.durable == "" {
+		options := append(s.deliverOptions(), nats.ManualAck(), nats.AckExplicit(), nats.AckWait(s.ackWait), nats.MaxDeliver(s.maxDeliver()))
+		s.sub, err = js.Subscribe(s.topic, s.receive, options...)
+		return err
+	}
+
+	stream, err := s.broker.streamFor(s.topic)
+	if err != nil {
+		return err
+	}
+	if err := s.ensureConsumer(stream); err != nil {
+		return err
+	}
+
+	if s.queue == "" {
+		s.sub, err = js.Subscribe(s.topic, s.receive, nats.Bind(stream, s.durable), nats.ManualAck())
+	} else {
+		s.sub, err = js.QueueSubscribe(s.topic, s.queue, s.receive, nats.Bind(stream, s.durable), nats.ManualAck())
+	}
+	return err
+}
+
+func (s *subscription) ensureConsumer(stream string) error {
+	js := s.broker.js
+	if _, err := js.ConsumerInfo(stream, s.durable); err == nil {
+		return nil
+	}
+
+	config := &nats.ConsumerConfig{
+		Durable:        s.durable,
+		DeliverSubject: fmt.Sprintf("_deliver.%s.%s", stream, s.durable),
+		DeliverGroup:   s.queue,
+		FilterSubject:  s.topic,
+		AckPolicy:      nats.AckExplicitPolicy,
+		AckWait:        s.ackWait,
+		MaxDeliver:     s.maxDeliver(),
+	}
+
+	switch s.deliver {
+	case deliverNew:
+		config.DeliverPolicy = nats.DeliverNewPolicy
+	case deliverLast:
+		config.DeliverPolicy = nats.DeliverLastPolicy
+	case deliverSequence:
+		config.DeliverPolicy = nats.DeliverByStartSequencePolicy
+		config.OptStartSeq = s.startSequence
+	case deliverTime:
+		config.DeliverPolicy = nats.DeliverByStartTimePolicy
+		config.OptStartTime = &s.startTime
+	default:
+		config.DeliverPolicy = nats.DeliverAllPolicy
+	}
+
+	if _, err := js.AddConsumer(stream, config); err != nil {
+		return fmt.Errorf("error creating consumer %s on stream %s: %v", s.durable, stream, err)
+	}
+	s.broker.logger.Info().Str("stream", stream).Str("durable", s.durable).Str("deliver", s.deliver).Msg("consumer created")
+	return nil
+}
+
+func (s *subscription) deliverOptions() []nats.SubOpt {
+	switch s.deliver {
+	case deliverNew:
+		return []nats.SubOpt{nats.DeliverNew()}
+	case deliverLast:
+		return []nats.SubOpt{nats.DeliverLast()}
+	case deliverSequence:
+		return []nats.SubOpt{nats.StartSequence(s.startSequence)}
+	case deliverTime:
+		return []nats.SubOpt{nats.StartTime(s.startTime)}
+	}
+	return []nats.SubOpt{nats.DeliverAll()}
+}
+
+// durableName replaces the characters not allowed in consumer names.
+func durableName(name string) string {
+	return strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_").Replace(name)
+}
+
+// handleAcknowledged runs the handler once per delivery, the server redelivering the messages that are not acknowledged.
+// Retries are delayed up to half the ack wait so that the server doesn't redeliver the message in the meantime.
+func (s *subscription) handleAcknowledged(raw *nats.Msg, msg *Message) {
+	err := s.handler(msg)
+
+	// Deferred messages are held rather than released, so that waiting doesn't use up their deliveries
+	for deferrals := 1; deferred(err); deferrals++ {
+		delay := s.backoff(deferrals, err)
+		if delay > s.ackWait/2 {
+			delay = s.ackWait / 2
+		}
+		if inProgressErr := raw.InProgress(); inProgressErr != nil {
+			s.broker.logger.Warn().Err(inProgressErr).Str("topic", s.topic).Msg("error extending the ack wait of a deferred message")
+		}
+		if !s.broker.wait(delay) {
+			raw.Nak()
+			return
+		}
+		err = s.handler(msg)
+	}
+
+	if err == nil {
+		if ackErr := raw.Ack(); ackErr != nil {
+			s.broker.logger.Warn().Err(ackErr).Str("topic", s.topic).Msg("error acknowledging message")
+		}
+		return
+	}
+
+	if !retryable(err) || msg.Attempt >= s.maxAttempts {
+		s.terminate(raw, err, msg.Attempt)
+		return
+	}
+
+	delay := s.backoff(msg.Attempt, err)
+	if delay > s.ackWait/2 {
+		delay = s.ackWait / 2
+	}
+	s.broker.logger.Warn().
+		Err(err).
+		Str("topic", s.topic).
+		Int("attempt", msg.Attempt).
+		Dur("retry_in", delay).
+		Msg("error handling message")
+
+	// On shutdown the message is released right away, to be redelivered to another subscriber or after restart
+	s.broker.wait(delay)
+	raw.Nak()
+}
+
+// maxDeliver caps the deliveries of a message, so that a message failing to be dead-lettered isn't redelivered forever.
+func (s *subscription) maxDeliver() int {
+	return s.maxAttempts + deadLetterDeliveries
+}
+
+// terminate dead-letters the message and stops its redelivery. The message is released when it can't be dead-lettered,
+// and dropped on its last delivery.
+func (s *subscription) terminate(raw *nats.Msg, err error, attempts int) {
+	if !s.deadLetterMessage(raw, err, attempts) {
+		if attempts < s.maxDeliver() {
+			raw.Nak()
+			return
+		}
+		s.broker.logger.Error().
+			Err(err).
+			Str("topic", s.topic).
+			Int("attempts", attempts).
+			Msg("message dropped, dead-lettering failed on its last delivery")
+	}
+	raw.Term()
+}
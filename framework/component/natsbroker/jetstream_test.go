@@ -0,0 +1,225 @@
+package natsbroker
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/duration"
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
+)
+
+func testStream(subjects ...string) *streamConfig {
+	return &streamConfig{
+		Name:     "TEST",
+		Subjects: subjects,
+		Storage:  "memory",
+	}
+}
+
+func publishMessages(t *testing.T, b *natsBroker, topic string, from, to int64) {
+	t.Helper()
+
+	for i := from; i <= to; i++ {
+		if err := b.Publish(topic, testMessage(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkPayloads(t *testing.T, messages []*Message, from int64) {
+	t.Helper()
+
+	for i, msg := range messages {
+		expected := from + int64(i)
+		if seconds := msg.Payload.(*duration.Duration).Seconds; seconds != expected {
+			t.Errorf("message %d: expected payload %d, got %d", i, expected, seconds)
+		}
+		if msg.Sequence != uint64(expected) {
+			t.Errorf("message %d: expected sequence %d, got %d", i, expected, msg.Sequence)
+		}
+	}
+}
+
+func TestJetStreamDurableConsumer(t *testing.T) {
+	srv := runServer(t, true)
+	b := newTestBroker(t, srv, testStream("test.>"))
+
+	// Messages published before the consumer exists are delivered to it
+	publishMessages(t, b, "test.events", 1, 3)
+
+	received := make(chan *Message, 10)
+	handler := func(msg *Message) error {
+		received <- msg
+		return nil
+	}
+
+	sub, err := b.QueueSubscribe("test.events", "workers", testFactory, handler, WithWorkers(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPayloads(t, expectMessages(t, received, 3), 1)
+	if err := sub.Drain(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The acknowledged messages are not delivered again to the durable consumer, the new ones are
+	publishMessages(t, b, "test.events", 4, 4)
+	if _, err := b.QueueSubscribe("test.events", "workers", testFactory, handler); err != nil {
+		t.Fatal(err)
+	}
+	checkPayloads(t, expectMessages(t, received, 1), 4)
+
+	info, err := b.js.ConsumerInfo("TEST", "workers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Config.MaxDeliver != b.maxAttempts+deadLetterDeliveries {
+		t.Errorf("expected max deliver %d, got %d", b.maxAttempts+deadLetterDeliveries, info.Config.MaxDeliver)
+	}
+}
+
+func TestJetStreamRedelivery(t *testing.T) {
+	b := newTestBroker(t, runServer(t, true), testStream("test.>"))
+
+	received := make(chan *Message, 10)
+	_, err := b.Subscribe("test.events", testFactory, func(msg *Message) error {
+		received <- msg
+		if msg.Attempt == 1 {
+			return errors.Unavailable("down", "temporarily unavailable")
+		}
+		return nil
+	}, WithDurable("redelivery"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publishMessages(t, b, "test.events", 1, 1)
+
+	messages := expectMessages(t, received, 2)
+	for i, msg := range messages {
+		if msg.Attempt != i+1 {
+			t.Errorf("expected attempt %d, got %d", i+1, msg.Attempt)
+		}
+		if msg.Sequence != 1 {
+			t.Errorf("expected the message to be redelivered, got sequence %d", msg.Sequence)
+		}
+	}
+
+	select {
+	case msg := <-received:
+		t.Fatalf("acknowledged message redelivered, attempt %d", msg.Attempt)
+	case <-time.After(2 * b.ackWait):
+	}
+}
+
+func TestJetStreamReplayFromSequence(t *testing.T) {
+	b := newTestBroker(t, runServer(t, true), testStream("test.>"))
+	publishMessages(t, b, "test.events", 1, 5)
+
+	received := make(chan *Message, 10)
+	_, err := b.Subscribe("test.events", testFactory, func(msg *Message) error {
+		received <- msg
+		return nil
+	}, WithDurable("replay"), WithStartSequence(3), WithWorkers(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkPayloads(t, expectMessages(t, received, 3), 3)
+}
+
+func TestJetStreamDeadLetter(t *testing.T) {
+	b := newTestBroker(t, runServer(t, true), testStream("test.>"))
+	dlq := subscribeSync(t, b, DeadLetterSubject("test.events"))
+
+	var calls int32
+	_, err := b.Subscribe("test.events", testFactory, func(msg *Message) error {
+		atomic.AddInt32(&calls, 1)
+		return errors.InvalidArgument("invalid_name", "invalid name")
+	}, WithDurable("dead-letter"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Publish("test.events", testMessage(1), WithEventID("event-1")); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := receive(t, dlq)
+	if attempts := msg.Header.Get(HeaderDeadLetterAttempts); attempts != "1" {
+		t.Errorf("expected 1 attempt, got %s", attempts)
+	}
+
+	// The dead-lettered message isn't discarded by the stream as a duplicate of the original one
+	stored, err := b.js.GetMsg("TEST", 2)
+	if err != nil {
+		t.Fatalf("dead-lettered message not stored: %v", err)
+	}
+	if stored.Subject != DeadLetterSubject("test.events") {
+		t.Errorf("unexpected subject %s", stored.Subject)
+	}
+	if id := stored.Header.Get(HeaderDeadLetterOriginalID); id != "event-1" {
+		t.Errorf("expected the original message id, got %s", id)
+	}
+
+	// The dead-lettered message is terminated rather than redelivered
+	time.Sleep(2 * b.ackWait)
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("expected 1 call, got %d", n)
+	}
+}
+
+func TestJetStreamDropsMessagesFailingToBeDeadLettered(t *testing.T) {
+	// The dead-letter subject isn't captured by the stream, publishing to it fails
+	b := newTestBroker(t, runServer(t, true), testStream("test.events"))
+	b.maxAttempts = 1
+
+	calls := make(chan *Message, 20)
+	_, err := b.Subscribe("test.events", testFactory, func(msg *Message) error {
+		calls <- msg
+		return errors.InvalidArgument("invalid_name", "invalid name")
+	}, WithDurable("undeliverable"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publishMessages(t, b, "test.events", 1, 1)
+
+	deliveries := b.maxAttempts + deadLetterDeliveries
+	messages := expectMessages(t, calls, deliveries)
+	if last := messages[len(messages)-1]; last.Attempt != deliveries {
+		t.Errorf("expected the last attempt to be %d, got %d", deliveries, last.Attempt)
+	}
+
+	select {
+	case msg := <-calls:
+		t.Fatalf("message delivered past its last delivery, attempt %d", msg.Attempt)
+	case <-time.After(2 * b.ackWait):
+	}
+}
+
+func TestJetStreamDeferHoldsMessage(t *testing.T) {
+	b := newTestBroker(t, runServer(t, true), testStream("test.>"))
+	b.retryMaxBackoff = b.ackWait
+
+	var calls int32
+	done := make(chan *Message, 1)
+	_, err := b.Subscribe("test.events", testFactory, func(msg *Message) error {
+		// Deferred for longer than the ack wait and more times than the message has deliveries
+		if atomic.AddInt32(&calls, 1) <= int32(b.maxAttempts+deadLetterDeliveries) {
+			return Defer(errors.Unavailable("in_progress", "in progress"))
+		}
+		done <- msg
+		return nil
+	}, WithDurable("defer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publishMessages(t, b, "test.events", 1, 1)
+
+	if msg := expectMessages(t, done, 1)[0]; msg.Attempt != 1 {
+		t.Errorf("expected the message to be held rather than redelivered, got attempt %d", msg.Attempt)
+	}
+}
@@ -1,11 +1,13 @@
 package natsbroker
 
 import (
+	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/golang/protobuf/proto"
-	"github.com/nats-io/go-nats"
+	"github.com/nats-io/nats.go"
 	"github.com/rs/zerolog"
 	"github.com/ubiqueworks/go-clean-architecture/framework"
 	"gopkg.in/urfave/cli.v1"
@@ -16,6 +18,60 @@ const (
 
 	envNatsUrl  = "NATS_URL"
 	flagNatsUrl = "nats-url"
+
+	envNatsWorkers  = "NATS_WORKERS"
+	flagNatsWorkers = "nats-workers"
+
+	envNatsPendingLimit  = "NATS_PENDING_LIMIT"
+	flagNatsPendingLimit = "nats-pending-limit"
+
+	envNatsDrainTimeout  = "NATS_DRAIN_TIMEOUT"
+	flagNatsDrainTimeout = "nats-drain-timeout"
+
+	envNatsMaxAttempts  = "NATS_MAX_ATTEMPTS"
+	flagNatsMaxAttempts = "nats-max-attempts"
+
+	envNatsRetryBackoff  = "NATS_RETRY_BACKOFF"
+	flagNatsRetryBackoff = "nats-retry-backoff"
+
+	envNatsRetryMaxBackoff  = "NATS_RETRY_MAX_BACKOFF"
+	flagNatsRetryMaxBackoff = "nats-retry-max-backoff"
+
+	envNatsJetStream  = "NATS_JETSTREAM"
+	flagNatsJetStream = "nats-jetstream"
+
+	envNatsStreamsFile  = "NATS_STREAMS_FILE"
+	flagNatsStreamsFile = "nats-streams-file"
+
+	envNatsAckWait  = "NATS_ACK_WAIT"
+	flagNatsAckWait = "nats-ack-wait"
+
+	envNatsRequestTimeout  = "NATS_REQUEST_TIMEOUT"
+	flagNatsRequestTimeout = "nats-request-timeout"
+
+	envNatsContentType  = "NATS_CONTENT_TYPE"
+	flagNatsContentType = "nats-content-type"
+
+	envNatsTopicCodecs  = "NATS_TOPIC_CODECS"
+	flagNatsTopicCodecs = "nats-topic-codecs"
+
+	envNatsConnectAttempts  = "NATS_CONNECT_ATTEMPTS"
+	flagNatsConnectAttempts = "nats-connect-attempts"
+
+	envNatsConnectBackoff  = "NATS_CONNECT_BACKOFF"
+	flagNatsConnectBackoff = "nats-connect-backoff"
+
+	envNatsConnectMaxBackoff  = "NATS_CONNECT_MAX_BACKOFF"
+	flagNatsConnectMaxBackoff = "nats-connect-max-backoff"
+
+	envNatsMaxReconnects  = "NATS_MAX_RECONNECTS"
+	flagNatsMaxReconnects = "nats-max-reconnects"
+
+	envNatsReconnectWait  = "NATS_RECONNECT_WAIT"
+	flagNatsReconnectWait = "nats-reconnect-wait"
+
+	envNatsReconnectBufferSize  = "NATS_RECONNECT_BUFFER_SIZE"
+	flagNatsReconnectBufferSize = "nats-reconnect-buffer-size"
 )
 
 var cliFlags = []cli.Flag{
@@ -24,11 +80,120 @@ var cliFlags = []cli.Flag{
 		EnvVar: envNatsUrl,
 		Usage:  "nats connection url",
 	},
+	cli.IntFlag{
+		Name:   flagNatsWorkers,
+		EnvVar: envNatsWorkers,
+		Usage:  "default number of messages handled concurrently by each subscription",
+		Value:  10,
+	},
+	cli.IntFlag{
+		Name:   flagNatsPendingLimit,
+		EnvVar: envNatsPendingLimit,
+		Usage:  "default number of messages buffered by each subscription while its workers are busy",
+		Value:  1000,
+	},
+	cli.DurationFlag{
+		Name:   flagNatsDrainTimeout,
+		EnvVar: envNatsDrainTimeout,
+		Usage:  "maximum time to wait on shutdown for the messages being handled",
+		Value:  30 * time.Second,
+	},
+	cli.IntFlag{
+		Name:   flagNatsMaxAttempts,
+		EnvVar: envNatsMaxAttempts,
+		Usage:  "default number of times a failing message is handled before being dead-lettered",
+		Value:  5,
+	},
+	cli.DurationFlag{
+		Name:   flagNatsRetryBackoff,
+		EnvVar: envNatsRetryBackoff,
+		Usage:  "default delay before retrying a failed message, doubled on each attempt",
+		Value:  time.Second,
+	},
+	cli.DurationFlag{
+		Name:   flagNatsRetryMaxBackoff,
+		EnvVar: envNatsRetryMaxBackoff,
+		Usage:  "default maximum delay between retries of a failed message",
+		Value:  30 * time.Second,
+	},
+	cli.BoolFlag{
+		Name:   flagNatsJetStream,
+		EnvVar: envNatsJetStream,
+		Usage:  "persist messages in JetStream streams, with acknowledged and redelivered subscriptions",
+	},
+	cli.StringFlag{
+		Name:   flagNatsStreamsFile,
+		EnvVar: envNatsStreamsFile,
+		Usage:  "yaml file declaring the JetStream streams and durable consumers",
+	},
+	cli.DurationFlag{
+		Name:   flagNatsAckWait,
+		EnvVar: envNatsAckWait,
+		Usage:  "default time JetStream waits for a message to be acknowledged before redelivering it",
+		Value:  30 * time.Second,
+	},
+	cli.DurationFlag{
+		Name:   flagNatsRequestTimeout,
+		EnvVar: envNatsRequestTimeout,
+		Usage:  "timeout of the requests sent without a context deadline",
+		Value:  5 * time.Second,
+	},
+	cli.StringFlag{
+		Name:   flagNatsContentType,
+		EnvVar: envNatsContentType,
+		Usage:  "default content type of the published messages: application/protobuf, application/json or application/msgpack",
+		Value:  ContentTypeProtobuf,
+	},
+	cli.StringFlag{
+		Name:   flagNatsTopicCodecs,
+		EnvVar: envNatsTopicCodecs,
+		Usage:  "comma separated content types of the messages published on matching topics, e.g. debug.>=application/json",
+	},
+	cli.IntFlag{
+		Name:   flagNatsConnectAttempts,
+		EnvVar: envNatsConnectAttempts,
+		Usage:  "number of attempts to connect at startup before failing, 0 to retry forever",
+		Value:  10,
+	},
+	cli.DurationFlag{
+		Name:   flagNatsConnectBackoff,
+		EnvVar: envNatsConnectBackoff,
+		Usage:  "delay before retrying to connect at startup, doubled on each attempt",
+		Value:  time.Second,
+	},
+	cli.DurationFlag{
+		Name:   flagNatsConnectMaxBackoff,
+		EnvVar: envNatsConnectMaxBackoff,
+		Usage:  "maximum delay between attempts to connect at startup",
+		Value:  30 * time.Second,
+	},
+	cli.IntFlag{
+		Name:   flagNatsMaxReconnects,
+		EnvVar: envNatsMaxReconnects,
+		Usage:  "number of attempts to reconnect to each server once connected, -1 to retry forever",
+		Value:  nats.DefaultMaxReconnect,
+	},
+	cli.DurationFlag{
+		Name:   flagNatsReconnectWait,
+		EnvVar: envNatsReconnectWait,
+		Usage:  "delay between attempts to reconnect to the same server",
+		Value:  nats.DefaultReconnectWait,
+	},
+	cli.IntFlag{
+		Name:   flagNatsReconnectBufferSize,
+		EnvVar: envNatsReconnectBufferSize,
+		Usage:  "size in bytes of the buffer holding the messages published while reconnecting",
+		Value:  nats.DefaultReconnectBufSize,
+	},
 }
 
 func Create(options ...nats.Option) framework.Component {
 	return &natsBroker{
-		natsOptions: options,
+		natsOptions:   options,
+		subscriptions: make(map[*subscription]struct{}),
+		closingCh:     make(chan struct{}),
+		asyncErrCh:    make(chan error, 1),
+		status:        connectionStatus{status: ConnectionStatus{State: StateConnecting}},
 	}
 }
 
@@ -42,26 +207,81 @@ func Get(service framework.Service) (Broker, error) {
 
 type Broker interface {
 	Client() *nats.Conn
-	Publish(topic string, msg proto.Message) error
+	// Publish sends the message to the topic, wrapped in an event envelope.
+	Publish(topic string, msg proto.Message, options ...PublishOption) error
+	// Subscribe delivers the messages published on the topic to the handler, decoded into the messages returned by the factory.
+	Subscribe(topic string, factory MessageFactory, handler MessageHandler, options ...SubscribeOption) (Subscription, error)
+	// QueueSubscribe is like Subscribe, with the messages load balanced across the subscribers of the queue group.
+	QueueSubscribe(topic, queue string, factory MessageFactory, handler MessageHandler, options ...SubscribeOption) (Subscription, error)
+	// Request sends req to the responders of the subject and decodes their reply into resp, waiting until the context deadline.
+	Request(ctx context.Context, subject string, req, resp proto.Message) error
+	// Respond replies to the requests sent on the subject, load balanced across the responders of the queue group.
+	Respond(subject, queue string, factory MessageFactory, handler RequestHandler, options ...SubscribeOption) (Subscription, error)
+	// Status returns the state of the connection, for health checks.
+	Status() ConnectionStatus
 }
 
 type natsBroker struct {
-	client      *nats.Conn
-	logger      *zerolog.Logger
-	natsUrl     string
-	natsOptions []nats.Option
+	client            *nats.Conn
+	js                nats.JetStreamContext
+	jetStream         bool
+	streams           *streamsConfig
+	ackWait           time.Duration
+	requestTimeout    time.Duration
+	logger            *zerolog.Logger
+	source            string
+	contentType       string
+	topicCodecs       []topicCodec
+	natsUrl           string
+	natsOptions       []nats.Option
+	status            connectionStatus
+	connectAttempts   int
+	connectBackoff    time.Duration
+	connectMaxBackoff time.Duration
+	maxReconnects     int
+	reconnectWait     time.Duration
+	reconnectBufSize  int
+	workers           int
+	pendingLimit      int
+	drainTimeout      time.Duration
+	maxAttempts       int
+	retryBackoff      time.Duration
+	retryMaxBackoff   time.Duration
+	closingCh         chan struct{}
+	asyncErrCh        chan error
+	subscriptionsLock sync.Mutex
+	subscriptions     map[*subscription]struct{}
 }
 
 func (b *natsBroker) Client() *nats.Conn {
 	return b.client
 }
 
-func (b *natsBroker) Publish(subj string, msg proto.Message) error {
-	data, err := proto.Marshal(msg)
+func (b *natsBroker) Publish(subj string, msg proto.Message, options ...PublishOption) error {
+	event := b.newEvent(subj, msg, options)
+	codec, err := CodecFor(event.ContentType)
 	if err != nil {
 		return err
 	}
-	return b.client.Publish(subj, data)
+
+	data, err := codec.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return b.publishMsg(&nats.Msg{
+		Subject: subj,
+		Data:    data,
+		Header:  event.header(),
+	})
+}
+
+// publishMsg publishes through JetStream when enabled, waiting for the message to be stored.
+func (b *natsBroker) publishMsg(msg *nats.Msg) error {
+	if b.js != nil {
+		_, err := b.js.PublishMsg(msg)
+		return err
+	}
+	return b.client.PublishMsg(msg)
 }
 
 func (b *natsBroker) ID() string {
@@ -83,6 +303,7 @@ func (b *natsBroker) Logger() *zerolog.Logger {
 func (b *natsBroker) Configure(service framework.Service, cliCtx *cli.Context) error {
 	logger := service.Logger().With().Str("component", Component).Logger()
 	b.logger = &logger
+	b.source = service.Name()
 
 	natsUrl := cliCtx.String(flagNatsUrl)
 	if natsUrl == "" {
@@ -90,6 +311,52 @@ func (b *natsBroker) Configure(service framework.Service, cliCtx *cli.Context) e
 	}
 	b.natsUrl = natsUrl
 
+	b.connectAttempts = cliCtx.Int(flagNatsConnectAttempts)
+	b.connectBackoff = cliCtx.Duration(flagNatsConnectBackoff)
+	b.connectMaxBackoff = cliCtx.Duration(flagNatsConnectMaxBackoff)
+	if b.connectAttempts < 0 || b.connectBackoff <= 0 || b.connectMaxBackoff < b.connectBackoff {
+		return fmt.Errorf("invalid nats connect retries: attempts must not be negative and backoff must be positive")
+	}
+	b.maxReconnects = cliCtx.Int(flagNatsMaxReconnects)
+	b.reconnectWait = cliCtx.Duration(flagNatsReconnectWait)
+	b.reconnectBufSize = cliCtx.Int(flagNatsReconnectBufferSize)
+
+	b.workers = cliCtx.Int(flagNatsWorkers)
+	b.pendingLimit = cliCtx.Int(flagNatsPendingLimit)
+	b.maxAttempts = cliCtx.Int(flagNatsMaxAttempts)
+	if b.workers < 1 || b.pendingLimit < 1 || b.maxAttempts < 1 {
+		return fmt.Errorf("invalid nats subscription defaults: workers, pending limit and max attempts must be positive")
+	}
+	b.drainTimeout = cliCtx.Duration(flagNatsDrainTimeout)
+	b.retryBackoff = cliCtx.Duration(flagNatsRetryBackoff)
+	b.retryMaxBackoff = cliCtx.Duration(flagNatsRetryMaxBackoff)
+
+	b.requestTimeout = cliCtx.Duration(flagNatsRequestTimeout)
+
+	b.contentType = cliCtx.String(flagNatsContentType)
+	if _, err := CodecFor(b.contentType); err != nil {
+		return fmt.Errorf("invalid nats content type: %v", err)
+	}
+	topicCodecs, err := parseTopicCodecs(cliCtx.String(flagNatsTopicCodecs))
+	if err != nil {
+		return err
+	}
+	b.topicCodecs = topicCodecs
+
+	b.jetStream = cliCtx.Bool(flagNatsJetStream)
+	b.ackWait = cliCtx.Duration(flagNatsAckWait)
+	if b.jetStream && b.ackWait <= 0 {
+		return fmt.Errorf("invalid nats ack wait: %s", b.ackWait)
+	}
+	b.streams = &streamsConfig{}
+	if streamsFile := cliCtx.String(flagNatsStreamsFile); streamsFile != "" {
+		streams, err := loadStreamsConfig(streamsFile)
+		if err != nil {
+			return err
+		}
+		b.streams = streams
+	}
+
 	return nil
 }
 
@@ -97,7 +364,7 @@ func (b *natsBroker) Initialize(wg *sync.WaitGroup, startedCh chan<- struct{}, s
 	defer wg.Done()
 
 	b.logger.Debug().Msg("connecting...")
-	client, err := nats.Connect(b.natsUrl, b.natsOptions...)
+	client, closedCh, err := b.connectWithRetry(shutdownCh)
 	if err != nil {
 		b.logger.Error().Err(err).Msg("connection error")
 		errCh <- err
@@ -105,20 +372,75 @@ func (b *natsBroker) Initialize(wg *sync.WaitGroup, startedCh chan<- struct{}, s
 	}
 	b.client = client
 
-	b.logger.Info().Msg("connected")
+	if b.jetStream {
+		if err := b.initJetStream(); err != nil {
+			b.logger.Error().Err(err).Msg("jetstream error")
+			client.Close()
+			errCh <- err
+			return
+		}
+	}
+
+	b.logger.Info().
+		Str("server", client.ConnectedUrl()).
+		Bool("jetstream", b.jetStream).
+		Msg("connected")
 	close(startedCh)
 
-	doneCh := make(chan struct{}, 1)
-	stopFunc := func() {
-		b.logger.Debug().Msg("disconnecting...")
-		b.client.Close()
-		close(doneCh)
+	select {
+	case <-shutdownCh:
+	case err := <-b.asyncErrCh:
+		b.logger.Error().Err(err).Msg("subscription error")
+		select {
+		case errCh <- err:
+		case <-shutdownCh:
+		}
+		<-shutdownCh
+	case <-closedCh:
+		// The connection is closed before the shutdown when reconnecting failed
+		err := fmt.Errorf("nats connection lost: %v", client.LastError())
+		b.logger.Error().Err(err).Msg("connection closed")
+		select {
+		case errCh <- err:
+		case <-shutdownCh:
+		}
+		<-shutdownCh
+		close(b.closingCh)
+		return
 	}
-
-	<-shutdownCh
 	b.logger.Debug().Msg("shutdown signal received...")
-	stopFunc()
+	close(b.closingCh)
+
+	// Draining lets the subscriptions handle the messages already received before the connection is closed
+	b.logger.Debug().Msg("draining...")
+	b.drainSubscriptions()
+	if err := b.client.Drain(); err != nil {
+		b.logger.Warn().Err(err).Msg("drain error")
+		b.client.Close()
+	}
 
-	<-doneCh
+	<-closedCh
 	b.logger.Info().Msg("disconnected")
 }
+
+func (b *natsBroker) initJetStream() error {
+	js, err := b.client.JetStream()
+	if err != nil {
+		return err
+	}
+	b.js = js
+	return b.declareStreams()
+}
+
+// wait sleeps for the given duration, returning false when interrupted by the shutdown.
+func (b *natsBroker) wait(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-b.closingCh:
+		return false
+	}
+}
@@ -0,0 +1,214 @@
+package natsbroker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nats-io/nats.go"
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
+)
+
+const (
+	headerRequestDeadline = "Request-Deadline"
+	headerReplyError      = "Reply-Error"
+)
+
+// RequestHandler replies to the requests received by a responder, the context carrying the deadline of the caller.
+type RequestHandler func(ctx context.Context, req *Message) (proto.Message, error)
+
+// errorReply is the body of the replies reporting an error to the caller.
+type errorReply struct {
+	Kind         string            `json:"kind"`
+	Code         string            `json:"code,omitempty"`
+	Message      string            `json:"message"`
+	Details      map[string]string `json:"details,omitempty"`
+	Fields       []fieldViolation  `json:"fields,omitempty"`
+	RetryAfterMs int64             `json:"retry_after_ms,omitempty"`
+}
+
+type fieldViolation struct {
+	Field       string `json:"field"`
+	Description string `json:"description"`
+}
+
+func newErrorReply(err error) []byte {
+	e := errors.From(err)
+	reply := &errorReply{
+		Kind:         e.Kind.String(),
+		Code:         e.Code,
+		Message:      e.Message,
+		Details:      e.Details,
+		RetryAfterMs: int64(e.RetryAfter / time.Millisecond),
+	}
+	if e.Kind == errors.KindInternal {
+		// Never leak the cause of internal errors to callers
+		reply.Message = "internal error"
+	}
+	for _, f := range e.Fields {
+		reply.Fields = append(reply.Fields, fieldViolation{Field: f.Field, Description: f.Description})
+	}
+
+	data, _ := json.Marshal(reply)
+	return data
+}
+
+func parseErrorReply(data []byte) error {
+	reply := &errorReply{}
+	if err := json.Unmarshal(data, reply); err != nil {
+		return errors.Wrap(err, errors.KindInternal, "invalid_reply", "invalid error reply")
+	}
+
+	e := errors.New(errors.ParseKind(reply.Kind), reply.Code, "%s", reply.Message)
+	e.Details = reply.Details
+	for _, f := range reply.Fields {
+		e.WithField(f.Field, f.Description)
+	}
+	if reply.RetryAfterMs > 0 {
+		e.WithRetryAfter(time.Duration(reply.RetryAfterMs) * time.Millisecond)
+	}
+	return e
+}
+
+func (b *natsBroker) Request(ctx context.Context, subject string, req, resp proto.Message) error {
+	event := b.newEvent(subject, req, nil)
+	codec, err := CodecFor(event.ContentType)
+	if err != nil {
+		return err
+	}
+
+	data, err := codec.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	if _, hasDeadline := ctx.Deadline(); !hasDeadline {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.requestTimeout)
+		defer cancel()
+	}
+	deadline, _ := ctx.Deadline()
+
+	msg := &nats.Msg{
+		Subject: subject,
+		Data:    data,
+		Header:  event.header(),
+	}
+	msg.Header.Set(headerRequestDeadline, deadline.UTC().Format(time.RFC3339Nano))
+
+	reply, err := b.client.RequestMsgWithContext(ctx, msg)
+	switch {
+	case err == nats.ErrNoResponders:
+		return errors.Unavailable("no_responders", "no responders for %s", subject)
+	case err == context.DeadlineExceeded || err == nats.ErrTimeout:
+		return errors.Wrap(err, errors.KindUnavailable, "request_timeout", "request to %s timed out", subject)
+	case err != nil:
+		return err
+	}
+
+	if reply.Header.Get(headerReplyError) != "" {
+		return parseErrorReply(reply.Data)
+	}
+	// Handlers returning no message reply without body nor content type
+	if len(reply.Data) == 0 {
+		resp.Reset()
+		return nil
+	}
+	if err := decode(reply.Header, reply.Data, resp); err != nil {
+		return errors.Wrap(err, errors.KindInternal, "invalid_reply", "error decoding reply from %s", subject)
+	}
+	return nil
+}
+
+// Respond replies to the requests sent on the subject. Requests always go through core NATS, JetStream mode
+// doesn't apply, and are not retried: errors are sent back to the caller.
+func (b *natsBroker) Respond(subject, queue string, factory MessageFactory, handler RequestHandler, options ...SubscribeOption) (Subscription, error) {
+	s, err := b.newSubscription(subject, queue, factory, options)
+	if err != nil {
+		return nil, err
+	}
+	s.deadLetter = ""
+	s.process = func(raw *nats.Msg) {
+		s.respond(raw, handler)
+	}
+
+	if queue == "" {
+		s.sub, err = b.client.Subscribe(subject, s.receive)
+	} else {
+		s.sub, err = b.client.QueueSubscribe(subject, queue, s.receive)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error subscribing to %s: %v", subject, err)
+	}
+	if err := b.register(s); err != nil {
+		return nil, err
+	}
+
+	b.logger.Info().
+		Str("subject", subject).
+		Str("queue", queue).
+		Int("workers", s.workers).
+		Msg("responding")
+	return s, nil
+}
+
+func (s *subscription) respond(raw *nats.Msg, handler RequestHandler) {
+	logger := s.broker.logger.With().Str("subject", s.topic).Logger()
+	if raw.Reply == "" {
+		logger.Warn().Msg("request without reply subject")
+		return
+	}
+
+	ctx := context.Background()
+	if deadline, err := time.Parse(time.RFC3339Nano, raw.Header.Get(headerRequestDeadline)); err == nil {
+		if time.Now().After(deadline) {
+			logger.Debug().Msg("request expired before being handled")
+			return
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	msg := &Message{
+		Subject: raw.Subject,
+		Event:   eventFromHeader(raw.Header),
+		Raw:     raw,
+		Attempt: 1,
+	}
+	msg.Payload = s.newPayload(msg.Event)
+
+	var resp proto.Message
+	err := decode(raw.Header, raw.Data, msg.Payload)
+	if err != nil {
+		err = errors.Wrap(err, errors.KindInvalidArgument, "invalid_request", "error decoding request")
+	} else {
+		resp, err = handler(ctx, msg)
+	}
+
+	reply := &nats.Msg{
+		Subject: raw.Reply,
+		Header:  nats.Header{},
+	}
+	if err == nil && resp != nil {
+		// Replies are encoded like the request, so that callers get back the content type they sent
+		var codec Codec
+		if codec, err = headerCodec(raw.Header); err == nil {
+			reply.Data, err = codec.Marshal(resp)
+			reply.Header.Set(HeaderContentType, codec.ContentType())
+		}
+	}
+	if err != nil {
+		if errors.KindOf(err) == errors.KindInternal {
+			logger.Error().Err(err).Msg("error handling request")
+		}
+		reply.Data = newErrorReply(err)
+		reply.Header.Set(headerReplyError, errors.KindOf(err).String())
+	}
+
+	if err := s.broker.client.PublishMsg(reply); err != nil {
+		logger.Error().Err(err).Msg("error sending reply")
+	}
+}
@@ -0,0 +1,147 @@
+package natsbroker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/duration"
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
+)
+
+func TestRequestReply(t *testing.T) {
+	b := newTestBroker(t, runServer(t, false))
+
+	_, err := b.Respond("test.double", "responders", testFactory, func(ctx context.Context, req *Message) (proto.Message, error) {
+		if _, hasDeadline := ctx.Deadline(); !hasDeadline {
+			return nil, errors.Internal("no_deadline", "request without deadline")
+		}
+		return testMessage(2 * req.Payload.(*duration.Duration).Seconds), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := &duration.Duration{}
+	if err := b.Request(context.Background(), "test.double", testMessage(21), resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Seconds != 42 {
+		t.Errorf("expected 42, got %d", resp.Seconds)
+	}
+}
+
+func TestRequestEmptyReply(t *testing.T) {
+	for _, contentType := range []string{ContentTypeProtobuf, ContentTypeJSON} {
+		contentType := contentType
+		t.Run(contentType, func(t *testing.T) {
+			b := newTestBroker(t, runServer(t, false))
+			b.contentType = contentType
+
+			_, err := b.Respond("test.empty", "", testFactory, func(ctx context.Context, req *Message) (proto.Message, error) {
+				return nil, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp := &duration.Duration{Seconds: 1}
+			if err := b.Request(context.Background(), "test.empty", testMessage(1), resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Seconds != 0 {
+				t.Errorf("expected an empty reply, got %v", resp)
+			}
+		})
+	}
+}
+
+func TestRequestErrors(t *testing.T) {
+	b := newTestBroker(t, runServer(t, false))
+
+	_, err := b.Respond("test.fail", "", testFactory, func(ctx context.Context, req *Message) (proto.Message, error) {
+		switch req.Payload.(*duration.Duration).Seconds {
+		case 1:
+			return nil, errors.InvalidArgument("invalid_request", "invalid request").
+				WithField("seconds", "must be even").
+				WithRetryAfter(time.Second)
+		case 2:
+			return nil, errors.Internal("database_down", "connection refused by 10.0.0.1")
+		default:
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("typed error", func(t *testing.T) {
+		err := errors.From(b.Request(context.Background(), "test.fail", testMessage(1), &duration.Duration{}))
+		if err.Kind != errors.KindInvalidArgument || err.Code != "invalid_request" || err.Message != "invalid request" {
+			t.Fatalf("unexpected error %+v", err)
+		}
+		if len(err.Fields) != 1 || err.Fields[0].Field != "seconds" {
+			t.Errorf("unexpected field violations %+v", err.Fields)
+		}
+		if err.RetryAfter != time.Second {
+			t.Errorf("expected retry after 1s, got %s", err.RetryAfter)
+		}
+	})
+
+	t.Run("internal error", func(t *testing.T) {
+		err := errors.From(b.Request(context.Background(), "test.fail", testMessage(2), &duration.Duration{}))
+		if err.Kind != errors.KindInternal || err.Message != "internal error" {
+			t.Errorf("expected the cause of the internal error to be hidden, got %+v", err)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		err := errors.From(b.Request(ctx, "test.fail", testMessage(3), &duration.Duration{}))
+		if err.Kind != errors.KindUnavailable || err.Code != "request_timeout" {
+			t.Errorf("expected a timeout, got %+v", err)
+		}
+	})
+
+	t.Run("no responders", func(t *testing.T) {
+		err := errors.From(b.Request(context.Background(), "test.nobody", testMessage(1), &duration.Duration{}))
+		if err.Kind != errors.KindUnavailable || err.Code != "no_responders" {
+			t.Errorf("expected no responders, got %+v", err)
+		}
+	})
+}
+
+func TestRequestQueueGroup(t *testing.T) {
+	b := newTestBroker(t, runServer(t, false))
+
+	handled := make(chan int, 10)
+	for i := 1; i <= 2; i++ {
+		responder := i
+		_, err := b.Respond("test.echo", "echo", testFactory, func(ctx context.Context, req *Message) (proto.Message, error) {
+			handled <- responder
+			return req.Payload, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := int64(1); i <= 10; i++ {
+		resp := &duration.Duration{}
+		if err := b.Request(context.Background(), "test.echo", testMessage(i), resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Seconds != i {
+			t.Errorf("expected %d, got %d", i, resp.Seconds)
+		}
+	}
+
+	// Each request is handled once, by either responder of the queue group
+	if n := len(handled); n != 10 {
+		t.Errorf("expected 10 requests handled, got %d", n)
+	}
+}
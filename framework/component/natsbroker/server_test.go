@@ -0,0 +1,146 @@
+package natsbroker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/duration"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+)
+
+const testTimeout = 5 * time.Second
+
+// runServer starts an embedded NATS server, with JetStream storing the streams in a temporary directory.
+func runServer(t *testing.T, jetStream bool) *server.Server {
+	t.Helper()
+
+	srv, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		NoLog:     true,
+		NoSigs:    true,
+		JetStream: jetStream,
+		StoreDir:  t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(testTimeout) {
+		t.Fatal("nats server not ready")
+	}
+	t.Cleanup(srv.Shutdown)
+	return srv
+}
+
+// newTestBroker connects a broker with short retry delays to the server, declaring the streams in JetStream mode.
+func newTestBroker(t *testing.T, srv *server.Server, streams ...*streamConfig) *natsBroker {
+	t.Helper()
+
+	logger := zerolog.Nop()
+	b := Create().(*natsBroker)
+	b.logger = &logger
+	b.source = "test"
+	b.natsUrl = srv.ClientURL()
+	b.contentType = ContentTypeProtobuf
+	b.workers = 4
+	b.pendingLimit = 100
+	b.drainTimeout = testTimeout
+	b.maxAttempts = 3
+	b.retryBackoff = 10 * time.Millisecond
+	b.retryMaxBackoff = 50 * time.Millisecond
+	b.requestTimeout = testTimeout
+	b.ackWait = time.Second
+	b.streams = &streamsConfig{Streams: streams}
+
+	client, _, err := b.connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.client = client
+
+	if len(streams) > 0 {
+		b.jetStream = true
+		if err := b.initJetStream(); err != nil {
+			client.Close()
+			t.Fatal(err)
+		}
+	}
+
+	t.Cleanup(func() {
+		b.shutdown()
+		client.Close()
+	})
+	return b
+}
+
+// shutdown interrupts the pending retries, as the broker does when the service stops.
+func (b *natsBroker) shutdown() {
+	select {
+	case <-b.closingCh:
+	default:
+		close(b.closingCh)
+	}
+}
+
+func testMessage(seconds int64) proto.Message {
+	return &duration.Duration{Seconds: seconds}
+}
+
+func testFactory() proto.Message {
+	return &duration.Duration{}
+}
+
+// subscribeSync subscribes a channel to the subject, making sure the server knows about it before returning.
+func subscribeSync(t *testing.T, b *natsBroker, subject string) *nats.Subscription {
+	t.Helper()
+
+	sub, err := b.client.SubscribeSync(subject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.client.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sub.Unsubscribe() })
+	return sub
+}
+
+// receive waits for the next message of the channel subscription.
+func receive(t *testing.T, sub *nats.Subscription) *nats.Msg {
+	t.Helper()
+
+	msg, err := sub.NextMsg(testTimeout)
+	if err != nil {
+		t.Fatalf("no message received on %s: %v", sub.Subject, err)
+	}
+	return msg
+}
+
+// expectNone checks that nothing is received on the subscription for a while.
+func expectNone(t *testing.T, sub *nats.Subscription, wait time.Duration) {
+	t.Helper()
+
+	if msg, err := sub.NextMsg(wait); err == nil {
+		t.Fatalf("unexpected message on %s: %v", msg.Subject, msg.Header)
+	}
+}
+
+// expectMessages waits for n messages from the handler channel.
+func expectMessages(t *testing.T, ch <-chan *Message, n int) []*Message {
+	t.Helper()
+
+	var messages []*Message
+	for len(messages) < n {
+		select {
+		case msg := <-ch:
+			messages = append(messages, msg)
+		case <-time.After(testTimeout):
+			t.Fatalf("received %d of %d messages", len(messages), n)
+		}
+	}
+	return messages
+}
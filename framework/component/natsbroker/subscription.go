@@ -0,0 +1,291 @@
+package natsbroker
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nats-io/nats.go"
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
+)
+
+// Message is a message received on a subscription, decoded into the type returned by the factory of the subscription.
+type Message struct {
+	Subject string
+	Payload proto.Message
+	Event   Event
+	Raw     *nats.Msg
+	// Attempt counts the deliveries of the message to the handler, starting at 1.
+	Attempt int
+	// Sequence is the position of the message in its stream, set in JetStream mode.
+	Sequence uint64
+}
+
+// MessageFactory returns an empty message of the type published on a topic.
+type MessageFactory func() proto.Message
+
+// MessageHandler processes the messages received on a subscription. Returning nil acknowledges the message,
+// internal, unavailable and resource exhausted errors are retried and any other error dead-letters the message.
+// Errors returned by Defer handle the message again later, without counting as a failed attempt.
+type MessageHandler func(msg *Message) error
+
+type Subscription interface {
+	Topic() string
+	Unsubscribe() error
+	// Drain stops the delivery of new messages and waits for the messages already received to be handled.
+	Drain() error
+}
+
+// SubscribeOption customizes a subscription, overriding the defaults set on the command line.
+type SubscribeOption func(*subscription)
+
+// WithWorkers sets the maximum number of messages handled concurrently by the subscription.
+func WithWorkers(workers int) SubscribeOption {
+	return func(s *subscription) {
+		s.workers = workers
+	}
+}
+
+// WithPendingLimit sets the number of messages buffered by the client while the workers are busy,
+// messages are dropped as slow consumer errors past the limit.
+func WithPendingLimit(messages int) SubscribeOption {
+	return func(s *subscription) {
+		s.pendingLimit = messages
+	}
+}
+
+type subscription struct {
+	broker          *natsBroker
+	topic           string
+	queue           string
+	factory         MessageFactory
+	factories       map[string]MessageFactory
+	handler         MessageHandler
+	workers         int
+	pendingLimit    int
+	maxAttempts     int
+	retryBackoff    time.Duration
+	retryMaxBackoff time.Duration
+	deadLetter      string
+	durable         string
+	ackWait         time.Duration
+	deliver         string
+	startSequence   uint64
+	startTime       time.Time
+	process         func(raw *nats.Msg)
+	sub             *nats.Subscription
+	slots           chan struct{}
+	inFlight        sync.WaitGroup
+	drainOnce       sync.Once
+	drainErr        error
+}
+
+func (s *subscription) Topic() string {
+	return s.topic
+}
+
+func (s *subscription) Unsubscribe() error {
+	s.broker.removeSubscription(s)
+	return s.sub.Unsubscribe()
+}
+
+func (s *subscription) Drain() error {
+	s.drainOnce.Do(func() {
+		s.broker.removeSubscription(s)
+		s.drainErr = s.drain(s.broker.drainTimeout)
+	})
+	return s.drainErr
+}
+
+func (s *subscription) drain(timeout time.Duration) error {
+	if err := s.sub.Drain(); err != nil {
+		return err
+	}
+
+	// The subscription becomes invalid once the client has delivered the pending messages
+	deadline := time.Now().Add(timeout)
+	for s.sub.IsValid() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.inFlight.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("timeout draining subscription to %s", s.topic)
+	}
+}
+
+// receive hands the message over to a worker, blocking the delivery of the subscription while all the workers are busy.
+func (s *subscription) receive(raw *nats.Msg) {
+	s.slots <- struct{}{}
+	s.inFlight.Add(1)
+
+	go func() {
+		defer func() {
+			<-s.slots
+			s.inFlight.Done()
+		}()
+		s.process(raw)
+	}()
+}
+
+func (s *subscription) dispatch(raw *nats.Msg) {
+	msg := &Message{
+		Subject: raw.Subject,
+		Event:   eventFromHeader(raw.Header),
+		Raw:     raw,
+		Attempt: 1,
+	}
+	if s.broker.js != nil {
+		if meta, err := raw.Metadata(); err == nil {
+			msg.Attempt = int(meta.NumDelivered)
+			msg.Sequence = meta.Sequence.Stream
+		}
+	}
+
+	msg.Payload = s.newPayload(msg.Event)
+	if err := decode(raw.Header, raw.Data, msg.Payload); err != nil {
+		err = errors.Wrap(err, errors.KindInvalidArgument, "invalid_message", "error decoding message")
+		if s.broker.js != nil {
+			s.terminate(raw, err, msg.Attempt)
+		} else {
+			s.deadLetterMessage(raw, err, msg.Attempt)
+		}
+		return
+	}
+
+	if s.broker.js != nil {
+		s.handleAcknowledged(raw, msg)
+	} else {
+		s.handle(raw, msg)
+	}
+}
+
+// newPayload returns the message decoding the payload, chosen by schema version.
+func (s *subscription) newPayload(event Event) proto.Message {
+	if factory, exists := s.factories[event.SchemaVersion]; exists {
+		return factory()
+	}
+	return s.factory()
+}
+
+func (b *natsBroker) Subscribe(topic string, factory MessageFactory, handler MessageHandler, options ...SubscribeOption) (Subscription, error) {
+	return b.subscribe(topic, "", factory, handler, options)
+}
+
+func (b *natsBroker) QueueSubscribe(topic, queue string, factory MessageFactory, handler MessageHandler, options ...SubscribeOption) (Subscription, error) {
+	return b.subscribe(topic, queue, factory, handler, options)
+}
+
+func (b *natsBroker) subscribe(topic, queue string, factory MessageFactory, handler MessageHandler, options []SubscribeOption) (Subscription, error) {
+	s, err := b.newSubscription(topic, queue, factory, options)
+	if err != nil {
+		return nil, err
+	}
+	s.handler = handler
+	s.process = s.dispatch
+
+	switch {
+	case b.js != nil:
+		err = s.subscribeJetStream()
+	case queue == "":
+		s.sub, err = b.client.Subscribe(topic, s.receive)
+	default:
+		s.sub, err = b.client.QueueSubscribe(topic, queue, s.receive)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error subscribing to %s: %v", topic, err)
+	}
+	if err := b.register(s); err != nil {
+		return nil, err
+	}
+
+	b.logger.Info().
+		Str("topic", topic).
+		Str("queue", queue).
+		Int("workers", s.workers).
+		Str("dead_letter", s.deadLetter).
+		Str("durable", s.durable).
+		Msg("subscribed")
+	return s, nil
+}
+
+func (b *natsBroker) newSubscription(topic, queue string, factory MessageFactory, options []SubscribeOption) (*subscription, error) {
+	if b.client == nil {
+		return nil, fmt.Errorf("nats broker not connected")
+	}
+
+	s := &subscription{
+		broker:          b,
+		topic:           topic,
+		queue:           queue,
+		factory:         factory,
+		workers:         b.workers,
+		pendingLimit:    b.pendingLimit,
+		maxAttempts:     b.maxAttempts,
+		retryBackoff:    b.retryBackoff,
+		retryMaxBackoff: b.retryMaxBackoff,
+		deadLetter:      DeadLetterSubject(topic),
+		ackWait:         b.ackWait,
+	}
+	for _, option := range options {
+		option(s)
+	}
+	if s.workers < 1 || s.pendingLimit < 1 || s.maxAttempts < 1 {
+		return nil, fmt.Errorf("invalid subscription to %s: workers, pending limit and max attempts must be positive", topic)
+	}
+	s.slots = make(chan struct{}, s.workers)
+	return s, nil
+}
+
+// register applies the pending limit of the subscription and tracks it until shutdown.
+func (b *natsBroker) register(s *subscription) error {
+	if err := s.sub.SetPendingLimits(s.pendingLimit, -1); err != nil {
+		s.sub.Unsubscribe()
+		return err
+	}
+
+	b.subscriptionsLock.Lock()
+	b.subscriptions[s] = struct{}{}
+	b.subscriptionsLock.Unlock()
+	return nil
+}
+
+func (b *natsBroker) removeSubscription(s *subscription) {
+	b.subscriptionsLock.Lock()
+	defer b.subscriptionsLock.Unlock()
+	delete(b.subscriptions, s)
+}
+
+// drainSubscriptions drains the subscriptions left open concurrently.
+func (b *natsBroker) drainSubscriptions() {
+	b.subscriptionsLock.Lock()
+	subscriptions := make([]*subscription, 0, len(b.subscriptions))
+	for s := range b.subscriptions {
+		subscriptions = append(subscriptions, s)
+	}
+	b.subscriptionsLock.Unlock()
+
+	var wg sync.WaitGroup
+	for _, s := range subscriptions {
+		wg.Add(1)
+		go func(s *subscription) {
+			defer wg.Done()
+			if err := s.Drain(); err != nil {
+				b.logger.Warn().Err(err).Str("topic", s.topic).Msg("drain error")
+			}
+		}(s)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,210 @@
+package natsbroker
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/duration"
+	"github.com/nats-io/nats.go"
+)
+
+func TestSubscribeDecodesMessages(t *testing.T) {
+	b := newTestBroker(t, runServer(t, false))
+
+	received := make(chan *Message, 1)
+	sub, err := b.Subscribe("test.decode", testFactory, func(msg *Message) error {
+		received <- msg
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.Topic() != "test.decode" {
+		t.Errorf("expected topic test.decode, got %s", sub.Topic())
+	}
+
+	if err := b.Publish("test.decode", testMessage(42), WithEventID("event-1")); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := expectMessages(t, received, 1)[0]
+	if seconds := msg.Payload.(*duration.Duration).Seconds; seconds != 42 {
+		t.Errorf("expected 42, got %d", seconds)
+	}
+	if msg.Subject != "test.decode" || msg.Event.ID != "event-1" || msg.Event.Source != "test" || msg.Attempt != 1 {
+		t.Errorf("unexpected message %+v", msg)
+	}
+}
+
+func TestQueueSubscribe(t *testing.T) {
+	b := newTestBroker(t, runServer(t, false))
+
+	const messages = 20
+	received := make(chan *Message, messages)
+	var first, second int32
+	for _, counter := range []*int32{&first, &second} {
+		counter := counter
+		_, err := b.QueueSubscribe("test.queue", "workers", testFactory, func(msg *Message) error {
+			atomic.AddInt32(counter, 1)
+			received <- msg
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < messages; i++ {
+		if err := b.Publish("test.queue", testMessage(int64(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expectMessages(t, received, messages)
+	select {
+	case msg := <-received:
+		t.Fatalf("expected each message to be handled once, got %v again", msg.Payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if atomic.LoadInt32(&first)+atomic.LoadInt32(&second) != messages {
+		t.Errorf("expected %d messages, got %d and %d", messages, first, second)
+	}
+}
+
+func TestSubscribeInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []SubscribeOption
+	}{
+		{"no workers", []SubscribeOption{WithWorkers(0)}},
+		{"no pending messages", []SubscribeOption{WithPendingLimit(0)}},
+	}
+
+	b := newTestBroker(t, runServer(t, false))
+	for _, test := range tests {
+		if _, err := b.Subscribe("test.invalid", testFactory, func(*Message) error { return nil }, test.options...); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+
+	if _, err := Create().(*natsBroker).Subscribe("test.invalid", testFactory, func(*Message) error { return nil }); err == nil {
+		t.Error("expected an error subscribing before connecting")
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	b := newTestBroker(t, runServer(t, false))
+
+	received := make(chan *Message, 1)
+	sub, err := b.Subscribe("test.unsubscribe", testFactory, func(msg *Message) error {
+		received <- msg
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sub.Unsubscribe(); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.subscriptions) != 0 {
+		t.Errorf("expected the subscription to be removed, got %d", len(b.subscriptions))
+	}
+
+	if err := b.Publish("test.unsubscribe", testMessage(1)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-received:
+		t.Errorf("unexpected message %v", msg.Payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestDrainWaitsForHandlers(t *testing.T) {
+	b := newTestBroker(t, runServer(t, false))
+
+	started := make(chan struct{})
+	var handled int32
+	_, err := b.Subscribe("test.drain", testFactory, func(msg *Message) error {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		atomic.AddInt32(&handled, 1)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Publish("test.drain", testMessage(1)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-started:
+	case <-time.After(testTimeout):
+		t.Fatal("message not received")
+	}
+
+	// Subscriptions left open are drained on shutdown
+	b.drainSubscriptions()
+	if atomic.LoadInt32(&handled) != 1 {
+		t.Error("expected the message being handled to be waited for")
+	}
+	if len(b.subscriptions) != 0 {
+		t.Errorf("expected the subscriptions to be removed, got %d", len(b.subscriptions))
+	}
+}
+
+func TestSlowConsumerReported(t *testing.T) {
+	b := newTestBroker(t, runServer(t, false))
+
+	release := make(chan struct{})
+	defer close(release)
+	_, err := b.Subscribe("test.slow", testFactory, func(msg *Message) error {
+		<-release
+		return nil
+	}, WithWorkers(1), WithPendingLimit(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := b.Publish("test.slow", testMessage(int64(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case err := <-b.asyncErrCh:
+		if err == nil {
+			t.Error("expected an error")
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("expected the dropped messages to be reported")
+	}
+}
+
+func TestFatalAsyncError(t *testing.T) {
+	b := newTestBroker(t, runServer(t, false))
+	sub := subscribeSync(t, b, "test.async")
+
+	tests := []struct {
+		name  string
+		sub   *nats.Subscription
+		err   error
+		fatal bool
+	}{
+		{"slow consumer", sub, nats.ErrSlowConsumer, true},
+		{"slow consumer without subscription", nil, nats.ErrSlowConsumer, false},
+		{"permissions violation", sub, fmt.Errorf("nats: permissions violation for subscription to \"test.async\""), true},
+		{"other error", sub, fmt.Errorf("nats: stale connection"), false},
+	}
+
+	for _, test := range tests {
+		if fatal := b.fatalAsyncError(test.sub, test.err) != nil; fatal != test.fatal {
+			t.Errorf("%s: expected fatal %t, got %t", test.name, test.fatal, fatal)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package outbox
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/golang/protobuf/proto"
+	"github.com/rs/zerolog"
+	"github.com/ubiqueworks/go-clean-architecture/framework"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/cloudstore"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/natsbroker"
+	"github.com/ubiqueworks/go-clean-architecture/framework/util"
+	"gopkg.in/urfave/cli.v1"
+)
+
+const (
+	Component = "outbox"
+
+	sweepInterval = 5 * time.Minute
+
+	envOutboxKind            = "OUTBOX_KIND"
+	envOutboxPollInterval    = "OUTBOX_POLL_INTERVAL"
+	envOutboxBatchSize       = "OUTBOX_BATCH_SIZE"
+	envOutboxLease           = "OUTBOX_LEASE"
+	envOutboxRetryBackoff    = "OUTBOX_RETRY_BACKOFF"
+	envOutboxRetryMaxBackoff = "OUTBOX_RETRY_MAX_BACKOFF"
+	envOutboxRetention       = "OUTBOX_RETENTION"
+
+	flagOutboxKind            = "outbox-kind"
+	flagOutboxPollInterval    = "outbox-poll-interval"
+	flagOutboxBatchSize       = "outbox-batch-size"
+	flagOutboxLease           = "outbox-lease"
+	flagOutboxRetryBackoff    = "outbox-retry-backoff"
+	flagOutboxRetryMaxBackoff = "outbox-retry-max-backoff"
+	flagOutboxRetention       = "outbox-retention"
+)
+
+var cliFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:   flagOutboxKind,
+		EnvVar: envOutboxKind,
+		Value:  "OutboxEvent",
+		Usage:  "datastore kind of the outbox events",
+	},
+	cli.DurationFlag{
+		Name:   flagOutboxPollInterval,
+		EnvVar: envOutboxPollInterval,
+		Value:  time.Second,
+		Usage:  "how often the relay looks for events to publish",
+	},
+	cli.IntFlag{
+		Name:   flagOutboxBatchSize,
+		EnvVar: envOutboxBatchSize,
+		Value:  100,
+		Usage:  "maximum number of events published on each poll",
+	},
+	cli.DurationFlag{
+		Name:   flagOutboxLease,
+		EnvVar: envOutboxLease,
+		Value:  30 * time.Second,
+		Usage:  "how long an event claimed by a relay is hidden from the other replicas",
+	},
+	cli.DurationFlag{
+		Name:   flagOutboxRetryBackoff,
+		EnvVar: envOutboxRetryBackoff,
+		Value:  time.Second,
+		Usage:  "delay before publishing a failed event again, doubled on each attempt",
+	},
+	cli.DurationFlag{
+		Name:   flagOutboxRetryMaxBackoff,
+		EnvVar: envOutboxRetryMaxBackoff,
+		Value:  5 * time.Minute,
+		Usage:  "maximum delay between attempts to publish a failed event",
+	},
+	cli.DurationFlag{
+		Name:   flagOutboxRetention,
+		EnvVar: envOutboxRetention,
+		Value:  24 * time.Hour,
+		Usage:  "how long sent events are kept before being deleted",
+	},
+}
+
+// Create returns the outbox component, relaying the events recorded in datastore transactions to the broker.
+func Create() framework.Component {
+	return &outbox{}
+}
+
+func Get(service framework.Service) (Outbox, error) {
+	component, err := service.Component(Component)
+	if err != nil {
+		return nil, err
+	}
+	return component.(Outbox), nil
+}
+
+type Outbox interface {
+	// Add records the message in the transaction, the relay publishes it to the topic once the transaction is committed.
+	Add(tx *datastore.Transaction, topic string, msg proto.Message) error
+}
+
+type outbox struct {
+	logger          *zerolog.Logger
+	kind            string
+	store           eventStore
+	broker          natsbroker.Broker
+	pollInterval    time.Duration
+	batchSize       int
+	lease           time.Duration
+	retryBackoff    time.Duration
+	retryMaxBackoff time.Duration
+	retention       time.Duration
+}
+
+func (o *outbox) Add(tx *datastore.Transaction, topic string, msg proto.Message) error {
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	return o.store.add(tx, &Event{
+		Key:           datastore.NameKey(o.kind, util.NewUUID(), nil),
+		Topic:         topic,
+		MessageType:   proto.MessageName(msg),
+		Payload:       payload,
+		CreatedAt:     now,
+		NextAttemptAt: now,
+	})
+}
+
+func (o *outbox) ID() string {
+	return Component
+}
+
+func (o *outbox) DependsOn() []string {
+	return []string{
+		cloudstore.Component,
+		natsbroker.Component,
+	}
+}
+
+func (o *outbox) Flags() []cli.Flag {
+	return cliFlags
+}
+
+func (o *outbox) Logger() *zerolog.Logger {
+	return o.logger
+}
+
+func (o *outbox) Configure(service framework.Service, cliCtx *cli.Context) error {
+	logger := service.Logger().With().Str("component", Component).Logger()
+	o.logger = &logger
+
+	o.pollInterval = cliCtx.Duration(flagOutboxPollInterval)
+	o.batchSize = cliCtx.Int(flagOutboxBatchSize)
+	o.lease = cliCtx.Duration(flagOutboxLease)
+	o.retryBackoff = cliCtx.Duration(flagOutboxRetryBackoff)
+	o.retryMaxBackoff = cliCtx.Duration(flagOutboxRetryMaxBackoff)
+	o.retention = cliCtx.Duration(flagOutboxRetention)
+	if o.pollInterval <= 0 || o.lease <= 0 || o.batchSize < 1 || o.batchSize > maxBatchSize {
+		return fmt.Errorf("invalid outbox poll interval %v, lease %v or batch size %d", o.pollInterval, o.lease, o.batchSize)
+	}
+
+	cloudStore, err := cloudstore.Get(service)
+	if err != nil {
+		return err
+	}
+	o.kind = cliCtx.String(flagOutboxKind)
+	o.store = &store{
+		cloudstore: cloudStore,
+		kind:       o.kind,
+	}
+
+	broker, err := natsbroker.Get(service)
+	if err != nil {
+		return err
+	}
+	o.broker = broker
+
+	return nil
+}
+
+func (o *outbox) Initialize(wg *sync.WaitGroup, startedCh chan<- struct{}, shutdownCh <-chan struct{}, errCh chan<- error) {
+	defer wg.Done()
+
+	o.logger.Info().
+		Dur("poll_interval", o.pollInterval).
+		Int("batch_size", o.batchSize).
+		Msg("outbox relay started")
+	close(startedCh)
+
+	pollTicker := time.NewTicker(o.pollInterval)
+	defer pollTicker.Stop()
+	sweepTicker := time.NewTicker(sweepInterval)
+	defer sweepTicker.Stop()
+
+	for {
+		select {
+		case <-pollTicker.C:
+			o.relay(shutdownCh)
+		case <-sweepTicker.C:
+			if err := o.store.sweep(time.Now().Add(-o.retention)); err != nil {
+				o.logger.Error().Err(err).Msg("error deleting sent outbox events")
+			}
+		case <-shutdownCh:
+			o.logger.Info().Msg("outbox relay stopped")
+			return
+		}
+	}
+}
+
+// relay publishes the due events, stopping early on shutdown. Events are published at least once:
+// an event published by a relay that stops before marking it sent is published again after its lease.
+func (o *outbox) relay(shutdownCh <-chan struct{}) {
+	keys, err := o.store.due(time.Now(), o.batchSize)
+	if err != nil {
+		o.logger.Error().Err(err).Msg("error querying outbox events")
+		return
+	}
+
+	for _, key := range keys {
+		select {
+		case <-shutdownCh:
+			return
+		default:
+		}
+
+		now := time.Now()
+		event, err := o.store.claim(key, now, now.Add(o.lease))
+		if err != nil {
+			o.logger.Error().Err(err).Str("event", key.Name).Msg("error claiming outbox event")
+			continue
+		}
+		if event == nil {
+			continue
+		}
+
+		if err := o.publish(event); err != nil {
+			event.Attempts++
+			event.LastError = err.Error()
+			event.NextAttemptAt = time.Now().Add(o.backoff(event.Attempts))
+			o.logger.Warn().
+				Err(err).
+				Str("event", key.Name).
+				Str("topic", event.Topic).
+				Int("attempts", event.Attempts).
+				Time("next_attempt_at", event.NextAttemptAt).
+				Msg("error publishing outbox event")
+		} else {
+			event.Sent = true
+			event.SentAt = time.Now()
+			event.LastError = ""
+		}
+
+		if err := o.store.update(event); err != nil {
+			o.logger.Error().Err(err).Str("event", key.Name).Msg("error updating outbox event")
+		}
+	}
+}
+
+func (o *outbox) publish(event *Event) error {
+	t := proto.MessageType(event.MessageType)
+	if t == nil {
+		return fmt.Errorf("unknown message type %s", event.MessageType)
+	}
+
+	msg := reflect.New(t.Elem()).Interface().(proto.Message)
+	if err := proto.Unmarshal(event.Payload, msg); err != nil {
+		return err
+	}
+	// Relaying the event again keeps its id, so that consumers can recognize the duplicates
+	return o.broker.Publish(event.Topic, msg, natsbroker.WithEventID(event.Key.Name), natsbroker.WithEventTime(event.CreatedAt))
+}
+
+func (o *outbox) backoff(attempts int) time.Duration {
+	d := o.retryBackoff
+	for i := 1; i < attempts && d < o.retryMaxBackoff; i++ {
+		d *= 2
+	}
+	if d > o.retryMaxBackoff {
+		d = o.retryMaxBackoff
+	}
+	return d
+}
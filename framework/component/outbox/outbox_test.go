@@ -0,0 +1,241 @@
+package outbox
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/duration"
+	"github.com/rs/zerolog"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/natsbroker"
+)
+
+// memoryStore keeps the outbox events in memory, claiming them like the datastore store.
+type memoryStore struct {
+	events map[string]Event
+	leases map[string]time.Time
+}
+
+func newMemoryStore(events ...*Event) *memoryStore {
+	s := &memoryStore{
+		events: make(map[string]Event),
+		leases: make(map[string]time.Time),
+	}
+	for _, event := range events {
+		s.events[event.Key.Name] = *event
+	}
+	return s
+}
+
+func (s *memoryStore) add(tx *datastore.Transaction, event *Event) error {
+	s.events[event.Key.Name] = *event
+	return nil
+}
+
+func (s *memoryStore) due(now time.Time, limit int) ([]*datastore.Key, error) {
+	var due []Event
+	for _, event := range s.events {
+		if !event.Sent && !event.NextAttemptAt.After(now) {
+			due = append(due, event)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+	})
+
+	var keys []*datastore.Key
+	for i := 0; i < len(due) && i < limit; i++ {
+		keys = append(keys, due[i].Key)
+	}
+	return keys, nil
+}
+
+func (s *memoryStore) claim(key *datastore.Key, now, until time.Time) (*Event, error) {
+	event, exists := s.events[key.Name]
+	if !exists || event.Sent || event.NextAttemptAt.After(now) {
+		return nil, nil
+	}
+	event.NextAttemptAt = until
+	s.events[key.Name] = event
+	s.leases[key.Name] = until
+	return &event, nil
+}
+
+func (s *memoryStore) update(event *Event) error {
+	s.events[event.Key.Name] = *event
+	return nil
+}
+
+func (s *memoryStore) sweep(before time.Time) error {
+	for name, event := range s.events {
+		if event.Sent && !event.SentAt.After(before) {
+			delete(s.events, name)
+		}
+	}
+	return nil
+}
+
+// testBroker records the published events, failing while err is set.
+type testBroker struct {
+	natsbroker.Broker
+	err       error
+	published []natsbroker.Event
+	payloads  []proto.Message
+}
+
+func (b *testBroker) Publish(topic string, msg proto.Message, options ...natsbroker.PublishOption) error {
+	if b.err != nil {
+		return b.err
+	}
+	var event natsbroker.Event
+	for _, option := range options {
+		option(&event)
+	}
+	b.published = append(b.published, event)
+	b.payloads = append(b.payloads, msg)
+	return nil
+}
+
+func newTestOutbox(store eventStore, broker natsbroker.Broker) *outbox {
+	logger := zerolog.Nop()
+	return &outbox{
+		logger:          &logger,
+		kind:            "OutboxEvent",
+		store:           store,
+		broker:          broker,
+		batchSize:       10,
+		lease:           time.Minute,
+		retryBackoff:    time.Second,
+		retryMaxBackoff: 5 * time.Second,
+	}
+}
+
+func testEvent(t *testing.T, name string, nextAttemptAt time.Time) *Event {
+	t.Helper()
+
+	msg := &duration.Duration{Seconds: 42}
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Event{
+		Key:           datastore.NameKey("OutboxEvent", name, nil),
+		Topic:         "test.events",
+		MessageType:   proto.MessageName(msg),
+		Payload:       payload,
+		CreatedAt:     nextAttemptAt.Add(-time.Minute),
+		NextAttemptAt: nextAttemptAt,
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	o := newTestOutbox(nil, nil)
+
+	tests := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{100, 5 * time.Second},
+	}
+
+	for _, test := range tests {
+		if d := o.backoff(test.attempts); d != test.expected {
+			t.Errorf("%d attempts: expected %v, got %v", test.attempts, test.expected, d)
+		}
+	}
+}
+
+func TestRelay(t *testing.T) {
+	now := time.Now()
+	unknown := testEvent(t, "unknown", now.Add(-time.Second))
+	unknown.MessageType = "test.Unknown"
+	store := newMemoryStore(
+		testEvent(t, "event-1", now.Add(-time.Second)),
+		unknown,
+		testEvent(t, "later", now.Add(time.Hour)),
+	)
+	broker := &testBroker{}
+
+	newTestOutbox(store, broker).relay(make(chan struct{}))
+
+	if len(broker.published) != 1 {
+		t.Fatalf("expected the due event to be published, got %+v", broker.published)
+	}
+	published := broker.published[0]
+	if published.ID != "event-1" || !published.Time.Equal(store.events["event-1"].CreatedAt) {
+		t.Errorf("expected the event to keep its id and time, got %+v", published)
+	}
+	if seconds := broker.payloads[0].(*duration.Duration).Seconds; seconds != 42 {
+		t.Errorf("unexpected payload %v", broker.payloads[0])
+	}
+
+	if event := store.events["event-1"]; !event.Sent || event.SentAt.IsZero() {
+		t.Errorf("expected the event to be sent, got %+v", event)
+	}
+	if lease := store.leases["event-1"]; lease.Before(now.Add(time.Minute)) {
+		t.Errorf("expected the event to be leased while published, got %v", lease)
+	}
+
+	// Events that can't be decoded are retried with backoff
+	if event := store.events["unknown"]; event.Sent || event.Attempts != 1 || event.LastError == "" || event.NextAttemptAt.After(time.Now().Add(time.Second)) {
+		t.Errorf("expected the event to be retried, got %+v", event)
+	}
+	if _, claimed := store.leases["later"]; claimed {
+		t.Error("expected the event not yet due to be left alone")
+	}
+}
+
+func TestRelayRetriesFailedEvents(t *testing.T) {
+	store := newMemoryStore(testEvent(t, "event-1", time.Now()))
+	broker := &testBroker{err: fmt.Errorf("broker unavailable")}
+	o := newTestOutbox(store, broker)
+
+	// Makes the event due again without waiting for its backoff
+	retryNow := func() {
+		event := store.events["event-1"]
+		event.NextAttemptAt = time.Now()
+		store.events["event-1"] = event
+	}
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		retryNow()
+
+		before := time.Now()
+		o.relay(make(chan struct{}))
+
+		event := store.events["event-1"]
+		if event.Sent || event.Attempts != attempt || event.LastError != "broker unavailable" {
+			t.Fatalf("attempt %d: unexpected event %+v", attempt, event)
+		}
+		if next := event.NextAttemptAt.Sub(before); next < o.backoff(attempt) || next > o.backoff(attempt)+time.Second {
+			t.Errorf("attempt %d: expected the next attempt in %v, got %v", attempt, o.backoff(attempt), next)
+		}
+	}
+
+	broker.err = nil
+	retryNow()
+	o.relay(make(chan struct{}))
+	if event := store.events["event-1"]; !event.Sent || event.LastError != "" {
+		t.Errorf("expected the event to be sent, got %+v", event)
+	}
+}
+
+func TestRelayStopsOnShutdown(t *testing.T) {
+	store := newMemoryStore(testEvent(t, "event-1", time.Now()))
+	broker := &testBroker{}
+
+	shutdownCh := make(chan struct{})
+	close(shutdownCh)
+	newTestOutbox(store, broker).relay(shutdownCh)
+
+	if len(broker.published) != 0 || len(store.leases) != 0 {
+		t.Errorf("expected no event to be claimed on shutdown, got %+v", broker.published)
+	}
+}
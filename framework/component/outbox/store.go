@@ -0,0 +1,107 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/cloudstore"
+)
+
+const maxBatchSize = 500
+
+// Event is a message waiting in the outbox to be published to its topic.
+type Event struct {
+	Key           *datastore.Key `datastore:"__key__"`
+	Topic         string
+	MessageType   string `datastore:",noindex"`
+	Payload       []byte `datastore:",noindex"`
+	CreatedAt     time.Time
+	Sent          bool
+	SentAt        time.Time
+	NextAttemptAt time.Time
+	Attempts      int    `datastore:",noindex"`
+	LastError     string `datastore:",noindex"`
+}
+
+// eventStore holds the outbox events, implemented by the datastore store.
+type eventStore interface {
+	add(tx *datastore.Transaction, event *Event) error
+	due(now time.Time, limit int) ([]*datastore.Key, error)
+	claim(key *datastore.Key, now, until time.Time) (*Event, error)
+	update(event *Event) error
+	sweep(before time.Time) error
+}
+
+// store keeps the outbox events in the datastore. Relays on several replicas claim events by pushing
+// their next attempt past the lease, so that an event is published by one replica at a time.
+type store struct {
+	cloudstore cloudstore.Store
+	kind       string
+}
+
+func (s *store) add(tx *datastore.Transaction, event *Event) error {
+	_, err := tx.Put(event.Key, event)
+	return err
+}
+
+// due returns the keys of the events to publish, oldest attempts first.
+func (s *store) due(now time.Time, limit int) ([]*datastore.Key, error) {
+	query := datastore.NewQuery(s.kind).
+		Filter("Sent =", false).
+		Filter("NextAttemptAt <=", now).
+		Order("NextAttemptAt").
+		KeysOnly().
+		Limit(limit)
+
+	return s.cloudstore.Client().GetAll(context.Background(), query, nil)
+}
+
+// claim leases the event until the given time, returning nil when it was sent or claimed in the meantime.
+func (s *store) claim(key *datastore.Key, now, until time.Time) (*Event, error) {
+	var claimed *Event
+	_, err := s.cloudstore.Client().RunInTransaction(context.Background(), func(tx *datastore.Transaction) error {
+		claimed = nil
+
+		var event Event
+		if err := tx.Get(key, &event); err != nil {
+			if err == datastore.ErrNoSuchEntity {
+				return nil
+			}
+			return err
+		}
+		if event.Sent || event.NextAttemptAt.After(now) {
+			return nil
+		}
+
+		event.NextAttemptAt = until
+		if _, err := tx.Put(key, &event); err != nil {
+			return err
+		}
+		claimed = &event
+		return nil
+	})
+	return claimed, err
+}
+
+func (s *store) update(event *Event) error {
+	_, err := s.cloudstore.Client().Put(context.Background(), event.Key, event)
+	return err
+}
+
+// sweep deletes the events sent before the given time.
+func (s *store) sweep(before time.Time) error {
+	client := s.cloudstore.Client()
+
+	query := datastore.NewQuery(s.kind).
+		Filter("Sent =", true).
+		Filter("SentAt <=", before).
+		KeysOnly().
+		Limit(maxBatchSize)
+
+	keys, err := client.GetAll(context.Background(), query, nil)
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+	return client.DeleteMulti(context.Background(), keys)
+}
@@ -0,0 +1,112 @@
+package ratelimit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/natsbroker"
+)
+
+const maxUpdateAttempts = 5
+
+// natsStore keeps the token buckets in a NATS key-value bucket shared by all replicas,
+// using optimistic concurrency on the entry revisions.
+type natsStore struct {
+	broker natsbroker.Broker
+	bucket string
+	ttl    time.Duration
+	lock   sync.Mutex
+	kv     nats.KeyValue
+}
+
+func newNatsStore(broker natsbroker.Broker, bucket string, ttl time.Duration) *natsStore {
+	return &natsStore{
+		broker: broker,
+		bucket: bucket,
+		ttl:    ttl,
+	}
+}
+
+// keyValue binds the key-value bucket on first use, once the broker is connected.
+func (s *natsStore) keyValue() (nats.KeyValue, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.kv != nil {
+		return s.kv, nil
+	}
+
+	client := s.broker.Client()
+	if client == nil {
+		return nil, fmt.Errorf("nats broker not connected")
+	}
+
+	js, err := client.JetStream()
+	if err != nil {
+		return nil, err
+	}
+
+	kv, err := js.KeyValue(s.bucket)
+	if err == nats.ErrBucketNotFound {
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{
+			Bucket: s.bucket,
+			TTL:    s.ttl,
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.kv = kv
+	return kv, nil
+}
+
+func (s *natsStore) Take(key string, rate float64, burst int) (bool, time.Duration, error) {
+	kv, err := s.keyValue()
+	if err != nil {
+		return false, 0, err
+	}
+
+	// Bucket keys are hashed since key-value keys only allow a restricted character set
+	hash := sha256.Sum256([]byte(key))
+	entryKey := hex.EncodeToString(hash[:16])
+
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		var b bucket
+		var revision uint64
+
+		entry, err := kv.Get(entryKey)
+		switch {
+		case err == nats.ErrKeyNotFound:
+		case err != nil:
+			return false, 0, err
+		default:
+			if err := json.Unmarshal(entry.Value(), &b); err != nil {
+				return false, 0, fmt.Errorf("invalid token bucket %s: %v", entryKey, err)
+			}
+			revision = entry.Revision()
+		}
+
+		allowed, wait := b.take(time.Now(), rate, burst)
+		data, err := json.Marshal(&b)
+		if err != nil {
+			return false, 0, err
+		}
+
+		if revision == 0 {
+			_, err = kv.Create(entryKey, data)
+		} else {
+			_, err = kv.Update(entryKey, data, revision)
+		}
+		if err == nil {
+			return allowed, wait, nil
+		}
+		// Another replica updated the bucket in the meantime, retry on its state
+	}
+	return false, 0, fmt.Errorf("too many concurrent updates of token bucket %s", entryKey)
+}
@@ -0,0 +1,218 @@
+package ratelimit
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/ubiqueworks/go-clean-architecture/framework"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/natsbroker"
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
+	"gopkg.in/urfave/cli.v1"
+)
+
+const (
+	Component = "rate-limiter"
+
+	StoreMemory = "memory"
+	StoreNats   = "nats"
+
+	envRateLimitRulesFile  = "RATE_LIMIT_RULES_FILE"
+	envRateLimitStore      = "RATE_LIMIT_STORE"
+	envRateLimitNatsBucket = "RATE_LIMIT_NATS_BUCKET"
+	envRateLimitNatsTTL    = "RATE_LIMIT_NATS_TTL"
+
+	flagRateLimitRulesFile  = "rate-limit-rules-file"
+	flagRateLimitStore      = "rate-limit-store"
+	flagRateLimitNatsBucket = "rate-limit-nats-bucket"
+	flagRateLimitNatsTTL    = "rate-limit-nats-ttl"
+
+	sweepInterval = time.Minute
+)
+
+var cliFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:   flagRateLimitRulesFile,
+		EnvVar: envRateLimitRulesFile,
+		Usage:  "yaml rate limit rules file, its rules take precedence over the ones declared by the service",
+	},
+	cli.StringFlag{
+		Name:   flagRateLimitStore,
+		EnvVar: envRateLimitStore,
+		Value:  StoreMemory,
+		Usage:  "token bucket store (memory, nats), nats shares the limits across replicas",
+	},
+	cli.StringFlag{
+		Name:   flagRateLimitNatsBucket,
+		EnvVar: envRateLimitNatsBucket,
+		Value:  "rate_limits",
+		Usage:  "nats key-value bucket holding the token buckets",
+	},
+	cli.DurationFlag{
+		Name:   flagRateLimitNatsTTL,
+		EnvVar: envRateLimitNatsTTL,
+		Value:  time.Hour,
+		Usage:  "expiry of idle token buckets in the nats store",
+	},
+}
+
+// Create returns the rate limiter component, enforcing the limits declared by the service.
+// The nats store requires the nats broker component.
+func Create(rules ...Rule) framework.Component {
+	return &rateLimiter{
+		rules: rules,
+	}
+}
+
+func Get(service framework.Service) (Limiter, error) {
+	component, err := service.Component(Component)
+	if err != nil {
+		return nil, err
+	}
+	return component.(Limiter), nil
+}
+
+type Limiter interface {
+	LimitHTTP(method, path string, client *Client) error
+	LimitRPC(fullMethod string, client *Client) error
+}
+
+// Client identifies the caller of a request.
+type Client struct {
+	IP        string
+	APIKey    string
+	Principal string
+}
+
+type rateLimiter struct {
+	logger    *zerolog.Logger
+	rules     []Rule
+	fileRules []Rule
+	rulesFile string
+	storeType string
+	store     Store
+}
+
+func (l *rateLimiter) LimitHTTP(method, path string, client *Client) error {
+	return l.limit(client, func(r *Rule) bool {
+		return r.matchHTTP(method, path)
+	})
+}
+
+func (l *rateLimiter) LimitRPC(fullMethod string, client *Client) error {
+	return l.limit(client, func(r *Rule) bool {
+		return r.matchRPC(fullMethod)
+	})
+}
+
+func (l *rateLimiter) limit(client *Client, match func(*Rule) bool) error {
+	// The first matching rule decides, rules file first
+	for _, rules := range [][]Rule{l.fileRules, l.rules} {
+		for i := range rules {
+			if match(&rules[i]) {
+				return l.take(&rules[i], client)
+			}
+		}
+	}
+	return nil
+}
+
+func (l *rateLimiter) take(rule *Rule, client *Client) error {
+	allowed, wait, err := l.store.Take(rule.bucketKey(client), rule.Rate, rule.Burst)
+	if err != nil {
+		// Fail open, an unreachable store must not take the service down with it
+		l.logger.Error().Err(err).Msg("error taking rate limit token")
+		return nil
+	}
+	if allowed {
+		return nil
+	}
+
+	return errors.ResourceExhausted("rate_limited", "rate limit exceeded, retry in %v", wait.Round(time.Millisecond)).
+		WithDetail("key", rule.key()).
+		WithRetryAfter(wait)
+}
+
+func (l *rateLimiter) ID() string {
+	return Component
+}
+
+func (l *rateLimiter) DependsOn() []string {
+	if l.storeType == StoreNats {
+		return []string{natsbroker.Component}
+	}
+	return nil
+}
+
+func (l *rateLimiter) Flags() []cli.Flag {
+	return cliFlags
+}
+
+func (l *rateLimiter) Logger() *zerolog.Logger {
+	return l.logger
+}
+
+func (l *rateLimiter) Configure(service framework.Service, cliCtx *cli.Context) error {
+	logger := service.Logger().With().Str("component", Component).Logger()
+	l.logger = &logger
+
+	for _, rule := range l.rules {
+		if err := rule.validate(); err != nil {
+			return err
+		}
+	}
+
+	if rulesFile := cliCtx.String(flagRateLimitRulesFile); rulesFile != "" {
+		rules, err := loadRules(rulesFile)
+		if err != nil {
+			return err
+		}
+		l.rulesFile = rulesFile
+		l.fileRules = rules
+	}
+
+	l.storeType = cliCtx.String(flagRateLimitStore)
+	switch l.storeType {
+	case StoreMemory:
+		l.store = newMemoryStore()
+	case StoreNats:
+		broker, err := natsbroker.Get(service)
+		if err != nil {
+			return fmt.Errorf("nats rate limit store requires the %s component", natsbroker.Component)
+		}
+		l.store = newNatsStore(broker, cliCtx.String(flagRateLimitNatsBucket), cliCtx.Duration(flagRateLimitNatsTTL))
+	default:
+		return fmt.Errorf("invalid rate limit store: %s", l.storeType)
+	}
+	return nil
+}
+
+func (l *rateLimiter) Initialize(wg *sync.WaitGroup, startedCh chan<- struct{}, shutdownCh <-chan struct{}, errCh chan<- error) {
+	defer wg.Done()
+
+	l.logger.Info().
+		Int("rules", len(l.rules)+len(l.fileRules)).
+		Str("rules_file", l.rulesFile).
+		Str("store", l.storeType).
+		Msg("rate limiting enabled")
+	close(startedCh)
+
+	memory, ok := l.store.(*memoryStore)
+	if !ok {
+		<-shutdownCh
+		return
+	}
+
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			memory.sweep(time.Now())
+		case <-shutdownCh:
+			return
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
+)
+
+// failingStore is a token bucket store that can't be reached.
+type failingStore struct{}
+
+func (failingStore) Take(string, float64, int) (bool, time.Duration, error) {
+	return false, 0, fmt.Errorf("store unavailable")
+}
+
+func newTestLimiter(store Store, rules, fileRules []Rule) *rateLimiter {
+	logger := zerolog.Nop()
+	return &rateLimiter{
+		logger:    &logger,
+		rules:     rules,
+		fileRules: fileRules,
+		store:     store,
+	}
+}
+
+func TestLimit(t *testing.T) {
+	l := newTestLimiter(newMemoryStore(),
+		[]Rule{
+			{HTTP: "POST /messages", Rate: 0.001, Burst: 1},
+			{RPC: "/producer.Producer/*", Rate: 0.001, Burst: 1},
+		},
+		[]Rule{{HTTP: "POST /messages", Key: KeyAPIKey, Rate: 0.001, Burst: 2}},
+	)
+	client := &Client{IP: "203.0.113.7", APIKey: "key-1"}
+
+	// The rules file rule takes precedence, allowing a burst of 2
+	for n := 0; n < 2; n++ {
+		if err := l.LimitHTTP("POST", "/messages", client); err != nil {
+			t.Fatalf("request %d: %v", n, err)
+		}
+	}
+	err := errors.From(l.LimitHTTP("POST", "/messages", client))
+	if err == nil || err.Kind != errors.KindResourceExhausted || err.Code != "rate_limited" {
+		t.Fatalf("expected the request to be rate limited, got %v", err)
+	}
+	if err.RetryAfter <= 0 || err.Details["key"] != KeyAPIKey {
+		t.Errorf("expected the retry delay and key of the rule, got %+v", err)
+	}
+
+	// Other clients and unmatched requests have their own limits
+	if err := l.LimitHTTP("POST", "/messages", &Client{IP: "203.0.113.7", APIKey: "key-2"}); err != nil {
+		t.Errorf("expected another api key to be allowed, got %v", err)
+	}
+	if err := l.LimitHTTP("GET", "/messages", client); err != nil {
+		t.Errorf("expected unmatched requests to be allowed, got %v", err)
+	}
+
+	if err := l.LimitRPC("/producer.Producer/Publish", client); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.LimitRPC("/producer.Producer/Publish", client); errors.KindOf(err) != errors.KindResourceExhausted {
+		t.Errorf("expected the rpc to be rate limited, got %v", err)
+	}
+}
+
+func TestLimitFailsOpen(t *testing.T) {
+	l := newTestLimiter(failingStore{}, []Rule{{HTTP: "POST /messages", Rate: 0.001, Burst: 1}}, nil)
+
+	for n := 0; n < 2; n++ {
+		if err := l.LimitHTTP("POST", "/messages", &Client{IP: "203.0.113.7"}); err != nil {
+			t.Errorf("expected requests to be allowed while the store is unavailable, got %v", err)
+		}
+	}
+}
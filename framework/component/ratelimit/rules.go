@@ -0,0 +1,108 @@
+package ratelimit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	KeyIP        = "ip"
+	KeyAPIKey    = "api_key"
+	KeyPrincipal = "principal"
+)
+
+// Rule limits HTTP routes or RPC methods to Rate requests per second per client, allowing bursts of Burst requests.
+// HTTP patterns are "METHOD /path" with glob path segments, RPC patterns are full method globs.
+// Clients are told apart by Key, falling back to their IP when they don't have one.
+type Rule struct {
+	HTTP  string  `yaml:"http,omitempty"`
+	RPC   string  `yaml:"rpc,omitempty"`
+	Key   string  `yaml:"key,omitempty"`
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+type rulesFile struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+func loadRules(file string) ([]Rule, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var config rulesFile
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid rate limit rules file %s: %v", file, err)
+	}
+
+	for _, rule := range config.Rules {
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("invalid rate limit rules file %s: %v", file, err)
+		}
+	}
+	return config.Rules, nil
+}
+
+func (r *Rule) validate() error {
+	if (r.HTTP == "") == (r.RPC == "") {
+		return fmt.Errorf("rate limit rule needs exactly one of http or rpc")
+	}
+	switch r.Key {
+	case "", KeyIP, KeyAPIKey, KeyPrincipal:
+	default:
+		return fmt.Errorf("invalid rate limit key: %s", r.Key)
+	}
+	if r.Rate <= 0 || r.Burst < 1 {
+		return fmt.Errorf("invalid rate limit for %s%s: rate %v, burst %d", r.HTTP, r.RPC, r.Rate, r.Burst)
+	}
+	return nil
+}
+
+func (r *Rule) matchHTTP(method, urlPath string) bool {
+	if r.HTTP == "" {
+		return false
+	}
+
+	parts := strings.Fields(r.HTTP)
+	if len(parts) != 2 || (parts[0] != "*" && !strings.EqualFold(parts[0], method)) {
+		return false
+	}
+	matched, _ := path.Match(parts[1], urlPath)
+	return matched
+}
+
+func (r *Rule) matchRPC(fullMethod string) bool {
+	if r.RPC == "" {
+		return false
+	}
+	matched, _ := path.Match(r.RPC, fullMethod)
+	return matched
+}
+
+func (r *Rule) key() string {
+	if r.Key == "" {
+		return KeyIP
+	}
+	return r.Key
+}
+
+// bucketKey identifies the token bucket of the client, each rule having its own buckets.
+func (r *Rule) bucketKey(client *Client) string {
+	key, value := r.key(), ""
+	switch key {
+	case KeyAPIKey:
+		value = client.APIKey
+	case KeyPrincipal:
+		value = client.Principal
+	}
+	if value == "" {
+		key, value = KeyIP, client.IP
+	}
+	return fmt.Sprintf("%s%s|%s|%s", r.HTTP, r.RPC, key, value)
+}
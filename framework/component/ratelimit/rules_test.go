@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestRuleValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  Rule
+		valid bool
+	}{
+		{"http", Rule{HTTP: "POST /messages", Rate: 1, Burst: 1}, true},
+		{"rpc with key", Rule{RPC: "/producer.Producer/*", Key: KeyPrincipal, Rate: 0.5, Burst: 10}, true},
+		{"http and rpc", Rule{HTTP: "POST /messages", RPC: "/producer.Producer/*", Rate: 1, Burst: 1}, false},
+		{"neither http nor rpc", Rule{Rate: 1, Burst: 1}, false},
+		{"unknown key", Rule{HTTP: "POST /messages", Key: "user", Rate: 1, Burst: 1}, false},
+		{"no rate", Rule{HTTP: "POST /messages", Burst: 1}, false},
+		{"no burst", Rule{HTTP: "POST /messages", Rate: 1}, false},
+	}
+
+	for _, test := range tests {
+		if err := test.rule.validate(); (err == nil) != test.valid {
+			t.Errorf("%s: expected valid %v, got %v", test.name, test.valid, err)
+		}
+	}
+}
+
+func TestRuleBucketKey(t *testing.T) {
+	client := &Client{IP: "203.0.113.7", APIKey: "key-1", Principal: "user-1"}
+	anonymous := &Client{IP: "203.0.113.7"}
+
+	tests := []struct {
+		rule     Rule
+		client   *Client
+		expected string
+	}{
+		{Rule{HTTP: "POST /messages"}, client, "POST /messages|ip|203.0.113.7"},
+		{Rule{HTTP: "POST /messages", Key: KeyAPIKey}, client, "POST /messages|api_key|key-1"},
+		{Rule{RPC: "/producer.Producer/*", Key: KeyPrincipal}, client, "/producer.Producer/*|principal|user-1"},
+		{Rule{RPC: "/producer.Producer/*", Key: KeyPrincipal}, anonymous, "/producer.Producer/*|ip|203.0.113.7"},
+	}
+
+	for _, test := range tests {
+		if key := test.rule.bucketKey(test.client); key != test.expected {
+			t.Errorf("expected %s, got %s", test.expected, key)
+		}
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	write := func(content string) string {
+		file := filepath.Join(t.TempDir(), "rate-limits.yaml")
+		if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+
+	rules, err := loadRules(write("rules:\n  - http: POST /messages\n    key: api_key\n    rate: 2\n    burst: 5\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].Key != KeyAPIKey || rules[0].Rate != 2 || rules[0].Burst != 5 {
+		t.Errorf("unexpected rules %+v", rules)
+	}
+
+	if _, err := loadRules(write("rules:\n  - http: POST /messages\n    rate: 2\n")); err == nil {
+		t.Error("expected an error for a rule without burst")
+	}
+}
@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+type Store interface {
+	// Take consumes a token from the bucket, returning how long to wait for the next one when it is empty.
+	Take(key string, rate float64, burst int) (bool, time.Duration, error)
+}
+
+type bucket struct {
+	Tokens float64 `json:"tokens"`
+	Last   int64   `json:"last"`
+}
+
+func (b *bucket) take(now time.Time, rate float64, burst int) (bool, time.Duration) {
+	if b.Last == 0 {
+		b.Tokens = float64(burst)
+	} else if elapsed := now.Sub(time.Unix(0, b.Last)); elapsed > 0 {
+		b.Tokens = math.Min(float64(burst), b.Tokens+elapsed.Seconds()*rate)
+	}
+
+	// Never move back in time when replicas clocks disagree
+	if now.UnixNano() > b.Last {
+		b.Last = now.UnixNano()
+	}
+
+	if b.Tokens >= 1 {
+		b.Tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.Tokens) / rate * float64(time.Second))
+}
+
+// full reports whether the bucket has refilled, making it equivalent to a new one.
+func (b *bucket) full(now time.Time, rate float64, burst int) bool {
+	elapsed := now.Sub(time.Unix(0, b.Last)).Seconds()
+	return b.Tokens+elapsed*rate >= float64(burst)
+}
+
+type memoryBucket struct {
+	bucket
+	rate  float64
+	burst int
+}
+
+type memoryStore struct {
+	lock    sync.Mutex
+	buckets map[string]*memoryBucket
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		buckets: make(map[string]*memoryBucket),
+	}
+}
+
+func (s *memoryStore) Take(key string, rate float64, burst int) (bool, time.Duration, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	b, exists := s.buckets[key]
+	if !exists {
+		b = &memoryBucket{}
+		s.buckets[key] = b
+	}
+	b.rate, b.burst = rate, burst
+
+	allowed, wait := b.take(time.Now(), rate, burst)
+	return allowed, wait, nil
+}
+
+// sweep drops the buckets that have refilled so that idle clients don't pile up.
+func (s *memoryStore) sweep(now time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for key, b := range s.buckets {
+		if b.full(now, b.rate, b.burst) {
+			delete(s.buckets, key)
+		}
+	}
+}
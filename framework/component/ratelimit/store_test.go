@@ -0,0 +1,160 @@
+package ratelimit
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/natsbroker"
+)
+
+// testBroker hands the connection to an embedded JetStream server to the nats store.
+type testBroker struct {
+	natsbroker.Broker
+	client *nats.Conn
+}
+
+func (b *testBroker) Client() *nats.Conn {
+	return b.client
+}
+
+func newTestNatsStore(t *testing.T) *natsStore {
+	t.Helper()
+
+	srv, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		NoLog:     true,
+		NoSigs:    true,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server not ready")
+	}
+	t.Cleanup(srv.Shutdown)
+
+	client, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+
+	return newNatsStore(&testBroker{client: client}, "rate_limits", time.Hour)
+}
+
+func TestBucketTake(t *testing.T) {
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		allowed bool
+		wait    time.Duration
+	}{
+		{"first token", 0, true, 0},
+		{"second token", 0, true, 0},
+		{"empty", 0, false, 500 * time.Millisecond},
+		{"partially refilled", 250 * time.Millisecond, false, 250 * time.Millisecond},
+		{"refilled", 500 * time.Millisecond, true, 0},
+		{"clock moved back", -time.Second, false, 250 * time.Millisecond},
+		{"refilled after idle", time.Hour, true, 0},
+		{"second token of the burst", 0, true, 0},
+		{"capped at burst", 0, false, 500 * time.Millisecond},
+	}
+
+	// 2 tokens per second with bursts of 2, each case advancing the clock of the previous one
+	var b bucket
+	now := time.Unix(1000, 0)
+	for _, test := range tests {
+		now = now.Add(test.elapsed)
+		allowed, wait := b.take(now, 2, 2)
+		if allowed != test.allowed || wait != test.wait {
+			t.Errorf("%s: expected %v %v, got %v %v", test.name, test.allowed, test.wait, allowed, wait)
+		}
+	}
+}
+
+func TestBucketFull(t *testing.T) {
+	now := time.Unix(1000, 0)
+	b := bucket{Tokens: 0, Last: now.UnixNano()}
+
+	if b.full(now.Add(time.Second), 1, 2) {
+		t.Error("expected the bucket to be refilling")
+	}
+	if !b.full(now.Add(2*time.Second), 1, 2) {
+		t.Error("expected the bucket to be full")
+	}
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	store := newMemoryStore()
+	store.Take("idle", 1, 1)
+	store.Take("busy", 0.001, 1)
+
+	store.sweep(time.Now().Add(time.Minute))
+	if _, exists := store.buckets["idle"]; exists {
+		t.Error("expected the refilled bucket to be dropped")
+	}
+	if _, exists := store.buckets["busy"]; !exists {
+		t.Error("expected the refilling bucket to be kept")
+	}
+}
+
+func TestStores(t *testing.T) {
+	stores := []struct {
+		name  string
+		store func(t *testing.T) Store
+	}{
+		{"memory", func(t *testing.T) Store { return newMemoryStore() }},
+		{"nats", func(t *testing.T) Store { return newTestNatsStore(t) }},
+	}
+
+	for _, test := range stores {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			store := test.store(t)
+
+			for n := 0; n < 3; n++ {
+				if allowed, _, err := store.Take("key", 0.001, 3); err != nil || !allowed {
+					t.Fatalf("expected token %d to be taken, got %v %v", n, allowed, err)
+				}
+			}
+			allowed, wait, err := store.Take("key", 0.001, 3)
+			if err != nil || allowed || wait <= 0 {
+				t.Errorf("expected the bucket to be empty, got %v %v %v", allowed, wait, err)
+			}
+			if allowed, _, _ := store.Take("other key", 0.001, 3); !allowed {
+				t.Error("expected keys to have their own bucket")
+			}
+
+			// Concurrent takes never hand out more tokens than the burst
+			var wg sync.WaitGroup
+			var lock sync.Mutex
+			taken := 0
+			for n := 0; n < 4; n++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					allowed, _, err := store.Take("concurrent", 0.001, 2)
+					if err != nil {
+						t.Error(err)
+					}
+					if allowed {
+						lock.Lock()
+						taken++
+						lock.Unlock()
+					}
+				}()
+			}
+			wg.Wait()
+			if taken != 2 {
+				t.Errorf("expected 2 concurrent tokens, got %d", taken)
+			}
+		})
+	}
+}
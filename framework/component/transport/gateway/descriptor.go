@@ -0,0 +1,126 @@
+package microgateway
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"google.golang.org/grpc"
+)
+
+// lookupMethod finds the request and response types of a registered RPC method through its proto descriptor.
+func lookupMethod(server *grpc.Server, serviceName, fullMethod string) (*methodTypes, error) {
+	info, exists := server.GetServiceInfo()[serviceName]
+	if !exists {
+		return nil, fmt.Errorf("rpc service not registered: %s", serviceName)
+	}
+
+	fileName, _ := info.Metadata.(string)
+	fd, err := fileDescriptor(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, svc := range fd.GetService() {
+		if qualifiedName(fd.GetPackage(), svc.GetName()) != serviceName {
+			continue
+		}
+
+		for _, method := range svc.GetMethod() {
+			if fmt.Sprintf("/%s/%s", serviceName, method.GetName()) != fullMethod {
+				continue
+			}
+
+			request, err := messageType(method.GetInputType())
+			if err != nil {
+				return nil, err
+			}
+			response, err := messageType(method.GetOutputType())
+			if err != nil {
+				return nil, err
+			}
+			return &methodTypes{
+				request:  request,
+				response: response,
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("rpc method not found: %s", fullMethod)
+}
+
+func fileDescriptor(name string) (*descriptor.FileDescriptorProto, error) {
+	compressed := proto.FileDescriptor(name)
+	if compressed == nil {
+		return nil, fmt.Errorf("proto file not registered: %s", name)
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	fd := &descriptor.FileDescriptorProto{}
+	if err := proto.Unmarshal(data, fd); err != nil {
+		return nil, err
+	}
+	return fd, nil
+}
+
+func messageType(name string) (reflect.Type, error) {
+	t := proto.MessageType(strings.TrimPrefix(name, "."))
+	if t == nil {
+		return nil, fmt.Errorf("proto message not registered: %s", name)
+	}
+	return t, nil
+}
+
+func qualifiedName(pkg, name string) string {
+	if pkg == "" {
+		return name
+	}
+	return pkg + "." + name
+}
+
+// fieldType returns the Go type of a message field, the path being made of JSON or proto field names.
+func fieldType(t reflect.Type, path string) (reflect.Type, error) {
+	for _, name := range strings.Split(path, ".") {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			return nil, fmt.Errorf("invalid field path %s", path)
+		}
+
+		var field reflect.Type
+		for i := 0; i < t.NumField() && field == nil; i++ {
+			f := t.Field(i)
+			for _, part := range strings.Split(f.Tag.Get("protobuf"), ",") {
+				if part == "name="+name || part == "json="+name {
+					field = f.Type
+					break
+				}
+			}
+		}
+		if field == nil {
+			return nil, fmt.Errorf("field %s not found in %s", path, t.Name())
+		}
+		t = field
+	}
+	return t, nil
+}
+
+// sampleValue returns a zero value of the type, used to describe the type in the OpenAPI specification.
+func sampleValue(t reflect.Type) interface{} {
+	return reflect.Zero(t).Interface()
+}
@@ -0,0 +1,66 @@
+package microgateway
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+func TestFieldType(t *testing.T) {
+	file := reflect.TypeOf(&descriptor.FileDescriptorProto{})
+
+	tests := []struct {
+		path     string
+		expected reflect.Type
+	}{
+		{"name", reflect.TypeOf(new(string))},
+		{"message_type", reflect.TypeOf([]*descriptor.DescriptorProto{})},
+		{"messageType", reflect.TypeOf([]*descriptor.DescriptorProto{})},
+		{"options.java_package", reflect.TypeOf(new(string))},
+		{"options.javaPackage", reflect.TypeOf(new(string))},
+		{"unknown", nil},
+		{"name.length", nil},
+	}
+
+	for _, test := range tests {
+		field, err := fieldType(file, test.path)
+		if test.expected == nil {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.path)
+			}
+			continue
+		}
+		if err != nil || field != test.expected {
+			t.Errorf("%s: expected %v, got %v %v", test.path, test.expected, field, err)
+		}
+	}
+}
+
+func TestFileDescriptor(t *testing.T) {
+	fd, err := fileDescriptor("google/protobuf/descriptor.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fd.GetPackage() != "google.protobuf" || len(fd.GetMessageType()) == 0 {
+		t.Errorf("unexpected descriptor %s %d", fd.GetPackage(), len(fd.GetMessageType()))
+	}
+
+	if _, err := fileDescriptor("unknown.proto"); err == nil {
+		t.Error("expected an error for an unregistered file")
+	}
+}
+
+func TestMessageType(t *testing.T) {
+	msgType, err := messageType(".google.protobuf.FileDescriptorProto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgType != reflect.TypeOf(&descriptor.FileDescriptorProto{}) {
+		t.Errorf("unexpected type %v", msgType)
+	}
+
+	if _, err := messageType(".test.Unknown"); err == nil {
+		t.Error("expected an error for an unregistered message")
+	}
+}
@@ -0,0 +1,411 @@
+package microgateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/ubiqueworks/go-clean-architecture/framework"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/transport/http"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/transport/rpc"
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	headerRequestId      = "x-request-id"
+	headerForwardedFor   = "x-forwarded-for"
+	headerMetadataPrefix = "grpc-metadata-"
+	contentTypeJSON      = "application/json; charset=utf-8"
+)
+
+// forwardedHeaders are copied as is to the outgoing RPC metadata.
+var forwardedHeaders = map[string]bool{
+	"authorization":   true,
+	"x-api-key":       true,
+	"idempotency-key": true,
+}
+
+// reservedMetadata are set by the gateway and cannot be sent by clients as grpc-metadata-* headers.
+var reservedMetadata = map[string]bool{
+	headerRequestId:          true,
+	headerForwardedFor:       true,
+	microrpc.HeaderPrincipal: true,
+}
+
+// returnedHeaders are copied from the RPC response metadata to the HTTP response.
+var returnedHeaders = map[string]string{
+	"idempotent-replayed": "Idempotent-Replayed",
+}
+
+// Rule maps an RPC method to an HTTP route, following the google.api.HttpRule conventions.
+type Rule struct {
+	// Selector is the fully qualified RPC method, e.g. handler.ProducerRPC.GetMessages
+	Selector string
+	// Method is the HTTP method of the route
+	Method string
+	// Path is the route template, variables such as {message.name} are mapped to request fields
+	Path string
+	// Body is the request field populated from the HTTP body, "*" for the whole request message
+	Body string
+	// ResponseBody is the response field rendered as the HTTP body, empty for the whole response message
+	ResponseBody string
+	// Status is the HTTP status of successful responses, defaults to 200
+	Status int
+	// EmptyResponse discards the response message, successful responses having no body
+	EmptyResponse bool
+	// OmitDefaults leaves the fields with default values out of the response, repeated fields excepted
+	OmitDefaults bool
+	// Metadata lists the keys that clients can send as grpc-metadata-<key> headers, other such headers are dropped
+	Metadata []string
+	// Summary describes the route in the OpenAPI specification
+	Summary string
+}
+
+// Mount registers HTTP routes on the router that proxy to the service RPC server according to the rules.
+// The routes are documented in the OpenAPI specification of the HTTP server with the RPC message types.
+func Mount(service framework.Service, router gin.IRouter, rules ...Rule) error {
+	server, err := microhttp.Get(service)
+	if err != nil {
+		return err
+	}
+
+	gw := &gateway{
+		service: service,
+		methods: make(map[string]*methodTypes),
+	}
+
+	for _, rule := range rules {
+		r, err := newRoute(rule)
+		if err != nil {
+			return err
+		}
+		server.Handle(router, gw.operation(r), gw.handler(r))
+	}
+	return nil
+}
+
+// operation describes the route, its message types are resolved from the RPC server once it has started.
+func (g *gateway) operation(r *route) microhttp.Operation {
+	method := r.fullMethod[strings.LastIndex(r.fullMethod, "/")+1:]
+	service := r.service[strings.LastIndex(r.service, ".")+1:]
+
+	return microhttp.Operation{
+		Id:      method,
+		Method:  r.rule.Method,
+		Path:    r.ginPath,
+		Summary: r.rule.Summary,
+		Tags:    []string{service},
+		Status:  r.rule.Status,
+		Resolve: func(op *microhttp.Operation) error {
+			_, types, err := g.resolve(r)
+			if err != nil {
+				return err
+			}
+
+			switch r.rule.Body {
+			case "":
+				op.Query = sampleValue(types.request)
+			case "*":
+				op.Request = sampleValue(types.request)
+			default:
+				t, err := fieldType(types.request, r.rule.Body)
+				if err != nil {
+					return err
+				}
+				op.Request = sampleValue(t)
+				op.Query = sampleValue(types.request)
+			}
+
+			if r.rule.EmptyResponse {
+				return nil
+			}
+			op.Response = sampleValue(types.response)
+			if r.rule.ResponseBody != "" {
+				t, err := fieldType(types.response, r.rule.ResponseBody)
+				if err != nil {
+					return err
+				}
+				op.Response = sampleValue(t)
+			}
+			return nil
+		},
+	}
+}
+
+type route struct {
+	rule       Rule
+	service    string
+	fullMethod string
+	ginPath    string
+	pathFields map[string]string
+	metadata   map[string]bool
+}
+
+func newRoute(rule Rule) (*route, error) {
+	idx := strings.LastIndex(rule.Selector, ".")
+	if idx <= 0 {
+		return nil, fmt.Errorf("invalid rpc selector: %s", rule.Selector)
+	}
+	if rule.Method == "" {
+		return nil, fmt.Errorf("missing http method for %s", rule.Selector)
+	}
+	if !strings.HasPrefix(rule.Path, "/") {
+		return nil, fmt.Errorf("invalid http path for %s: %s", rule.Selector, rule.Path)
+	}
+	if rule.Status == 0 {
+		rule.Status = http.StatusOK
+	}
+	if rule.EmptyResponse && rule.ResponseBody != "" {
+		return nil, fmt.Errorf("empty response with a response body for %s", rule.Selector)
+	}
+
+	metadataKeys := make(map[string]bool)
+	for _, key := range rule.Metadata {
+		key = strings.ToLower(key)
+		if reservedMetadata[key] {
+			return nil, fmt.Errorf("reserved metadata key for %s: %s", rule.Selector, key)
+		}
+		metadataKeys[key] = true
+	}
+
+	r := &route{
+		rule:       rule,
+		service:    rule.Selector[:idx],
+		fullMethod: fmt.Sprintf("/%s/%s", rule.Selector[:idx], rule.Selector[idx+1:]),
+		pathFields: make(map[string]string),
+		metadata:   metadataKeys,
+	}
+
+	segments := strings.Split(rule.Path, "/")
+	for i, segment := range segments {
+		if !strings.HasPrefix(segment, "{") || !strings.HasSuffix(segment, "}") {
+			continue
+		}
+
+		field := segment[1 : len(segment)-1]
+		if eq := strings.Index(field, "="); eq >= 0 {
+			field = field[:eq]
+		}
+		param := strings.Replace(field, ".", "_", -1)
+
+		segments[i] = ":" + param
+		r.pathFields[param] = field
+	}
+	r.ginPath = strings.Join(segments, "/")
+
+	return r, nil
+}
+
+type methodTypes struct {
+	request  reflect.Type
+	response reflect.Type
+}
+
+type gateway struct {
+	service framework.Service
+	lock    sync.Mutex
+	conn    *grpc.ClientConn
+	methods map[string]*methodTypes
+}
+
+func (g *gateway) handler(r *route) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		conn, types, err := g.resolve(r)
+		if err != nil {
+			microhttp.RequestLogger(c).Error().Err(err).Msg("error resolving rpc method")
+			microhttp.AbortWithError(c, errors.Wrap(err, errors.KindUnavailable, "rpc_unavailable", "rpc server unavailable"))
+			return
+		}
+
+		req := newMessage(types.request)
+		if err := r.decodeRequest(c, req); err != nil {
+			microhttp.AbortWithError(c, err)
+			return
+		}
+
+		var header metadata.MD
+		resp := newMessage(types.response)
+		if err := conn.Invoke(r.outgoingContext(c), r.fullMethod, req, resp, grpc.Header(&header)); err != nil {
+			microhttp.AbortWithError(c, microrpc.FromStatus(err))
+			return
+		}
+
+		for key, name := range returnedHeaders {
+			if values := header.Get(key); len(values) > 0 {
+				c.Header(name, values[0])
+			}
+		}
+
+		if r.rule.EmptyResponse {
+			c.Status(r.rule.Status)
+			return
+		}
+
+		body, err := r.encodeResponse(resp)
+		if err != nil {
+			microhttp.AbortWithError(c, err)
+			return
+		}
+		c.Data(r.rule.Status, contentTypeJSON, body)
+	}
+}
+
+// resolve lazily connects to the local RPC server and looks up the message types of the route method.
+func (g *gateway) resolve(r *route) (*grpc.ClientConn, *methodTypes, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	server, err := microrpc.Get(g.service)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if g.conn == nil {
+		conn, err := server.DialLocal()
+		if err != nil {
+			return nil, nil, err
+		}
+		g.conn = conn
+	}
+
+	types, exists := g.methods[r.fullMethod]
+	if !exists {
+		types, err = lookupMethod(server.GrpcServer(), r.service, r.fullMethod)
+		if err != nil {
+			return nil, nil, err
+		}
+		g.methods[r.fullMethod] = types
+	}
+	return g.conn, types, nil
+}
+
+func (r *route) decodeRequest(c *gin.Context, msg proto.Message) error {
+	fields := make(map[string]interface{})
+
+	if r.rule.Body != "" && c.Request.Body != nil {
+		var body interface{}
+		if err := json.NewDecoder(c.Request.Body).Decode(&body); err != nil && err != io.EOF {
+			return errors.Wrap(err, errors.KindInvalidArgument, "invalid_body", "invalid request body")
+		}
+
+		if r.rule.Body == "*" {
+			if body != nil {
+				obj, ok := body.(map[string]interface{})
+				if !ok {
+					return errors.InvalidArgument("invalid_body", "request body must be a json object")
+				}
+				fields = obj
+			}
+		} else if body != nil {
+			setField(fields, r.rule.Body, body)
+		}
+	}
+
+	// Query parameters populate the fields not bound to the body
+	if r.rule.Body != "*" {
+		for key, values := range c.Request.URL.Query() {
+			if len(values) == 1 {
+				setField(fields, key, values[0])
+			} else {
+				setField(fields, key, values)
+			}
+		}
+	}
+
+	for param, field := range r.pathFields {
+		setField(fields, field, c.Param(param))
+	}
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return errors.Wrap(err, errors.KindInvalidArgument, "invalid_body", "invalid request body")
+	}
+
+	unmarshaler := &jsonpb.Unmarshaler{AllowUnknownFields: true}
+	if err := unmarshaler.Unmarshal(bytes.NewReader(data), msg); err != nil {
+		return errors.Wrap(err, errors.KindInvalidArgument, "invalid_body", "invalid request body: %v", err)
+	}
+	return nil
+}
+
+func (r *route) encodeResponse(msg proto.Message) ([]byte, error) {
+	marshaler := &jsonpb.Marshaler{EmitDefaults: !r.rule.OmitDefaults}
+
+	var buf bytes.Buffer
+	if err := marshaler.Marshal(&buf, msg); err != nil {
+		return nil, errors.Wrap(err, errors.KindInternal, "invalid_response", "error encoding response")
+	}
+	if r.rule.ResponseBody == "" {
+		return buf.Bytes(), nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(buf.Bytes(), &fields); err != nil {
+		return nil, errors.Wrap(err, errors.KindInternal, "invalid_response", "error encoding response")
+	}
+
+	value, exists := fields[r.rule.ResponseBody]
+	if !exists {
+		// Default values are left out, empty lists are still rendered as such
+		if t, err := fieldType(reflect.TypeOf(msg), r.rule.ResponseBody); err == nil && t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8 {
+			return []byte("[]"), nil
+		}
+		return []byte("null"), nil
+	}
+	return value, nil
+}
+
+func setField(fields map[string]interface{}, path string, value interface{}) {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		nested, ok := fields[part].(map[string]interface{})
+		if !ok {
+			nested = make(map[string]interface{})
+			fields[part] = nested
+		}
+		fields = nested
+	}
+	fields[parts[len(parts)-1]] = value
+}
+
+func (r *route) outgoingContext(c *gin.Context) context.Context {
+	md := metadata.Pairs(
+		headerRequestId, microhttp.RequestId(c),
+		headerForwardedFor, microhttp.ClientIP(c),
+	)
+
+	for name, values := range c.Request.Header {
+		key := strings.ToLower(name)
+		switch {
+		case strings.HasPrefix(key, headerMetadataPrefix):
+			key = strings.TrimPrefix(key, headerMetadataPrefix)
+			if r.metadata[key] {
+				md[key] = append(md[key], values...)
+			}
+		case forwardedHeaders[key]:
+			md[key] = append(md[key], values...)
+		}
+	}
+	ctx := metadata.NewOutgoingContext(c.Request.Context(), md)
+
+	// Client certificates do not reach the RPC server, the principal authenticated by the HTTP server is forwarded instead
+	if principal := microhttp.RequestPrincipal(c); principal != nil {
+		ctx = microrpc.AppendPrincipal(ctx, principal)
+	}
+	return ctx
+}
+
+func newMessage(t reflect.Type) proto.Message {
+	return reflect.New(t.Elem()).Interface().(proto.Message)
+}
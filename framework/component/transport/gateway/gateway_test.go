@@ -0,0 +1,212 @@
+package microgateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
+)
+
+func TestNewRoute(t *testing.T) {
+	tests := []struct {
+		name       string
+		rule       Rule
+		fullMethod string
+		ginPath    string
+		pathFields map[string]string
+	}{
+		{
+			"path variables",
+			Rule{Selector: "handler.ProducerRPC.GetMessage", Method: "GET", Path: "/messages/{message.id}"},
+			"/handler.ProducerRPC/GetMessage",
+			"/messages/:message_id",
+			map[string]string{"message_id": "message.id"},
+		},
+		{
+			"path variable with pattern",
+			Rule{Selector: "handler.ProducerRPC.GetMessage", Method: "GET", Path: "/v1/messages/{message.id=*}/events"},
+			"/handler.ProducerRPC/GetMessage",
+			"/v1/messages/:message_id/events",
+			map[string]string{"message_id": "message.id"},
+		},
+		{
+			"no path variables",
+			Rule{Selector: "ProducerRPC.SendMessage", Method: "POST", Path: "/messages", Body: "*"},
+			"/ProducerRPC/SendMessage",
+			"/messages",
+			map[string]string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, err := newRoute(test.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.fullMethod != test.fullMethod || r.ginPath != test.ginPath {
+				t.Errorf("expected %s %s, got %s %s", test.fullMethod, test.ginPath, r.fullMethod, r.ginPath)
+			}
+			if len(r.pathFields) != len(test.pathFields) {
+				t.Fatalf("expected path fields %v, got %v", test.pathFields, r.pathFields)
+			}
+			for param, field := range test.pathFields {
+				if r.pathFields[param] != field {
+					t.Errorf("expected path fields %v, got %v", test.pathFields, r.pathFields)
+				}
+			}
+			if r.rule.Status != http.StatusOK {
+				t.Errorf("expected the default status, got %d", r.rule.Status)
+			}
+		})
+	}
+}
+
+func TestNewRouteInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+	}{
+		{"selector without method", Rule{Selector: "GetMessage", Method: "GET", Path: "/messages"}},
+		{"missing http method", Rule{Selector: "handler.ProducerRPC.GetMessage", Path: "/messages"}},
+		{"relative path", Rule{Selector: "handler.ProducerRPC.GetMessage", Method: "GET", Path: "messages"}},
+		{"empty response with response body", Rule{Selector: "handler.ProducerRPC.GetMessage", Method: "GET", Path: "/messages", EmptyResponse: true, ResponseBody: "messages"}},
+		{"reserved metadata", Rule{Selector: "handler.ProducerRPC.GetMessage", Method: "GET", Path: "/messages", Metadata: []string{"X-Request-Id"}}},
+	}
+
+	for _, test := range tests {
+		if _, err := newRoute(test.rule); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
+
+// decode serves the request on the route, decoding it into a file descriptor.
+func decode(t *testing.T, rule Rule, req *http.Request) (*descriptor.FileDescriptorProto, error) {
+	t.Helper()
+
+	r, err := newRoute(rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+
+	msg := &descriptor.FileDescriptorProto{}
+	var decodeErr error
+	engine.Handle(rule.Method, r.ginPath, func(c *gin.Context) {
+		decodeErr = r.decodeRequest(c, msg)
+	})
+	engine.ServeHTTP(httptest.NewRecorder(), req)
+	return msg, decodeErr
+}
+
+func TestDecodeRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     Rule
+		method   string
+		url      string
+		body     string
+		expected *descriptor.FileDescriptorProto
+	}{
+		{
+			"path and query",
+			Rule{Selector: "test.Files.Get", Method: "GET", Path: "/files/{name}"},
+			"GET", "/files/test.proto?package=test&dependency=a.proto&dependency=b.proto&options.javaPackage=com.example", "",
+			&descriptor.FileDescriptorProto{
+				Name:       proto.String("test.proto"),
+				Package:    proto.String("test"),
+				Dependency: []string{"a.proto", "b.proto"},
+				Options:    &descriptor.FileOptions{JavaPackage: proto.String("com.example")},
+			},
+		},
+		{
+			"whole body",
+			Rule{Selector: "test.Files.Create", Method: "POST", Path: "/files/{name}", Body: "*"},
+			"POST", "/files/test.proto?package=ignored", `{"package": "test", "syntax": "proto3", "unknown": true}`,
+			&descriptor.FileDescriptorProto{
+				Name:    proto.String("test.proto"),
+				Package: proto.String("test"),
+				Syntax:  proto.String("proto3"),
+			},
+		},
+		{
+			"body field",
+			Rule{Selector: "test.Files.Update", Method: "PUT", Path: "/files/{name}/options", Body: "options"},
+			"PUT", "/files/test.proto/options?package=test", `{"javaPackage": "com.example"}`,
+			&descriptor.FileDescriptorProto{
+				Name:    proto.String("test.proto"),
+				Package: proto.String("test"),
+				Options: &descriptor.FileOptions{JavaPackage: proto.String("com.example")},
+			},
+		},
+		{
+			"empty body",
+			Rule{Selector: "test.Files.Create", Method: "POST", Path: "/files/{name}", Body: "*"},
+			"POST", "/files/test.proto", "",
+			&descriptor.FileDescriptorProto{Name: proto.String("test.proto")},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			msg, err := decode(t, test.rule, httptest.NewRequest(test.method, test.url, strings.NewReader(test.body)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !proto.Equal(msg, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, msg)
+			}
+		})
+	}
+}
+
+func TestDecodeRequestInvalid(t *testing.T) {
+	rule := Rule{Selector: "test.Files.Create", Method: "POST", Path: "/files", Body: "*"}
+
+	for _, body := range []string{`{"name":`, `["test.proto"]`, `{"name": 42}`} {
+		_, err := decode(t, rule, httptest.NewRequest("POST", "/files", strings.NewReader(body)))
+		if e := errors.From(err); e.Kind != errors.KindInvalidArgument || e.Code != "invalid_body" {
+			t.Errorf("%s: expected an invalid body, got %v", body, err)
+		}
+	}
+}
+
+func TestEncodeResponse(t *testing.T) {
+	msg := &descriptor.FileDescriptorProto{
+		Name:       proto.String("test.proto"),
+		Dependency: []string{"a.proto"},
+		Options:    &descriptor.FileOptions{JavaPackage: proto.String("com.example")},
+	}
+
+	tests := []struct {
+		name     string
+		rule     Rule
+		msg      proto.Message
+		expected string
+	}{
+		{"whole message", Rule{OmitDefaults: true}, msg, `{"name":"test.proto","dependency":["a.proto"],"options":{"javaPackage":"com.example"}}`},
+		{"response body", Rule{ResponseBody: "dependency"}, msg, `["a.proto"]`},
+		{"message response body", Rule{ResponseBody: "options", OmitDefaults: true}, msg, `{"javaPackage":"com.example"}`},
+		{"empty list", Rule{ResponseBody: "dependency", OmitDefaults: true}, &descriptor.FileDescriptorProto{}, `[]`},
+		{"missing field", Rule{ResponseBody: "options", OmitDefaults: true}, &descriptor.FileDescriptorProto{}, `null`},
+	}
+
+	for _, test := range tests {
+		body, err := (&route{rule: test.rule}).encodeResponse(test.msg)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if string(body) != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, body)
+		}
+	}
+}
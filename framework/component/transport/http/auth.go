@@ -0,0 +1,75 @@
+package microhttp
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/auth"
+)
+
+const (
+	keyPrincipal = "__principal"
+
+	headerAuthorization = "Authorization"
+	headerApiKey        = "X-Api-Key"
+	bearerPrefix        = "bearer "
+)
+
+func (s *httpServer) authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.authenticator == nil {
+			c.Next()
+			return
+		}
+
+		req := c.Request
+		creds := &auth.Credentials{
+			APIKey: req.Header.Get(headerApiKey),
+		}
+		if authz := req.Header.Get(headerAuthorization); strings.HasPrefix(strings.ToLower(authz), bearerPrefix) {
+			creds.BearerToken = strings.TrimSpace(authz[len(bearerPrefix):])
+		}
+		if req.TLS != nil {
+			creds.PeerCertificates = req.TLS.PeerCertificates
+		}
+
+		principal, err := s.authenticator.Authenticate(creds)
+		if err != nil {
+			c.Header("WWW-Authenticate", "Bearer")
+			AbortWithError(c, err)
+			return
+		}
+
+		if principal != nil {
+			c.Set(keyPrincipal, principal)
+			c.Request = req.WithContext(auth.NewContext(req.Context(), principal))
+
+			logger := RequestLogger(c).With().Str("principal", principal.Subject).Logger()
+			c.Set(keyRequestLogger, &logger)
+		}
+		c.Next()
+	}
+}
+
+func (s *httpServer) authorize() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.authorizer == nil {
+			c.Next()
+			return
+		}
+
+		if err := s.authorizer.AuthorizeHTTP(c.Request.Method, c.Request.URL.Path, RequestPrincipal(c)); err != nil {
+			AbortWithError(c, err)
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequestPrincipal returns the authenticated principal of the request, nil for anonymous requests.
+func RequestPrincipal(c *gin.Context) *auth.Principal {
+	if principal, exists := c.Get(keyPrincipal); exists {
+		return principal.(*auth.Principal)
+	}
+	return nil
+}
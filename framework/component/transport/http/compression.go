@@ -0,0 +1,256 @@
+package microhttp
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
+)
+
+const (
+	encodingGzip    = "gzip"
+	encodingBrotli  = "br"
+	encodingDeflate = "deflate"
+)
+
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+}
+
+var encoders = map[string]func(io.Writer) encoder{
+	encodingGzip: func(w io.Writer) encoder {
+		return gzip.NewWriter(w)
+	},
+	encodingBrotli: func(w io.Writer) encoder {
+		return brotli.NewWriterLevel(w, brotli.DefaultCompression)
+	},
+}
+
+var decoders = map[string]func(io.Reader) (io.Reader, error){
+	encodingGzip: func(r io.Reader) (io.Reader, error) {
+		return gzip.NewReader(r)
+	},
+	encodingDeflate: func(r io.Reader) (io.Reader, error) {
+		return zlib.NewReader(r)
+	},
+	encodingBrotli: func(r io.Reader) (io.Reader, error) {
+		return brotli.NewReader(r), nil
+	},
+}
+
+// compressibleTypes are the content type prefixes worth compressing, other types are usually compressed already.
+var compressibleTypes = []string{
+	"text/",
+	"application/json",
+	"application/problem+json",
+	"application/javascript",
+	"application/xml",
+	"application/yaml",
+	"image/svg+xml",
+}
+
+func (s *httpServer) compress() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		encoding := negotiateEncoding(c.GetHeader("Accept-Encoding"), s.middleware.compression)
+		if encoding == "" || c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+
+		writer := &compressWriter{
+			ResponseWriter: c.Writer,
+			encoding:       encoding,
+			minBytes:       s.middleware.compressionMinBytes,
+		}
+		c.Writer = writer
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+
+		c.Next()
+
+		if err := writer.close(); err != nil {
+			RequestLogger(c).Error().Err(err).Msg("error compressing response")
+		}
+		c.Writer = writer.ResponseWriter
+	}
+}
+
+// negotiateEncoding picks the first supported encoding accepted by the client.
+func negotiateEncoding(acceptEncoding string, supported []string) string {
+	if acceptEncoding == "" || len(supported) == 0 {
+		return ""
+	}
+
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+
+		enabled := true
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); strings.HasPrefix(param, "q=") && err == nil && q == 0 {
+				enabled = false
+			}
+		}
+		accepted[name] = enabled
+	}
+
+	for _, encoding := range supported {
+		if enabled, listed := accepted[encoding]; enabled || !listed && accepted["*"] {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// compressWriter buffers the response until it reaches the minimum size, then compresses it
+// if its content type is compressible. Shorter responses are written as is.
+type compressWriter struct {
+	gin.ResponseWriter
+	encoding      string
+	minBytes      int
+	buf           []byte
+	started       bool
+	headerPending bool
+	encoder       encoder
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if w.started {
+		if w.encoder != nil {
+			return w.encoder.Write(data)
+		}
+		return w.ResponseWriter.Write(data)
+	}
+
+	w.buf = append(w.buf, data...)
+	if len(w.buf) >= w.minBytes {
+		if err := w.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *compressWriter) WriteHeaderNow() {
+	// Headers are final only once it's known whether the body is compressed
+	if !w.started {
+		w.headerPending = true
+		return
+	}
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *compressWriter) Flush() {
+	if !w.started {
+		w.start(len(w.buf) > 0)
+	}
+	if w.encoder != nil {
+		w.encoder.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *compressWriter) start(compress bool) error {
+	w.started = true
+
+	header := w.Header()
+	if compress && header.Get("Content-Encoding") == "" && bodyAllowedForStatus(w.Status()) && compressible(header.Get("Content-Type")) {
+		header.Set("Content-Encoding", w.encoding)
+		header.Del("Content-Length")
+		w.encoder = encoders[w.encoding](w.ResponseWriter)
+	}
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		if w.headerPending {
+			w.ResponseWriter.WriteHeaderNow()
+		}
+		return nil
+	}
+
+	if w.encoder != nil {
+		_, err := w.encoder.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+// close writes out short responses and terminates the compressed stream.
+func (w *compressWriter) close() error {
+	if !w.started {
+		return w.start(false)
+	}
+	if w.encoder != nil {
+		return w.encoder.Close()
+	}
+	return nil
+}
+
+func compressible(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	for _, prefix := range compressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func bodyAllowedForStatus(status int) bool {
+	switch {
+	case status >= 100 && status <= 199:
+		return false
+	case status == http.StatusNoContent, status == http.StatusNotModified:
+		return false
+	}
+	return true
+}
+
+// decompress transparently decodes compressed request bodies, before the body size limit applies.
+func (s *httpServer) decompress() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req := c.Request
+		encoding := strings.ToLower(strings.TrimSpace(req.Header.Get("Content-Encoding")))
+		if !s.middleware.decompression || encoding == "" || encoding == "identity" || req.Body == nil {
+			c.Next()
+			return
+		}
+
+		decoder, supported := decoders[encoding]
+		if !supported {
+			c.AbortWithStatus(http.StatusUnsupportedMediaType)
+			return
+		}
+
+		reader, err := decoder(req.Body)
+		if err != nil {
+			AbortWithError(c, errors.Wrap(err, errors.KindInvalidArgument, "invalid_body", "malformed %s request body", encoding))
+			return
+		}
+
+		req.Body = &decodedBody{Reader: reader, Closer: req.Body}
+		req.Header.Del("Content-Encoding")
+		req.Header.Del("Content-Length")
+		req.ContentLength = -1
+		c.Next()
+	}
+}
+
+type decodedBody struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,96 @@
+package microhttp
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type corsPolicy struct {
+	allowedOrigins   []string
+	allowedMethods   []string
+	allowedHeaders   []string
+	exposedHeaders   []string
+	allowCredentials bool
+	maxAge           time.Duration
+}
+
+func (p *corsPolicy) allowsAnyOrigin() bool {
+	for _, pattern := range p.allowedOrigins {
+		if pattern == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *corsPolicy) allowOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, pattern := range p.allowedOrigins {
+		pattern = strings.ToLower(pattern)
+		if pattern == origin {
+			return true
+		}
+		if strings.Contains(pattern, "*") {
+			if matched, _ := path.Match(pattern, origin); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cors answers preflight requests and adds the CORS headers to the responses of allowed origins.
+func (s *httpServer) cors() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		policy := s.middleware.cors
+		origin := c.GetHeader("Origin")
+		if policy == nil || origin == "" {
+			c.Next()
+			return
+		}
+
+		header := c.Writer.Header()
+		header.Add("Vary", "Origin")
+
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		if !policy.allowOrigin(origin) {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		if policy.allowsAnyOrigin() && !policy.allowCredentials {
+			header.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			header.Set("Access-Control-Allow-Origin", origin)
+		}
+		if policy.allowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if len(policy.exposedHeaders) > 0 {
+				header.Set("Access-Control-Expose-Headers", strings.Join(policy.exposedHeaders, ", "))
+			}
+			c.Next()
+			return
+		}
+
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+		header.Set("Access-Control-Allow-Methods", strings.Join(policy.allowedMethods, ", "))
+		header.Set("Access-Control-Allow-Headers", strings.Join(policy.allowedHeaders, ", "))
+		if policy.maxAge > 0 {
+			header.Set("Access-Control-Max-Age", strconv.FormatInt(int64(policy.maxAge/time.Second), 10))
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
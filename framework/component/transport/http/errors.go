@@ -0,0 +1,241 @@
+package microhttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/validation"
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
+	"gopkg.in/go-playground/validator.v8"
+)
+
+const contentTypeProblem = "application/problem+json"
+
+var statusCodes = map[errors.Kind]int{
+	errors.KindInternal:          http.StatusInternalServerError,
+	errors.KindInvalidArgument:   http.StatusBadRequest,
+	errors.KindNotFound:          http.StatusNotFound,
+	errors.KindConflict:          http.StatusConflict,
+	errors.KindUnavailable:       http.StatusServiceUnavailable,
+	errors.KindUnauthenticated:   http.StatusUnauthorized,
+	errors.KindPermissionDenied:  http.StatusForbidden,
+	errors.KindResourceExhausted: http.StatusTooManyRequests,
+}
+
+// Problem is an RFC 7807 problem details response body.
+type Problem struct {
+	Type      string            `json:"type"`
+	Title     string            `json:"title"`
+	Status    int               `json:"status"`
+	Detail    string            `json:"detail,omitempty"`
+	Instance  string            `json:"instance,omitempty"`
+	RequestId string            `json:"request_id,omitempty"`
+	Code      string            `json:"code,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+	Errors    []ProblemField    `json:"errors,omitempty"`
+
+	retryAfter time.Duration
+}
+
+type ProblemField struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func StatusCode(err error) int {
+	if code, exists := statusCodes[errors.KindOf(err)]; exists {
+		return code
+	}
+	return http.StatusInternalServerError
+}
+
+func NewProblem(err error) *Problem {
+	e := errors.From(err)
+	problem := newStatusProblem(StatusCode(e))
+	problem.Code = e.Code
+	problem.Details = e.Details
+	problem.retryAfter = e.RetryAfter
+
+	// Never leak the cause of internal errors to clients
+	if e.Kind != errors.KindInternal {
+		problem.Detail = e.Message
+	}
+
+	for _, f := range e.Fields {
+		problem.Errors = append(problem.Errors, ProblemField{
+			Field:   f.Field,
+			Message: f.Description,
+		})
+	}
+	return problem
+}
+
+func newStatusProblem(status int) *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+	}
+}
+
+// AbortWithError records err on the context and renders it as a problem response.
+func AbortWithError(c *gin.Context, err error) {
+	c.Error(err)
+	c.Abort()
+	renderProblem(c, NewProblem(err))
+}
+
+// Bind decodes the JSON request body into obj, returning field-level errors when validation fails.
+func Bind(c *gin.Context, obj interface{}) error {
+	if err := c.ShouldBindWith(obj, binding.JSON); err != nil {
+		return bindingError(err, obj)
+	}
+	return nil
+}
+
+func bindingError(err error, obj interface{}) error {
+	e := errors.Wrap(err, errors.KindInvalidArgument, "invalid_body", "invalid request body")
+
+	switch cause := err.(type) {
+	case validator.ValidationErrors:
+		for _, fe := range cause {
+			e.WithField(jsonFieldPath(obj, fe.FieldNamespace), validation.Describe(fe.Tag, fe.Param))
+		}
+		sort.Slice(e.Fields, func(i, j int) bool {
+			return e.Fields[i].Field < e.Fields[j].Field
+		})
+	case *json.UnmarshalTypeError:
+		e.WithField(cause.Field, fmt.Sprintf("must be of type %s", cause.Type))
+	case *json.SyntaxError:
+		e.Message = fmt.Sprintf("malformed request body at offset %d", cause.Offset)
+	}
+	return e
+}
+
+// jsonFieldPath maps the namespace of a struct field, e.g. "Request.Message.Name", to the JSON path of the field.
+func jsonFieldPath(obj interface{}, namespace string) string {
+	names := strings.Split(namespace, ".")
+	if len(names) > 1 {
+		names = names[1:]
+	}
+
+	t := reflect.TypeOf(obj)
+	path := make([]string, 0, len(names))
+	for _, name := range names {
+		// Collection fields are suffixed with the index or key of the item, e.g. "Items[0]"
+		index := ""
+		if i := strings.Index(name, "["); i >= 0 {
+			name, index = name[:i], name[i:]
+		}
+
+		for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Map) {
+			t = t.Elem()
+		}
+		if t == nil || t.Kind() != reflect.Struct {
+			path = append(path, name+index)
+			t = nil
+			continue
+		}
+
+		f, exists := t.FieldByName(name)
+		if !exists {
+			path = append(path, name+index)
+			t = nil
+			continue
+		}
+		path = append(path, fieldName(f, reflect.PtrTo(t).Implements(messageType))+index)
+		t = f.Type
+	}
+	return strings.Join(path, ".")
+}
+
+func renderProblem(c *gin.Context, problem *Problem) {
+	problem.Instance = c.Request.URL.Path
+	if reqId, exists := c.Get(keyRequestId); exists {
+		problem.RequestId = reqId.(string)
+	}
+
+	if problem.retryAfter > 0 {
+		seconds := int64((problem.retryAfter + time.Second - 1) / time.Second)
+		c.Header("Retry-After", strconv.FormatInt(seconds, 10))
+	}
+
+	c.Header("Content-Type", contentTypeProblem)
+	c.JSON(problem.Status, problem)
+}
+
+// problemWriter holds back error status codes flushed without a body so that they can be
+// rendered as problem responses once the handler chain completes.
+type problemWriter struct {
+	gin.ResponseWriter
+	deferred bool
+}
+
+func (w *problemWriter) WriteHeaderNow() {
+	if w.Status() >= http.StatusBadRequest && !w.ResponseWriter.Written() {
+		w.deferred = true
+		return
+	}
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *problemWriter) Written() bool {
+	return w.deferred || w.ResponseWriter.Written()
+}
+
+// problemRenderer renders aborts and errors left on the context as problem responses.
+func problemRenderer() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		writer := &problemWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		c.Next()
+
+		c.Writer = writer.ResponseWriter
+		if writer.ResponseWriter.Written() {
+			return
+		}
+
+		var problem *Problem
+		if len(c.Errors) > 0 {
+			problem = NewProblem(c.Errors.Last().Err)
+		}
+
+		if writer.deferred {
+			status := writer.Status()
+			if problem == nil {
+				problem = newStatusProblem(status)
+			}
+			problem.Status = status
+			problem.Title = http.StatusText(status)
+		}
+
+		if problem != nil {
+			renderProblem(c, problem)
+		}
+	}
+}
+
+func recovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			if r := recover(); r != nil {
+				RequestLogger(c).Error().
+					Interface("panic", r).
+					Str("stack", string(debug.Stack())).
+					Msg("recovered from panic")
+				AbortWithError(c, errors.Internal("panic", "%v", r))
+			}
+		}()
+		c.Next()
+	}
+}
@@ -2,7 +2,10 @@ package microhttp
 
 import (
 	"context"
+	"crypto/tls"
+	"expvar"
 	"fmt"
+	"net"
 	"net/http"
 	"strings"
 	"sync"
@@ -11,6 +14,8 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog"
 	"github.com/ubiqueworks/go-clean-architecture/framework"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/auth"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/ratelimit"
 	"github.com/ubiqueworks/go-clean-architecture/framework/util"
 	"gopkg.in/urfave/cli.v1"
 )
@@ -18,22 +23,120 @@ import (
 const (
 	Component = "http-server"
 
-	envHttpPort  = "HTTP_PORT"
-	flagHttpPort = "http-port"
-
-	keyRequestLogger = "__request_logger"
-	keyRequestId     = "__request_id"
-	headerRequestId  = "x-request-id"
+	envHttpPort              = "HTTP_PORT"
+	envHttpReadTimeout       = "HTTP_READ_TIMEOUT"
+	envHttpReadHeaderTimeout = "HTTP_READ_HEADER_TIMEOUT"
+	envHttpWriteTimeout      = "HTTP_WRITE_TIMEOUT"
+	envHttpIdleTimeout       = "HTTP_IDLE_TIMEOUT"
+	envHttpMaxHeaderBytes    = "HTTP_MAX_HEADER_BYTES"
+	envHttpMaxBodyBytes      = "HTTP_MAX_BODY_BYTES"
+	envHttpTlsCert           = "HTTP_TLS_CERT"
+	envHttpTlsKey            = "HTTP_TLS_KEY"
+	envHttpTlsClientCA       = "HTTP_TLS_CLIENT_CA"
+	envHttpTlsClientAuth     = "HTTP_TLS_CLIENT_AUTH"
+	envHttpDebugVars         = "HTTP_DEBUG_VARS"
+	envHttpTrustedProxies    = "HTTP_TRUSTED_PROXIES"
+
+	flagHttpPort              = "http-port"
+	flagHttpReadTimeout       = "http-read-timeout"
+	flagHttpReadHeaderTimeout = "http-read-header-timeout"
+	flagHttpWriteTimeout      = "http-write-timeout"
+	flagHttpIdleTimeout       = "http-idle-timeout"
+	flagHttpMaxHeaderBytes    = "http-max-header-bytes"
+	flagHttpMaxBodyBytes      = "http-max-body-bytes"
+	flagHttpTlsCert           = "http-tls-cert"
+	flagHttpTlsKey            = "http-tls-key"
+	flagHttpTlsClientCA       = "http-tls-client-ca"
+	flagHttpTlsClientAuth     = "http-tls-client-auth"
+	flagHttpDebugVars         = "http-debug-vars"
+	flagHttpTrustedProxies    = "http-trusted-proxies"
+
+	pathServiceInfo = "/info"
+	pathHealth      = "/health"
+	pathMetrics     = "/debug/vars"
+
+	keyRequestLogger   = "__request_logger"
+	keyRequestId       = "__request_id"
+	keyClientIP        = "__client_ip"
+	headerRequestId    = "x-request-id"
+	headerForwardedFor = "X-Forwarded-For"
 )
 
-var cliFlags = []cli.Flag{
+var cliFlags = append([]cli.Flag{
 	cli.IntFlag{
 		Name:   flagHttpPort,
 		EnvVar: envHttpPort,
 		Value:  framework.DefaultHttpPort,
 		Usage:  "http server port",
 	},
-}
+	cli.DurationFlag{
+		Name:   flagHttpReadTimeout,
+		EnvVar: envHttpReadTimeout,
+		Value:  30 * time.Second,
+		Usage:  "maximum duration for reading the entire request",
+	},
+	cli.DurationFlag{
+		Name:   flagHttpReadHeaderTimeout,
+		EnvVar: envHttpReadHeaderTimeout,
+		Value:  10 * time.Second,
+		Usage:  "maximum duration for reading the request headers",
+	},
+	cli.DurationFlag{
+		Name:   flagHttpWriteTimeout,
+		EnvVar: envHttpWriteTimeout,
+		Value:  30 * time.Second,
+		Usage:  "maximum duration before timing out writes of the response",
+	},
+	cli.DurationFlag{
+		Name:   flagHttpIdleTimeout,
+		EnvVar: envHttpIdleTimeout,
+		Value:  120 * time.Second,
+		Usage:  "maximum duration to wait for the next request on keep-alive connections",
+	},
+	cli.IntFlag{
+		Name:   flagHttpMaxHeaderBytes,
+		EnvVar: envHttpMaxHeaderBytes,
+		Value:  http.DefaultMaxHeaderBytes,
+		Usage:  "maximum size of the request headers in bytes",
+	},
+	cli.Int64Flag{
+		Name:   flagHttpMaxBodyBytes,
+		EnvVar: envHttpMaxBodyBytes,
+		Value:  4 << 20,
+		Usage:  "maximum size of the request body in bytes (0 for unlimited)",
+	},
+	cli.StringFlag{
+		Name:   flagHttpTlsCert,
+		EnvVar: envHttpTlsCert,
+		Usage:  "tls certificate file, enables https when set",
+	},
+	cli.StringFlag{
+		Name:   flagHttpTlsKey,
+		EnvVar: envHttpTlsKey,
+		Usage:  "tls private key file",
+	},
+	cli.StringFlag{
+		Name:   flagHttpTlsClientCA,
+		EnvVar: envHttpTlsClientCA,
+		Usage:  "ca bundle used to verify client certificates, enables mtls when set",
+	},
+	cli.StringFlag{
+		Name:   flagHttpTlsClientAuth,
+		EnvVar: envHttpTlsClientAuth,
+		Value:  "require",
+		Usage:  "client certificate policy when mtls is enabled (require, request)",
+	},
+	cli.BoolFlag{
+		Name:   flagHttpDebugVars,
+		EnvVar: envHttpDebugVars,
+		Usage:  "serve the expvar variables, including the command line and memory stats, on " + pathMetrics,
+	},
+	cli.StringSliceFlag{
+		Name:   flagHttpTrustedProxies,
+		EnvVar: envHttpTrustedProxies,
+		Usage:  "addresses or cidr networks of the proxies trusted to set X-Forwarded-For, the client being the remote address otherwise",
+	},
+}, append(middlewareFlags, openApiFlags...)...)
 
 type InitServerFunc func(framework.Service, framework.Component, *gin.Engine) error
 
@@ -44,10 +147,26 @@ func Create(initFunc InitServerFunc) framework.Component {
 }
 
 type httpServer struct {
-	initFunc InitServerFunc
-	logger   *zerolog.Logger
-	port     int
-	router   *gin.Engine
+	initFunc          InitServerFunc
+	logger            *zerolog.Logger
+	port              int
+	readTimeout       time.Duration
+	readHeaderTimeout time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+	maxHeaderBytes    int
+	maxBodyBytes      int64
+	debugVars         bool
+	trustedProxies    util.TrustedProxies
+	tlsConfig         *tls.Config
+	middleware        *middlewareConfig
+	openApi           *openApiConfig
+	operationsLock    sync.Mutex
+	operations        []*documentedOperation
+	authenticator     auth.Authenticator
+	authorizer        auth.Authorizer
+	limiter           ratelimit.Limiter
+	router            *gin.Engine
 }
 
 func (s *httpServer) ID() string {
@@ -76,6 +195,62 @@ func (s *httpServer) Configure(service framework.Service, cliCtx *cli.Context) e
 	}
 	s.port = httpPort
 
+	s.readTimeout = cliCtx.Duration(flagHttpReadTimeout)
+	s.readHeaderTimeout = cliCtx.Duration(flagHttpReadHeaderTimeout)
+	s.writeTimeout = cliCtx.Duration(flagHttpWriteTimeout)
+	s.idleTimeout = cliCtx.Duration(flagHttpIdleTimeout)
+
+	s.maxHeaderBytes = cliCtx.Int(flagHttpMaxHeaderBytes)
+	if s.maxHeaderBytes <= 0 {
+		return fmt.Errorf("invalid max header bytes: %d", s.maxHeaderBytes)
+	}
+
+	s.maxBodyBytes = cliCtx.Int64(flagHttpMaxBodyBytes)
+	if s.maxBodyBytes < 0 {
+		return fmt.Errorf("invalid max body bytes: %d", s.maxBodyBytes)
+	}
+	s.debugVars = cliCtx.Bool(flagHttpDebugVars)
+
+	trustedProxies, err := util.ParseTrustedProxies(cliCtx.StringSlice(flagHttpTrustedProxies))
+	if err != nil {
+		return err
+	}
+	s.trustedProxies = trustedProxies
+
+	tlsConfig, err := configureTLS(
+		cliCtx.String(flagHttpTlsCert),
+		cliCtx.String(flagHttpTlsKey),
+		cliCtx.String(flagHttpTlsClientCA),
+		cliCtx.String(flagHttpTlsClientAuth),
+	)
+	if err != nil {
+		return err
+	}
+	s.tlsConfig = tlsConfig
+
+	middleware, err := parseMiddlewareConfig(cliCtx)
+	if err != nil {
+		return err
+	}
+	s.middleware = middleware
+
+	s.openApi = &openApiConfig{
+		path:     cliCtx.String(flagHttpOpenApiPath),
+		uiPath:   cliCtx.String(flagHttpOpenApiUiPath),
+		validate: cliCtx.Bool(flagHttpOpenApiValidate),
+	}
+
+	// Authentication and authorization are enabled when the service registers the auth component
+	if authenticator, err := auth.Get(service); err == nil {
+		s.authenticator = authenticator
+	}
+	if authorizer, err := auth.GetAuthorizer(service); err == nil {
+		s.authorizer = authorizer
+	}
+	if limiter, err := ratelimit.Get(service); err == nil {
+		s.limiter = limiter
+	}
+
 	if err := s.configureRouter(service); err != nil {
 		return err
 	}
@@ -86,15 +261,42 @@ func (s *httpServer) Initialize(wg *sync.WaitGroup, startedCh chan<- struct{}, s
 	defer wg.Done()
 
 	server := &http.Server{
-		Addr:    fmt.Sprintf(":%d", s.port),
-		Handler: s.router,
+		Addr:              fmt.Sprintf(":%d", s.port),
+		Handler:           s.router,
+		ReadTimeout:       s.readTimeout,
+		ReadHeaderTimeout: s.readHeaderTimeout,
+		WriteTimeout:      s.writeTimeout,
+		IdleTimeout:       s.idleTimeout,
+		MaxHeaderBytes:    s.maxHeaderBytes,
+		TLSConfig:         s.tlsConfig,
+	}
+
+	// Bind before reporting started so that listen errors abort the bootstrap
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		errCh <- err
+		return
 	}
 
 	go func() {
-		s.logger.Info().Msgf("http listening on %v", server.Addr)
-		close(startedCh)
-		server.ListenAndServe()
+		var err error
+		if s.tlsConfig != nil {
+			s.logger.Info().Msgf("https listening on %v", listener.Addr().String())
+			err = server.ServeTLS(listener, "", "")
+		} else {
+			s.logger.Info().Msgf("http listening on %v", listener.Addr().String())
+			err = server.Serve(listener)
+		}
+
+		// Errors are no longer read once the service is shutting down
+		if err != nil && err != http.ErrServerClosed {
+			select {
+			case errCh <- err:
+			case <-shutdownCh:
+			}
+		}
 	}()
+	close(startedCh)
 
 	doneCh := make(chan struct{}, 1)
 	stopFunc := func() {
@@ -122,7 +324,44 @@ func (s *httpServer) configureRouter(service framework.Service) error {
 	gin.SetMode(gin.ReleaseMode)
 
 	router := gin.New()
-	router.Use(s.requestPreFlight(), gin.Recovery())
+	router.Use(
+		s.requestPreFlight(),
+		problemRenderer(),
+		s.cors(),
+		s.securityHeaders(),
+		s.compress(),
+		recovery(),
+		s.decompress(),
+		s.limitBody(),
+	)
+	s.configureOpenApi(service, router)
+
+	// Health checks of the orchestrator don't authenticate
+	s.Handle(router, Operation{
+		Id:          "GetServiceHealth",
+		Method:      http.MethodGet,
+		Path:        pathHealth,
+		Summary:     "Health of the service components",
+		Description: "Responds with status 503 when a component, e.g. the connection to the message broker, doesn't work.",
+		Tags:        []string{"service"},
+		Response:    framework.Health{},
+		Public:      true,
+	}, serviceHealthHandler(service))
+
+	// Routes registered from here on require authentication and authorization and are rate limited
+	router.Use(s.authenticate(), s.authorize(), s.rateLimit())
+
+	s.Handle(router, Operation{
+		Id:       "GetServiceInfo",
+		Method:   http.MethodGet,
+		Path:     pathServiceInfo,
+		Summary:  "Service build and runtime information",
+		Tags:     []string{"service"},
+		Response: framework.ServiceInfo{},
+	}, serviceInfoHandler(service))
+	if s.debugVars {
+		router.GET(pathMetrics, gin.WrapH(expvar.Handler()))
+	}
 
 	s.logger.Info().Msg("configuring http router...")
 	if err := s.initFunc(service, s, router); err != nil {
@@ -146,8 +385,11 @@ func (s *httpServer) requestPreFlight() gin.HandlerFunc {
 		}
 		c.Set(keyRequestId, reqId)
 
+		// X-Forwarded-For can be set by any client, it is only used when sent by a trusted proxy
+		clientIP := s.trustedProxies.ClientIP(req.RemoteAddr, req.Header[headerForwardedFor])
+		c.Set(keyClientIP, clientIP)
+
 		// Create request logger
-		clientIP := c.ClientIP()
 		method := req.Method
 		path := req.URL.Path
 
@@ -167,11 +409,47 @@ func (s *httpServer) requestPreFlight() gin.HandlerFunc {
 		end := time.Now()
 		latency := end.Sub(start)
 
-		logger.Info().
+		event := logger.Info().
 			Int("status", c.Writer.Status()).
 			Dur("latency", latency).
-			Str("err", c.Errors.ByType(gin.ErrorTypePrivate).String()).
-			Msg("done")
+			Str("err", c.Errors.ByType(gin.ErrorTypePrivate).String())
+		if principal := RequestPrincipal(c); principal != nil {
+			event = event.Str("principal", principal.Subject)
+		}
+		event.Msg("done")
+	}
+}
+
+func (s *httpServer) limitBody() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.maxBodyBytes == 0 || c.Request.Body == nil {
+			c.Next()
+			return
+		}
+
+		if c.Request.ContentLength > s.maxBodyBytes {
+			c.AbortWithStatus(http.StatusRequestEntityTooLarge)
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, s.maxBodyBytes)
+		c.Next()
+	}
+}
+
+func serviceInfoHandler(service framework.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, service.Info())
+	}
+}
+
+func serviceHealthHandler(service framework.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		health := service.Health()
+		status := http.StatusOK
+		if !health.Healthy {
+			status = http.StatusServiceUnavailable
+		}
+		c.JSON(status, health)
 	}
 }
 
@@ -179,6 +457,11 @@ func RequestId(c *gin.Context) string {
 	return c.MustGet(keyRequestId).(string)
 }
 
+// ClientIP returns the address of the client, as reported by trusted proxies.
+func ClientIP(c *gin.Context) string {
+	return c.GetString(keyClientIP)
+}
+
 func RequestLogger(c *gin.Context) *zerolog.Logger {
 	return c.MustGet(keyRequestLogger).(*zerolog.Logger)
 }
@@ -0,0 +1,187 @@
+package microhttp
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/urfave/cli.v1"
+)
+
+const (
+	envHttpCorsAllowedOrigins    = "HTTP_CORS_ALLOWED_ORIGINS"
+	envHttpCorsAllowedMethods    = "HTTP_CORS_ALLOWED_METHODS"
+	envHttpCorsAllowedHeaders    = "HTTP_CORS_ALLOWED_HEADERS"
+	envHttpCorsExposedHeaders    = "HTTP_CORS_EXPOSED_HEADERS"
+	envHttpCorsAllowCredentials  = "HTTP_CORS_ALLOW_CREDENTIALS"
+	envHttpCorsMaxAge            = "HTTP_CORS_MAX_AGE"
+	envHttpSecurityHeaders       = "HTTP_SECURITY_HEADERS"
+	envHttpContentSecurityPolicy = "HTTP_CONTENT_SECURITY_POLICY"
+	envHttpHstsMaxAge            = "HTTP_HSTS_MAX_AGE"
+	envHttpCompression           = "HTTP_COMPRESSION"
+	envHttpCompressionMinBytes   = "HTTP_COMPRESSION_MIN_BYTES"
+	envHttpDecompression         = "HTTP_DECOMPRESSION"
+
+	flagHttpCorsAllowedOrigins    = "http-cors-allowed-origins"
+	flagHttpCorsAllowedMethods    = "http-cors-allowed-methods"
+	flagHttpCorsAllowedHeaders    = "http-cors-allowed-headers"
+	flagHttpCorsExposedHeaders    = "http-cors-exposed-headers"
+	flagHttpCorsAllowCredentials  = "http-cors-allow-credentials"
+	flagHttpCorsMaxAge            = "http-cors-max-age"
+	flagHttpSecurityHeaders       = "http-security-headers"
+	flagHttpContentSecurityPolicy = "http-content-security-policy"
+	flagHttpHstsMaxAge            = "http-hsts-max-age"
+	flagHttpCompression           = "http-compression"
+	flagHttpCompressionMinBytes   = "http-compression-min-bytes"
+	flagHttpDecompression         = "http-decompression"
+)
+
+var (
+	defaultCorsMethods        = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+	defaultCorsHeaders        = []string{"Authorization", "Content-Type", "X-Api-Key", "X-Request-Id", "Idempotency-Key"}
+	defaultCorsExposedHeaders = []string{"X-Request-Id", "Retry-After", "Idempotent-Replayed"}
+)
+
+var middlewareFlags = []cli.Flag{
+	cli.StringSliceFlag{
+		Name:   flagHttpCorsAllowedOrigins,
+		EnvVar: envHttpCorsAllowedOrigins,
+		Usage:  "origins allowed to make cross-origin requests, with * wildcards, enables cors when set",
+	},
+	cli.StringSliceFlag{
+		Name:   flagHttpCorsAllowedMethods,
+		EnvVar: envHttpCorsAllowedMethods,
+		Usage:  fmt.Sprintf("methods allowed in cross-origin requests (default: %s)", strings.Join(defaultCorsMethods, ",")),
+	},
+	cli.StringSliceFlag{
+		Name:   flagHttpCorsAllowedHeaders,
+		EnvVar: envHttpCorsAllowedHeaders,
+		Usage:  fmt.Sprintf("headers allowed in cross-origin requests (default: %s)", strings.Join(defaultCorsHeaders, ",")),
+	},
+	cli.StringSliceFlag{
+		Name:   flagHttpCorsExposedHeaders,
+		EnvVar: envHttpCorsExposedHeaders,
+		Usage:  fmt.Sprintf("response headers exposed to cross-origin requests (default: %s)", strings.Join(defaultCorsExposedHeaders, ",")),
+	},
+	cli.BoolFlag{
+		Name:   flagHttpCorsAllowCredentials,
+		EnvVar: envHttpCorsAllowCredentials,
+		Usage:  "allow cross-origin requests with credentials",
+	},
+	cli.DurationFlag{
+		Name:   flagHttpCorsMaxAge,
+		EnvVar: envHttpCorsMaxAge,
+		Value:  10 * time.Minute,
+		Usage:  "how long browsers may cache preflight responses",
+	},
+	cli.BoolTFlag{
+		Name:   flagHttpSecurityHeaders,
+		EnvVar: envHttpSecurityHeaders,
+		Usage:  "add standard security headers to responses",
+	},
+	cli.StringFlag{
+		Name:   flagHttpContentSecurityPolicy,
+		EnvVar: envHttpContentSecurityPolicy,
+		Value:  "default-src 'none'; frame-ancestors 'none'",
+		Usage:  "content security policy of the responses",
+	},
+	cli.DurationFlag{
+		Name:   flagHttpHstsMaxAge,
+		EnvVar: envHttpHstsMaxAge,
+		Value:  365 * 24 * time.Hour,
+		Usage:  "strict transport security max age when serving https (0 to disable)",
+	},
+	cli.StringSliceFlag{
+		Name:   flagHttpCompression,
+		EnvVar: envHttpCompression,
+		Usage:  "response compression encodings in order of preference (br, gzip), enables compression when set",
+	},
+	cli.IntFlag{
+		Name:   flagHttpCompressionMinBytes,
+		EnvVar: envHttpCompressionMinBytes,
+		Value:  1024,
+		Usage:  "minimum response size in bytes to compress",
+	},
+	cli.BoolFlag{
+		Name:   flagHttpDecompression,
+		EnvVar: envHttpDecompression,
+		Usage:  "accept request bodies compressed with gzip, deflate or br",
+	},
+}
+
+type middlewareConfig struct {
+	cors                  *corsPolicy
+	securityHeaders       bool
+	contentSecurityPolicy string
+	hstsMaxAge            time.Duration
+	compression           []string
+	compressionMinBytes   int
+	decompression         bool
+}
+
+func parseMiddlewareConfig(cliCtx *cli.Context) (*middlewareConfig, error) {
+	config := &middlewareConfig{
+		securityHeaders:       cliCtx.BoolT(flagHttpSecurityHeaders),
+		contentSecurityPolicy: cliCtx.String(flagHttpContentSecurityPolicy),
+		hstsMaxAge:            cliCtx.Duration(flagHttpHstsMaxAge),
+		compressionMinBytes:   cliCtx.Int(flagHttpCompressionMinBytes),
+		decompression:         cliCtx.Bool(flagHttpDecompression),
+	}
+
+	if origins := cliCtx.StringSlice(flagHttpCorsAllowedOrigins); len(origins) > 0 {
+		config.cors = &corsPolicy{
+			allowedOrigins:   origins,
+			allowedMethods:   stringsOrDefault(cliCtx.StringSlice(flagHttpCorsAllowedMethods), defaultCorsMethods),
+			allowedHeaders:   stringsOrDefault(cliCtx.StringSlice(flagHttpCorsAllowedHeaders), defaultCorsHeaders),
+			exposedHeaders:   stringsOrDefault(cliCtx.StringSlice(flagHttpCorsExposedHeaders), defaultCorsExposedHeaders),
+			allowCredentials: cliCtx.Bool(flagHttpCorsAllowCredentials),
+			maxAge:           cliCtx.Duration(flagHttpCorsMaxAge),
+		}
+		if config.cors.allowCredentials && config.cors.allowsAnyOrigin() {
+			return nil, fmt.Errorf("cors credentials can't be allowed for any origin")
+		}
+	}
+
+	for _, encoding := range cliCtx.StringSlice(flagHttpCompression) {
+		encoding = strings.ToLower(strings.TrimSpace(encoding))
+		if _, supported := encoders[encoding]; !supported {
+			return nil, fmt.Errorf("unsupported compression encoding: %s", encoding)
+		}
+		config.compression = append(config.compression, encoding)
+	}
+	if config.compressionMinBytes < 0 {
+		return nil, fmt.Errorf("invalid compression min bytes: %d", config.compressionMinBytes)
+	}
+	return config, nil
+}
+
+// stringsOrDefault works around slice flags appending to their default value.
+func stringsOrDefault(values []string, defaults []string) []string {
+	if len(values) == 0 {
+		return defaults
+	}
+	return values
+}
+
+func (s *httpServer) securityHeaders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !s.middleware.securityHeaders {
+			c.Next()
+			return
+		}
+
+		// Handlers may relax these, e.g. the content security policy of HTML pages
+		header := c.Writer.Header()
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("X-Frame-Options", "DENY")
+		header.Set("Referrer-Policy", "no-referrer")
+		if s.middleware.contentSecurityPolicy != "" {
+			header.Set("Content-Security-Policy", s.middleware.contentSecurityPolicy)
+		}
+		if s.tlsConfig != nil && s.middleware.hstsMaxAge > 0 {
+			header.Set("Strict-Transport-Security", fmt.Sprintf("max-age=%d; includeSubDomains", int64(s.middleware.hstsMaxAge/time.Second)))
+		}
+		c.Next()
+	}
+}
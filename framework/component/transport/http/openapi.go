@@ -0,0 +1,422 @@
+package microhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ubiqueworks/go-clean-architecture/framework"
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
+	"gopkg.in/urfave/cli.v1"
+)
+
+const (
+	envHttpOpenApiPath     = "HTTP_OPENAPI_PATH"
+	envHttpOpenApiUiPath   = "HTTP_OPENAPI_UI_PATH"
+	envHttpOpenApiValidate = "HTTP_OPENAPI_VALIDATE"
+
+	flagHttpOpenApiPath     = "http-openapi-path"
+	flagHttpOpenApiUiPath   = "http-openapi-ui-path"
+	flagHttpOpenApiValidate = "http-openapi-validate"
+
+	openApiVersion   = "3.0.3"
+	swaggerUiVersion = "3.52.5"
+	swaggerUiCdn     = "https://unpkg.com/swagger-ui-dist@" + swaggerUiVersion
+	contentTypeJSON  = "application/json"
+	schemaRefPrefix  = "#/components/schemas/"
+	securityBearer   = "bearerAuth"
+	securityApiKey   = "apiKeyAuth"
+)
+
+var openApiFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:   flagHttpOpenApiPath,
+		EnvVar: envHttpOpenApiPath,
+		Value:  "/openapi.json",
+		Usage:  "path of the openapi specification of the documented routes (empty to disable)",
+	},
+	cli.StringFlag{
+		Name:   flagHttpOpenApiUiPath,
+		EnvVar: envHttpOpenApiUiPath,
+		Value:  "/docs",
+		Usage:  "path of the swagger ui (empty to disable)",
+	},
+	cli.BoolFlag{
+		Name:   flagHttpOpenApiValidate,
+		EnvVar: envHttpOpenApiValidate,
+		Usage:  "validate request bodies of documented routes against their openapi schema",
+	},
+}
+
+// Operation describes a route in the OpenAPI specification of the server.
+type Operation struct {
+	// Id uniquely identifies the operation, e.g. for client generators
+	Id          string
+	Method      string
+	Path        string
+	Summary     string
+	Description string
+	Tags        []string
+	// Status is the status of successful responses, defaults to 200
+	Status int
+	// Request, Query and Response are values of the types bound to the request body,
+	// the query parameters and the response body, nil when there's none
+	Request  interface{}
+	Query    interface{}
+	Response interface{}
+	// Public operations don't require authentication
+	Public bool
+	// Resolve fills in the types only known once the service has started, it's called before they are first needed
+	Resolve func(*Operation) error
+}
+
+type Server interface {
+	// Handle registers the route described by the operation and documents it in the OpenAPI specification.
+	Handle(router gin.IRouter, op Operation, handlers ...gin.HandlerFunc)
+}
+
+func Get(service framework.Service) (Server, error) {
+	component, err := service.Component(Component)
+	if err != nil {
+		return nil, err
+	}
+	return component.(Server), nil
+}
+
+type openApiConfig struct {
+	path     string
+	uiPath   string
+	validate bool
+}
+
+type documentedOperation struct {
+	lock      sync.Mutex
+	op        Operation
+	resolved  bool
+	generator *schemaGenerator
+	request   *Schema
+}
+
+// resolve returns the operation with its runtime types filled in.
+func (d *documentedOperation) resolve() (Operation, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if !d.resolved && d.op.Resolve != nil {
+		if err := d.op.Resolve(&d.op); err != nil {
+			return d.op, err
+		}
+	}
+	d.resolved = true
+	return d.op, nil
+}
+
+// requestSchema returns the schema of the request body, nil when the operation has none.
+func (d *documentedOperation) requestSchema() (*schemaGenerator, *Schema, error) {
+	op, err := d.resolve()
+	if err != nil || op.Request == nil {
+		return nil, nil, err
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.request == nil {
+		d.generator = newSchemaGenerator()
+		d.request = d.generator.schema(typeOf(op.Request))
+	}
+	return d.generator, d.request, nil
+}
+
+func (s *httpServer) Handle(router gin.IRouter, op Operation, handlers ...gin.HandlerFunc) {
+	if group, ok := router.(interface{ BasePath() string }); ok {
+		op.Path = joinPaths(group.BasePath(), op.Path)
+	}
+	if op.Status == 0 {
+		op.Status = http.StatusOK
+	}
+
+	documented := &documentedOperation{op: op}
+	s.operationsLock.Lock()
+	s.operations = append(s.operations, documented)
+	s.operationsLock.Unlock()
+
+	router.Handle(op.Method, op.Path, append([]gin.HandlerFunc{s.validateRequest(documented)}, handlers...)...)
+}
+
+func joinPaths(base, path string) string {
+	if base == "" || base == "/" {
+		return path
+	}
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(path, "/")
+}
+
+// OpenAPI is an OpenAPI 3 document.
+type OpenAPI struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       OpenAPIInfo                             `json:"info"`
+	Paths      map[string]map[string]*OpenAPIOperation `json:"paths"`
+	Components OpenAPIComponents                       `json:"components"`
+	Security   []map[string][]string                   `json:"security,omitempty"`
+}
+
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type OpenAPIOperation struct {
+	Summary     string                      `json:"summary,omitempty"`
+	Description string                      `json:"description,omitempty"`
+	OperationId string                      `json:"operationId,omitempty"`
+	Tags        []string                    `json:"tags,omitempty"`
+	Parameters  []*OpenAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *OpenAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*OpenAPIResponse `json:"responses"`
+	Security    []map[string][]string       `json:"security,omitempty"`
+}
+
+type OpenAPIParameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required,omitempty"`
+	Schema   *Schema `json:"schema"`
+}
+
+type OpenAPIRequestBody struct {
+	Required bool                         `json:"required,omitempty"`
+	Content  map[string]*OpenAPIMediaType `json:"content"`
+}
+
+type OpenAPIResponse struct {
+	Description string                       `json:"description"`
+	Content     map[string]*OpenAPIMediaType `json:"content,omitempty"`
+}
+
+type OpenAPIMediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type OpenAPIComponents struct {
+	Schemas         map[string]*Schema                `json:"schemas,omitempty"`
+	SecuritySchemes map[string]*OpenAPISecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type OpenAPISecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	In           string `json:"in,omitempty"`
+	Name         string `json:"name,omitempty"`
+}
+
+// openApiSpec builds the document of the documented routes.
+func (s *httpServer) openApiSpec(service framework.Service) (*OpenAPI, error) {
+	s.operationsLock.Lock()
+	operations := append([]*documentedOperation(nil), s.operations...)
+	s.operationsLock.Unlock()
+
+	info := service.Info()
+	spec := &OpenAPI{
+		OpenAPI: openApiVersion,
+		Info: OpenAPIInfo{
+			Title:   info.Name,
+			Version: info.Version,
+		},
+		Paths: make(map[string]map[string]*OpenAPIOperation),
+	}
+
+	generator := newSchemaGenerator()
+	problem := generator.schema(problemType)
+
+	if s.authenticator != nil {
+		spec.Components.SecuritySchemes = map[string]*OpenAPISecurityScheme{
+			securityBearer: {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+			securityApiKey: {Type: "apiKey", In: "header", Name: headerApiKey},
+		}
+		spec.Security = []map[string][]string{
+			{securityBearer: {}},
+			{securityApiKey: {}},
+		}
+	}
+
+	for _, documented := range operations {
+		op, err := documented.resolve()
+		if err != nil {
+			return nil, err
+		}
+
+		path, pathParams := openApiPath(op.Path)
+		o := &OpenAPIOperation{
+			Summary:     op.Summary,
+			Description: op.Description,
+			OperationId: op.Id,
+			Tags:        op.Tags,
+			Responses: map[string]*OpenAPIResponse{
+				"default": {
+					Description: "Error",
+					Content:     map[string]*OpenAPIMediaType{contentTypeProblem: {Schema: problem}},
+				},
+			},
+		}
+		if op.Public && s.authenticator != nil {
+			o.Security = []map[string][]string{{}}
+		}
+
+		for _, name := range pathParams {
+			o.Parameters = append(o.Parameters, &OpenAPIParameter{
+				Name:     name,
+				In:       "path",
+				Required: true,
+				Schema:   &Schema{Type: "string"},
+			})
+		}
+		if op.Query != nil {
+			o.Parameters = append(o.Parameters, generator.queryParameters(op.Query, pathParams)...)
+		}
+
+		if op.Request != nil {
+			o.RequestBody = &OpenAPIRequestBody{
+				Required: true,
+				Content:  map[string]*OpenAPIMediaType{contentTypeJSON: {Schema: generator.schema(typeOf(op.Request))}},
+			}
+		}
+
+		response := &OpenAPIResponse{Description: http.StatusText(op.Status)}
+		if op.Response != nil && bodyAllowedForStatus(op.Status) {
+			response.Content = map[string]*OpenAPIMediaType{contentTypeJSON: {Schema: generator.schema(typeOf(op.Response))}}
+		}
+		o.Responses[fmt.Sprintf("%d", op.Status)] = response
+
+		if spec.Paths[path] == nil {
+			spec.Paths[path] = make(map[string]*OpenAPIOperation)
+		}
+		spec.Paths[path][strings.ToLower(op.Method)] = o
+	}
+
+	spec.Components.Schemas = generator.schemas
+	return spec, nil
+}
+
+// openApiPath converts a gin route path to an OpenAPI path template, returning its parameters.
+func openApiPath(path string) (string, []string) {
+	var params []string
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			params = append(params, segment[1:])
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+func (s *httpServer) configureOpenApi(service framework.Service, router gin.IRouter) {
+	if s.openApi.path == "" {
+		return
+	}
+
+	router.GET(s.openApi.path, func(c *gin.Context) {
+		spec, err := s.openApiSpec(service)
+		if err != nil {
+			RequestLogger(c).Error().Err(err).Msg("error generating openapi specification")
+			AbortWithError(c, errors.Wrap(err, errors.KindUnavailable, "openapi_unavailable", "openapi specification unavailable"))
+			return
+		}
+		c.JSON(http.StatusOK, spec)
+	})
+
+	if s.openApi.uiPath == "" {
+		return
+	}
+
+	var page bytes.Buffer
+	err := swaggerUiPage.Execute(&page, map[string]string{
+		"Service":  service.Name(),
+		"Cdn":      swaggerUiCdn,
+		"SpecPath": s.openApi.path,
+	})
+	if err != nil {
+		s.logger.Error().Err(err).Msg("error rendering swagger ui page")
+		return
+	}
+
+	// The page loads the swagger ui assets from their CDN, the trailing slash limits the sources to the pinned version
+	csp := fmt.Sprintf("default-src 'self'; script-src 'self' 'unsafe-inline' %[1]s/; style-src 'self' 'unsafe-inline' %[1]s/; img-src 'self' data:", swaggerUiCdn)
+	router.GET(s.openApi.uiPath, func(c *gin.Context) {
+		c.Header("Content-Security-Policy", csp)
+		c.Data(http.StatusOK, "text/html; charset=utf-8", page.Bytes())
+	})
+}
+
+var swaggerUiPage = template.Must(template.New("swagger-ui").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>{{.Service}} API</title>
+  <link rel="stylesheet" href="{{.Cdn}}/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="{{.Cdn}}/swagger-ui-bundle.js"></script>
+  <script>
+    window.onload = function () {
+      window.ui = SwaggerUIBundle({url: "{{.SpecPath}}", dom_id: "#swagger-ui"});
+    };
+  </script>
+</body>
+</html>
+`))
+
+// validateRequest checks request bodies against the schema of the operation, when validation is enabled.
+func (s *httpServer) validateRequest(documented *documentedOperation) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !s.openApi.validate || c.Request.Body == nil {
+			c.Next()
+			return
+		}
+
+		generator, schema, err := documented.requestSchema()
+		if err != nil || schema == nil {
+			c.Next()
+			return
+		}
+
+		data, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			AbortWithError(c, errors.Wrap(err, errors.KindInvalidArgument, "invalid_body", "error reading request body"))
+			return
+		}
+		c.Request.Body = ioutil.NopCloser(bytes.NewReader(data))
+		if len(bytes.TrimSpace(data)) == 0 {
+			c.Next()
+			return
+		}
+
+		var value interface{}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&value); err != nil {
+			AbortWithError(c, bindingError(err, nil))
+			return
+		}
+
+		if violations := generator.validate(schema, value, ""); len(violations) > 0 {
+			sort.Slice(violations, func(i, j int) bool {
+				return violations[i].Field < violations[j].Field
+			})
+			e := errors.InvalidArgument("invalid_body", "request body doesn't match its schema")
+			e.Fields = violations
+			AbortWithError(c, e)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,39 @@
+package microhttp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOpenApiPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+		params   []string
+	}{
+		{"/messages", "/messages", nil},
+		{"/messages/:id", "/messages/{id}", []string{"id"}},
+		{"/users/:user/messages/:id", "/users/{user}/messages/{id}", []string{"user", "id"}},
+		{"/files/*path", "/files/{path}", []string{"path"}},
+	}
+
+	for _, test := range tests {
+		path, params := openApiPath(test.path)
+		if path != test.expected || !reflect.DeepEqual(params, test.params) {
+			t.Errorf("%s: expected %s %v, got %s %v", test.path, test.expected, test.params, path, params)
+		}
+	}
+}
+
+func TestJoinPaths(t *testing.T) {
+	for _, test := range []struct{ base, path, expected string }{
+		{"", "/messages", "/messages"},
+		{"/", "/messages", "/messages"},
+		{"/api", "/messages", "/api/messages"},
+		{"/api/", "messages", "/api/messages"},
+	} {
+		if path := joinPaths(test.base, test.path); path != test.expected {
+			t.Errorf("%q %q: expected %s, got %s", test.base, test.path, test.expected, path)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package microhttp
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/ratelimit"
+)
+
+func (s *httpServer) rateLimit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.limiter == nil {
+			c.Next()
+			return
+		}
+
+		client := &ratelimit.Client{
+			IP:     ClientIP(c),
+			APIKey: c.GetHeader(headerApiKey),
+		}
+		if principal := RequestPrincipal(c); principal != nil {
+			client.Principal = principal.Subject
+		}
+
+		if err := s.limiter.LimitHTTP(c.Request.Method, c.Request.URL.Path, client); err != nil {
+			AbortWithError(c, err)
+			return
+		}
+		c.Next()
+	}
+}
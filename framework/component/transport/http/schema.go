@@ -0,0 +1,350 @@
+package microhttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/duration"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
+)
+
+const (
+	formatDateTime = "date-time"
+	formatInt64    = "int64"
+)
+
+var (
+	problemType   = reflect.TypeOf(Problem{})
+	timeType      = reflect.TypeOf(time.Time{})
+	timestampType = reflect.TypeOf(timestamp.Timestamp{})
+	durationType  = reflect.TypeOf(duration.Duration{})
+	messageType   = reflect.TypeOf((*proto.Message)(nil)).Elem()
+)
+
+// Schema is an OpenAPI schema object.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+}
+
+func typeOf(value interface{}) reflect.Type {
+	return reflect.TypeOf(value)
+}
+
+// schemaGenerator derives schemas from Go types, following the JSON encoding of protobuf messages
+// for proto types and the json tags otherwise. Structs are collected as named component schemas.
+type schemaGenerator struct {
+	schemas map[string]*Schema
+}
+
+func newSchemaGenerator() *schemaGenerator {
+	return &schemaGenerator{
+		schemas: make(map[string]*Schema),
+	}
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t {
+	case timeType, timestampType:
+		return &Schema{Type: "string", Format: formatDateTime}
+	case durationType:
+		return &Schema{Type: "string", Format: "duration"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return &Schema{Type: "integer", Format: formatInt64}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: g.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schema(t.Elem())}
+	case reflect.Struct:
+		return g.structSchema(t)
+	}
+	return &Schema{}
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) *Schema {
+	name := schemaName(t)
+	if name == "" {
+		s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+		g.addFields(s, t)
+		return s
+	}
+
+	if _, exists := g.schemas[name]; !exists {
+		// Register before the fields so that recursive types refer to themselves
+		s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+		g.schemas[name] = s
+		g.addFields(s, t)
+	}
+	return &Schema{Ref: schemaRefPrefix + name}
+}
+
+func (g *schemaGenerator) addFields(s *Schema, t reflect.Type) {
+	isProto := reflect.PtrTo(t).Implements(messageType)
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || strings.HasPrefix(f.Name, "XXX_") || f.Tag.Get("protobuf_oneof") != "" {
+			continue
+		}
+		if f.Anonymous && f.Tag.Get("json") == "" && f.Type.Kind() == reflect.Struct {
+			g.addFields(s, f.Type)
+			continue
+		}
+
+		name := fieldName(f, isProto)
+		if name == "" {
+			continue
+		}
+
+		fs := g.schema(f.Type)
+		if isProto {
+			fs = protoFieldSchema(f, fs)
+		}
+		s.Properties[name] = fs
+
+		if tags := f.Tag.Get("binding") + "," + f.Tag.Get("validate"); strings.Contains(tags, "required") {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+// protoFieldSchema adjusts a field schema to the JSON mapping of protobuf, which encodes 64 bit integers and enums as strings.
+func protoFieldSchema(f reflect.StructField, s *Schema) *Schema {
+	target := s
+	if s.Type == "array" {
+		target = s.Items
+	}
+
+	if target.Format == formatInt64 {
+		target.Type = "string"
+	}
+
+	for _, part := range strings.Split(f.Tag.Get("protobuf"), ",") {
+		if strings.HasPrefix(part, "enum=") {
+			values := proto.EnumValueMap(strings.TrimPrefix(part, "enum="))
+			target.Type, target.Format, target.Enum = "string", "", nil
+			for value := range values {
+				target.Enum = append(target.Enum, value)
+			}
+			sort.Strings(target.Enum)
+		}
+	}
+	return s
+}
+
+func fieldName(f reflect.StructField, isProto bool) string {
+	if isProto {
+		var name string
+		for _, part := range strings.Split(f.Tag.Get("protobuf"), ",") {
+			switch {
+			case strings.HasPrefix(part, "json="):
+				return strings.TrimPrefix(part, "json=")
+			case strings.HasPrefix(part, "name="):
+				name = strings.TrimPrefix(part, "name=")
+			}
+		}
+		if name != "" {
+			return name
+		}
+	}
+
+	tag := strings.Split(f.Tag.Get("json"), ",")[0]
+	switch tag {
+	case "-":
+		return ""
+	case "":
+		return f.Name
+	}
+	return tag
+}
+
+func schemaName(t reflect.Type) string {
+	if reflect.PtrTo(t).Implements(messageType) {
+		return proto.MessageName(reflect.New(t).Interface().(proto.Message))
+	}
+	return t.Name()
+}
+
+// resolve follows component references.
+func (g *schemaGenerator) resolve(s *Schema) *Schema {
+	for s != nil && s.Ref != "" {
+		s = g.schemas[strings.TrimPrefix(s.Ref, schemaRefPrefix)]
+	}
+	return s
+}
+
+// queryParameters documents the scalar fields of the query type, except the ones bound to the path.
+func (g *schemaGenerator) queryParameters(query interface{}, pathParams []string) []*OpenAPIParameter {
+	s := g.resolve(g.schema(typeOf(query)))
+	if s == nil {
+		return nil
+	}
+
+	excluded := make(map[string]bool)
+	for _, param := range pathParams {
+		excluded[param] = true
+	}
+
+	var names []string
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var params []*OpenAPIParameter
+	for _, name := range names {
+		property := s.Properties[name]
+		scalar := property.Ref == "" && property.Type != "object" && (property.Items == nil || property.Items.Ref == "")
+		if excluded[name] || !scalar {
+			continue
+		}
+		params = append(params, &OpenAPIParameter{
+			Name:   name,
+			In:     "query",
+			Schema: property,
+		})
+	}
+	return params
+}
+
+// validate checks a decoded JSON value against the schema, returning the violations found.
+func (g *schemaGenerator) validate(s *Schema, value interface{}, field string) []errors.FieldViolation {
+	s = g.resolve(s)
+	if s == nil || value == nil {
+		return nil
+	}
+
+	violation := func(format string, args ...interface{}) []errors.FieldViolation {
+		name := field
+		if name == "" {
+			name = "body"
+		}
+		return []errors.FieldViolation{{Field: name, Description: fmt.Sprintf(format, args...)}}
+	}
+
+	switch s.Type {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return violation("must be an object")
+		}
+
+		var violations []errors.FieldViolation
+		for _, name := range s.Required {
+			if _, exists := obj[name]; !exists {
+				violations = append(violations, errors.FieldViolation{Field: joinField(field, name), Description: "is required"})
+			}
+		}
+		for name, v := range obj {
+			if property, exists := s.Properties[name]; exists {
+				violations = append(violations, g.validate(property, v, joinField(field, name))...)
+			} else if s.AdditionalProperties != nil {
+				violations = append(violations, g.validate(s.AdditionalProperties, v, joinField(field, name))...)
+			}
+		}
+		return violations
+
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return violation("must be an array")
+		}
+
+		var violations []errors.FieldViolation
+		for i, item := range items {
+			violations = append(violations, g.validate(s.Items, item, fmt.Sprintf("%s[%d]", field, i))...)
+		}
+		return violations
+
+	case "string":
+		str, ok := value.(string)
+		if n, isNumber := value.(json.Number); isNumber && s.Format == formatInt64 {
+			str, ok = n.String(), true
+		}
+		if !ok {
+			return violation("must be a string")
+		}
+
+		switch {
+		case len(s.Enum) > 0 && !containsString(s.Enum, str):
+			return violation("must be one of %s", strings.Join(s.Enum, ", "))
+		case s.Format == formatDateTime:
+			if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+				return violation("must be an RFC 3339 date-time")
+			}
+		case s.Format == formatInt64:
+			if _, err := strconv.ParseInt(str, 10, 64); err != nil {
+				return violation("must be an integer")
+			}
+		}
+
+	case "integer":
+		n, ok := value.(json.Number)
+		if !ok {
+			return violation("must be an integer")
+		}
+		if _, err := n.Int64(); err != nil {
+			return violation("must be an integer")
+		}
+
+	case "number":
+		if _, ok := value.(json.Number); !ok {
+			return violation("must be a number")
+		}
+
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return violation("must be a boolean")
+		}
+	}
+	return nil
+}
+
+func joinField(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package microhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+type testAddress struct {
+	City string `json:"city" binding:"required"`
+}
+
+type testRequest struct {
+	Name      string            `json:"name" binding:"required"`
+	Count     int64             `json:"count,omitempty"`
+	Tags      []string          `json:"tags"`
+	Labels    map[string]string `json:"labels"`
+	CreatedAt time.Time         `json:"created_at"`
+	Address   *testAddress      `json:"address"`
+	Ignored   string            `json:"-"`
+	internal  string
+}
+
+func TestSchema(t *testing.T) {
+	g := newSchemaGenerator()
+	s := g.schema(reflect.TypeOf(&testRequest{}))
+
+	if s.Ref != schemaRefPrefix+"testRequest" {
+		t.Fatalf("expected a reference to the named struct, got %+v", s)
+	}
+	s = g.resolve(s)
+
+	tests := []struct {
+		property string
+		expected Schema
+	}{
+		{"name", Schema{Type: "string"}},
+		{"count", Schema{Type: "integer", Format: formatInt64}},
+		{"created_at", Schema{Type: "string", Format: formatDateTime}},
+		{"address", Schema{Ref: schemaRefPrefix + "testAddress"}},
+	}
+	for _, test := range tests {
+		if property := s.Properties[test.property]; property == nil || !reflect.DeepEqual(*property, test.expected) {
+			t.Errorf("%s: expected %+v, got %+v", test.property, test.expected, property)
+		}
+	}
+
+	if tags := s.Properties["tags"]; tags.Type != "array" || tags.Items.Type != "string" {
+		t.Errorf("unexpected tags schema %+v", tags)
+	}
+	if labels := s.Properties["labels"]; labels.Type != "object" || labels.AdditionalProperties.Type != "string" {
+		t.Errorf("unexpected labels schema %+v", labels)
+	}
+	for _, name := range []string{"Ignored", "internal"} {
+		if _, exists := s.Properties[name]; exists {
+			t.Errorf("expected %s to be left out", name)
+		}
+	}
+	if len(s.Required) != 1 || s.Required[0] != "name" {
+		t.Errorf("expected name to be required, got %v", s.Required)
+	}
+	if address := g.schemas["testAddress"]; address == nil || address.Required[0] != "city" {
+		t.Errorf("expected the nested struct to be a component, got %+v", address)
+	}
+}
+
+func TestProtoSchema(t *testing.T) {
+	g := newSchemaGenerator()
+	s := g.resolve(g.schema(reflect.TypeOf(&descriptor.FieldDescriptorProto{})))
+	if s == nil || g.schemas["google.protobuf.FieldDescriptorProto"] != s {
+		t.Fatalf("expected the message to be named after its proto name, got %v", g.schemas)
+	}
+
+	if _, exists := s.Properties["jsonName"]; !exists {
+		t.Errorf("expected the json name of the field, got %v", s.Properties)
+	}
+	if number := s.Properties["number"]; number.Type != "integer" {
+		t.Errorf("unexpected number schema %+v", number)
+	}
+	if fieldType := s.Properties["type"]; fieldType.Type != "string" || len(fieldType.Enum) == 0 {
+		t.Errorf("expected the enum values, got %+v", fieldType)
+	}
+
+	option := g.resolve(g.schema(reflect.TypeOf(&descriptor.UninterpretedOption{})))
+	if value := option.Properties["positiveIntValue"]; value.Type != "string" || value.Format != formatInt64 {
+		t.Errorf("expected 64 bit integers to be strings, got %+v", value)
+	}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	g := newSchemaGenerator()
+	s := g.schema(reflect.TypeOf(&testRequest{}))
+
+	tests := []struct {
+		name     string
+		body     string
+		expected []string
+	}{
+		{"valid", `{"name": "test", "count": 3, "tags": ["a"], "created_at": "2020-01-02T03:04:05Z", "address": {"city": "Rome"}}`, nil},
+		{"missing required", `{"count": 3}`, []string{"name"}},
+		{"wrong types", `{"name": 1, "count": "3", "tags": "a"}`, []string{"count", "name", "tags"}},
+		{"nested", `{"name": "test", "tags": [1], "labels": {"a": 1}, "address": {}}`, []string{"address.city", "labels.a", "tags[0]"}},
+		{"invalid date", `{"name": "test", "created_at": "yesterday"}`, []string{"created_at"}},
+		{"not an object", `[]`, []string{"body"}},
+	}
+
+	for _, test := range tests {
+		decoder := json.NewDecoder(bytes.NewReader([]byte(test.body)))
+		decoder.UseNumber()
+		var value interface{}
+		if err := decoder.Decode(&value); err != nil {
+			t.Fatal(err)
+		}
+
+		var fields []string
+		for _, violation := range g.validate(s, value, "") {
+			fields = append(fields, violation.Field)
+		}
+		sort.Strings(fields)
+		if !reflect.DeepEqual(fields, test.expected) {
+			t.Errorf("%s: expected violations of %v, got %v", test.name, test.expected, fields)
+		}
+	}
+}
+
+func TestQueryParameters(t *testing.T) {
+	g := newSchemaGenerator()
+
+	var names []string
+	for _, param := range g.queryParameters(&testRequest{}, []string{"name"}) {
+		names = append(names, param.Name)
+	}
+	if expected := []string{"count", "created_at", "tags"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected the scalar fields not bound to the path %v, got %v", expected, names)
+	}
+}
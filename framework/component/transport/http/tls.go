@@ -0,0 +1,45 @@
+package microhttp
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"github.com/ubiqueworks/go-clean-architecture/framework/util"
+)
+
+func configureTLS(certFile, keyFile, clientCAFile, clientAuth string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" {
+		if clientCAFile != "" {
+			return nil, fmt.Errorf("client certificate verification requires tls to be enabled")
+		}
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("both tls certificate and key are required")
+	}
+
+	reloader, err := util.NewCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	if clientCAFile != "" {
+		pool, err := util.LoadCertPool(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		authType, err := util.ParseClientAuth(clientAuth)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = authType
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,119 @@
+package microrpc
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/auth"
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+const (
+	// HeaderPrincipal carries the principal authenticated by a local transport, e.g. the client certificate of an HTTP request
+	HeaderPrincipal = "x-authenticated-principal"
+
+	headerAuthorization = "authorization"
+	headerApiKey        = "x-api-key"
+	bearerPrefix        = "bearer "
+)
+
+// RequestPrincipal returns the authenticated principal of the call, nil for anonymous calls.
+func RequestPrincipal(ctx context.Context) *auth.Principal {
+	return auth.FromContext(ctx)
+}
+
+func (s *rpcServer) authenticate(ctx context.Context) (context.Context, error) {
+	if s.authenticator == nil {
+		return ctx, nil
+	}
+
+	creds := &auth.Credentials{}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if md != nil {
+		if values := md.Get(headerAuthorization); len(values) > 0 && strings.HasPrefix(strings.ToLower(values[0]), bearerPrefix) {
+			creds.BearerToken = strings.TrimSpace(values[0][len(bearerPrefix):])
+		}
+		if values := md.Get(headerApiKey); len(values) > 0 {
+			creds.APIKey = values[0]
+		}
+	}
+
+	// The local gateway connects with the server certificate, which must not grant its identity to proxied calls
+	local := false
+	if p, ok := peer.FromContext(ctx); ok {
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			local = s.tlsConfig.isLocal(tlsInfo.State.PeerCertificates)
+			if !local {
+				creds.PeerCertificates = tlsInfo.State.PeerCertificates
+			}
+		}
+	}
+
+	var principal *auth.Principal
+	if values := md.Get(HeaderPrincipal); local && len(values) > 0 {
+		principal = &auth.Principal{}
+		if err := json.Unmarshal([]byte(values[0]), principal); err != nil {
+			return ctx, errors.Wrap(err, errors.KindUnauthenticated, "invalid_principal", "invalid forwarded principal")
+		}
+	} else {
+		var err error
+		if principal, err = s.authenticator.Authenticate(creds); err != nil {
+			return ctx, err
+		}
+	}
+
+	if principal != nil {
+		if state, ok := ctx.Value(keyCallState).(*callState); ok {
+			state.principal = principal
+		}
+
+		logger := RequestLogger(ctx).With().Str("principal", principal.Subject).Logger()
+		ctx = context.WithValue(ctx, keyRequestLogger, &logger)
+		ctx = auth.NewContext(ctx, principal)
+	}
+	return ctx, nil
+}
+
+// AppendPrincipal forwards the principal to the calls made with the returned context.
+// The server only honors it on connections opened with DialLocal, other peers must present their own credentials.
+func AppendPrincipal(ctx context.Context, principal *auth.Principal) context.Context {
+	data, err := json.Marshal(principal)
+	if err != nil {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, HeaderPrincipal, string(data))
+}
+
+func (s *rpcServer) authorize(ctx context.Context, fullMethod string) error {
+	if s.authorizer == nil {
+		return nil
+	}
+	return s.authorizer.AuthorizeRPC(fullMethod, RequestPrincipal(ctx))
+}
+
+func (s *rpcServer) authUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *rpcServer) authStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+	if err := s.authorize(ctx, info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+}
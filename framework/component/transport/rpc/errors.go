@@ -0,0 +1,131 @@
+package microrpc
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var statusCodes = map[errors.Kind]codes.Code{
+	errors.KindInternal:          codes.Internal,
+	errors.KindInvalidArgument:   codes.InvalidArgument,
+	errors.KindNotFound:          codes.NotFound,
+	errors.KindConflict:          codes.AlreadyExists,
+	errors.KindUnavailable:       codes.Unavailable,
+	errors.KindUnauthenticated:   codes.Unauthenticated,
+	errors.KindPermissionDenied:  codes.PermissionDenied,
+	errors.KindResourceExhausted: codes.ResourceExhausted,
+}
+
+var statusKinds = map[codes.Code]errors.Kind{
+	codes.InvalidArgument:   errors.KindInvalidArgument,
+	codes.OutOfRange:        errors.KindInvalidArgument,
+	codes.NotFound:          errors.KindNotFound,
+	codes.AlreadyExists:     errors.KindConflict,
+	codes.Aborted:           errors.KindConflict,
+	codes.Unavailable:       errors.KindUnavailable,
+	codes.DeadlineExceeded:  errors.KindUnavailable,
+	codes.Canceled:          errors.KindUnavailable,
+	codes.Unauthenticated:   errors.KindUnauthenticated,
+	codes.PermissionDenied:  errors.KindPermissionDenied,
+	codes.ResourceExhausted: errors.KindResourceExhausted,
+}
+
+func StatusCode(err error) codes.Code {
+	if code, exists := statusCodes[errors.KindOf(err)]; exists {
+		return code
+	}
+	return codes.Internal
+}
+
+// Status converts err to a gRPC status error, leaving existing status errors untouched.
+func Status(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	e := errors.From(err)
+	message := e.Message
+	if e.Kind == errors.KindInternal {
+		// Never leak the cause of internal errors to clients
+		message = "internal error"
+	}
+
+	var details []proto.Message
+	if e.Code != "" {
+		details = append(details, &errdetails.ErrorInfo{
+			Reason:   e.Code,
+			Metadata: e.Details,
+		})
+	}
+	if len(e.Fields) > 0 {
+		badRequest := &errdetails.BadRequest{}
+		for _, f := range e.Fields {
+			badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       f.Field,
+				Description: f.Description,
+			})
+		}
+		details = append(details, badRequest)
+	}
+	if e.RetryAfter > 0 {
+		details = append(details, &errdetails.RetryInfo{
+			RetryDelay: ptypes.DurationProto(e.RetryAfter),
+		})
+	}
+
+	st := status.New(StatusCode(e), message)
+	if detailed, detailErr := st.WithDetails(details...); detailErr == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// FromStatus converts a gRPC status error back to a typed error, including its details.
+func FromStatus(err error) error {
+	st, ok := status.FromError(err)
+	if !ok || err == nil {
+		return err
+	}
+
+	kind, exists := statusKinds[st.Code()]
+	if !exists {
+		kind = errors.KindInternal
+	}
+
+	e := errors.New(kind, "", "%s", st.Message())
+	for _, detail := range st.Details() {
+		switch d := detail.(type) {
+		case *errdetails.ErrorInfo:
+			e.Code = d.Reason
+			e.Details = d.Metadata
+		case *errdetails.BadRequest:
+			for _, v := range d.FieldViolations {
+				e.WithField(v.Field, v.Description)
+			}
+		case *errdetails.RetryInfo:
+			if delay, err := ptypes.Duration(d.RetryDelay); err == nil {
+				e.RetryAfter = delay
+			}
+		}
+	}
+	return e
+}
+
+func errorUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	resp, err := handler(ctx, req)
+	return resp, Status(err)
+}
+
+func errorStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return Status(handler(srv, ss))
+}
@@ -0,0 +1,62 @@
+package microrpc
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	headerIdempotencyKey     = "idempotency-key"
+	headerIdempotentReplayed = "idempotent-replayed"
+)
+
+// idempotencyUnaryInterceptor replays the recorded response of calls retried with the same idempotency key.
+func (s *rpcServer) idempotencyUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if s.idempotency == nil || !s.idempotency.Match(info.FullMethod) {
+		return handler(ctx, req)
+	}
+
+	var key string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(headerIdempotencyKey); len(values) > 0 {
+			key = values[0]
+		}
+	}
+
+	msg, ok := req.(proto.Message)
+	if key == "" || !ok {
+		return handler(ctx, req)
+	}
+
+	request, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keys are scoped to the method and the principal so that clients can't replay each other's responses
+	scope := info.FullMethod
+	if principal := RequestPrincipal(ctx); principal != nil {
+		scope += "|" + principal.Subject
+	}
+
+	resp, replayed, err := s.idempotency.Execute(scope, key, request, func() (proto.Message, error) {
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		msg, _ := resp.(proto.Message)
+		return msg, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if replayed {
+		RequestLogger(ctx).Debug().Str("idempotency_key", key).Msg("replaying recorded response")
+		grpc.SetHeader(ctx, metadata.Pairs(headerIdempotentReplayed, "true"))
+	}
+	return resp, nil
+}
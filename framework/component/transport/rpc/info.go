@@ -0,0 +1,37 @@
+package microrpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/ubiqueworks/go-clean-architecture/framework"
+)
+
+type serviceInfoServer struct {
+	service framework.Service
+}
+
+func (s *serviceInfoServer) GetServiceInfo(context.Context, *ServiceInfoRequest) (*ServiceInfoReply, error) {
+	info := s.service.Info()
+
+	reply := &ServiceInfoReply{
+		Name:      info.Name,
+		Version:   info.Version,
+		Build:     info.Build,
+		GoVersion: info.GoVersion,
+		Vcs: &VCSInfo{
+			Revision: info.VCS.Revision,
+			Time:     info.VCS.Time,
+			Modified: info.VCS.Modified,
+		},
+		Host:       info.Host,
+		Components: info.Components,
+	}
+
+	if !info.StartTime.IsZero() {
+		reply.StartTime, _ = ptypes.TimestampProto(info.StartTime)
+		reply.Uptime = ptypes.DurationProto(time.Since(info.StartTime))
+	}
+	return reply, nil
+}
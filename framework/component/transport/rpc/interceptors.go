@@ -0,0 +1,179 @@
+package microrpc
+
+import (
+	"context"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/auth"
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
+	"github.com/ubiqueworks/go-clean-architecture/framework/util"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const headerRequestId = "x-request-id"
+
+type contextKey string
+
+const (
+	keyCallState     = contextKey("call_state")
+	keyRequestLogger = contextKey("request_logger")
+	keyRequestId     = contextKey("request_id")
+)
+
+// callState collects what inner interceptors learn about a call for the access log.
+type callState struct {
+	principal *auth.Principal
+}
+
+type Option func(*rpcServer)
+
+// WithUnaryInterceptors appends interceptors to the built-in unary chain, after logging, recovery and authentication.
+func WithUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) Option {
+	return func(s *rpcServer) {
+		s.unaryInterceptors = append(s.unaryInterceptors, interceptors...)
+	}
+}
+
+// WithStreamInterceptors appends interceptors to the built-in stream chain, after logging, recovery and authentication.
+func WithStreamInterceptors(interceptors ...grpc.StreamServerInterceptor) Option {
+	return func(s *rpcServer) {
+		s.streamInterceptors = append(s.streamInterceptors, interceptors...)
+	}
+}
+
+func RequestId(ctx context.Context) string {
+	if reqId, ok := ctx.Value(keyRequestId).(string); ok {
+		return reqId
+	}
+	return ""
+}
+
+func RequestLogger(ctx context.Context) *zerolog.Logger {
+	if logger, ok := ctx.Value(keyRequestLogger).(*zerolog.Logger); ok {
+		return logger
+	}
+	logger := zerolog.Nop()
+	return &logger
+}
+
+func chainUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		chained := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], chained
+			chained = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, next)
+			}
+		}
+		return chained(ctx, req)
+	}
+}
+
+func chainStreamInterceptors(interceptors ...grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		chained := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], chained
+			chained = func(srv interface{}, ss grpc.ServerStream) error {
+				return interceptor(srv, ss, info, next)
+			}
+		}
+		return chained(srv, ss)
+	}
+}
+
+// contextStream overrides the context of a server stream.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *rpcServer) requestContext(ctx context.Context, method string) (context.Context, *zerolog.Logger, *callState) {
+	var reqId string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(headerRequestId); len(values) > 0 {
+			reqId = values[0]
+		}
+	}
+	if strings.TrimSpace(reqId) == "" {
+		reqId = util.NewUUID()
+	}
+
+	logger := s.logger.With().
+		Str("request_id", reqId).
+		Str("method", method).
+		Str("client_ip", s.clientIP(ctx)).
+		Logger()
+
+	state := &callState{}
+
+	ctx = context.WithValue(ctx, keyRequestId, reqId)
+	ctx = context.WithValue(ctx, keyRequestLogger, &logger)
+	ctx = context.WithValue(ctx, keyCallState, state)
+	return ctx, &logger, state
+}
+
+func logCall(logger *zerolog.Logger, state *callState, start time.Time, err error) {
+	st, _ := status.FromError(err)
+	event := logger.Info().
+		Str("status", st.Code().String()).
+		Dur("latency", time.Since(start)).
+		Str("err", st.Message())
+	if state.principal != nil {
+		event = event.Str("principal", state.principal.Subject)
+	}
+	event.Msg("done")
+}
+
+func (s *rpcServer) requestUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+
+	ctx, logger, state := s.requestContext(ctx, info.FullMethod)
+	grpc.SetHeader(ctx, metadata.Pairs(headerRequestId, RequestId(ctx)))
+
+	resp, err := handler(ctx, req)
+	logCall(logger, state, start, err)
+
+	return resp, err
+}
+
+func (s *rpcServer) requestStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+
+	ctx, logger, state := s.requestContext(ss.Context(), info.FullMethod)
+	ss.SetHeader(metadata.Pairs(headerRequestId, RequestId(ctx)))
+
+	err := handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	logCall(logger, state, start, err)
+
+	return err
+}
+
+func recoverPanic(ctx context.Context, err *error) {
+	if r := recover(); r != nil {
+		RequestLogger(ctx).Error().
+			Interface("panic", r).
+			Str("stack", string(debug.Stack())).
+			Msg("recovered from panic")
+		*err = errors.Internal("panic", "%v", r)
+	}
+}
+
+func recoveryUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer recoverPanic(ctx, &err)
+	return handler(ctx, req)
+}
+
+func recoveryStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer recoverPanic(ss.Context(), &err)
+	return handler(srv, ss)
+}
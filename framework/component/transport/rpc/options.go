@@ -0,0 +1,140 @@
+package microrpc
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+	"gopkg.in/urfave/cli.v1"
+)
+
+const (
+	envRpcReflection              = "RPC_REFLECTION"
+	envRpcMaxRecvMsgBytes         = "RPC_MAX_RECV_MSG_BYTES"
+	envRpcMaxSendMsgBytes         = "RPC_MAX_SEND_MSG_BYTES"
+	envRpcMaxConcurrentStreams    = "RPC_MAX_CONCURRENT_STREAMS"
+	envRpcKeepaliveTime           = "RPC_KEEPALIVE_TIME"
+	envRpcKeepaliveTimeout        = "RPC_KEEPALIVE_TIMEOUT"
+	envRpcKeepaliveMinTime        = "RPC_KEEPALIVE_MIN_TIME"
+	envRpcKeepalivePermitNoStream = "RPC_KEEPALIVE_PERMIT_WITHOUT_STREAM"
+	envRpcMaxConnectionIdle       = "RPC_MAX_CONNECTION_IDLE"
+	envRpcMaxConnectionAge        = "RPC_MAX_CONNECTION_AGE"
+	envRpcMaxConnectionAgeGrace   = "RPC_MAX_CONNECTION_AGE_GRACE"
+
+	flagRpcReflection              = "rpc-reflection"
+	flagRpcMaxRecvMsgBytes         = "rpc-max-recv-msg-bytes"
+	flagRpcMaxSendMsgBytes         = "rpc-max-send-msg-bytes"
+	flagRpcMaxConcurrentStreams    = "rpc-max-concurrent-streams"
+	flagRpcKeepaliveTime           = "rpc-keepalive-time"
+	flagRpcKeepaliveTimeout        = "rpc-keepalive-timeout"
+	flagRpcKeepaliveMinTime        = "rpc-keepalive-min-time"
+	flagRpcKeepalivePermitNoStream = "rpc-keepalive-permit-without-stream"
+	flagRpcMaxConnectionIdle       = "rpc-max-connection-idle"
+	flagRpcMaxConnectionAge        = "rpc-max-connection-age"
+	flagRpcMaxConnectionAgeGrace   = "rpc-max-connection-age-grace"
+
+	defaultRpcMaxMsgBytes          = 4 << 20
+	defaultRpcKeepaliveEnforcement = 5 * time.Minute
+)
+
+var optionFlags = []cli.Flag{
+	cli.BoolFlag{
+		Name:   flagRpcReflection,
+		EnvVar: envRpcReflection,
+		Usage:  "register the grpc server reflection service",
+	},
+	cli.IntFlag{
+		Name:   flagRpcMaxRecvMsgBytes,
+		EnvVar: envRpcMaxRecvMsgBytes,
+		Value:  defaultRpcMaxMsgBytes,
+		Usage:  "maximum size of received messages in bytes",
+	},
+	cli.IntFlag{
+		Name:   flagRpcMaxSendMsgBytes,
+		EnvVar: envRpcMaxSendMsgBytes,
+		Value:  defaultRpcMaxMsgBytes,
+		Usage:  "maximum size of sent messages in bytes",
+	},
+	cli.IntFlag{
+		Name:   flagRpcMaxConcurrentStreams,
+		EnvVar: envRpcMaxConcurrentStreams,
+		Usage:  "maximum number of concurrent streams per connection (0 for unlimited)",
+	},
+	cli.DurationFlag{
+		Name:   flagRpcKeepaliveTime,
+		EnvVar: envRpcKeepaliveTime,
+		Value:  2 * time.Hour,
+		Usage:  "idle time after which the server pings the client",
+	},
+	cli.DurationFlag{
+		Name:   flagRpcKeepaliveTimeout,
+		EnvVar: envRpcKeepaliveTimeout,
+		Value:  20 * time.Second,
+		Usage:  "time to wait for a ping ack before closing the connection",
+	},
+	cli.DurationFlag{
+		Name:   flagRpcKeepaliveMinTime,
+		EnvVar: envRpcKeepaliveMinTime,
+		Value:  defaultRpcKeepaliveEnforcement,
+		Usage:  "minimum interval clients are allowed to send keepalive pings",
+	},
+	cli.BoolFlag{
+		Name:   flagRpcKeepalivePermitNoStream,
+		EnvVar: envRpcKeepalivePermitNoStream,
+		Usage:  "allow client keepalive pings when there are no active streams",
+	},
+	cli.DurationFlag{
+		Name:   flagRpcMaxConnectionIdle,
+		EnvVar: envRpcMaxConnectionIdle,
+		Usage:  "idle time after which a connection is closed (0 for infinite)",
+	},
+	cli.DurationFlag{
+		Name:   flagRpcMaxConnectionAge,
+		EnvVar: envRpcMaxConnectionAge,
+		Usage:  "maximum age of a connection before it's gracefully closed (0 for infinite)",
+	},
+	cli.DurationFlag{
+		Name:   flagRpcMaxConnectionAgeGrace,
+		EnvVar: envRpcMaxConnectionAgeGrace,
+		Usage:  "time allowed for pending calls to complete once a connection reached its maximum age",
+	},
+}
+
+func parseServerOptions(cliCtx *cli.Context) ([]grpc.ServerOption, error) {
+	maxRecvMsgBytes := cliCtx.Int(flagRpcMaxRecvMsgBytes)
+	if maxRecvMsgBytes <= 0 {
+		return nil, fmt.Errorf("invalid max receive message bytes: %d", maxRecvMsgBytes)
+	}
+
+	maxSendMsgBytes := cliCtx.Int(flagRpcMaxSendMsgBytes)
+	if maxSendMsgBytes <= 0 {
+		return nil, fmt.Errorf("invalid max send message bytes: %d", maxSendMsgBytes)
+	}
+
+	maxConcurrentStreams := cliCtx.Int(flagRpcMaxConcurrentStreams)
+	if maxConcurrentStreams < 0 {
+		return nil, fmt.Errorf("invalid max concurrent streams: %d", maxConcurrentStreams)
+	}
+
+	options := []grpc.ServerOption{
+		grpc.MaxRecvMsgSize(maxRecvMsgBytes),
+		grpc.MaxSendMsgSize(maxSendMsgBytes),
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			MaxConnectionIdle:     cliCtx.Duration(flagRpcMaxConnectionIdle),
+			MaxConnectionAge:      cliCtx.Duration(flagRpcMaxConnectionAge),
+			MaxConnectionAgeGrace: cliCtx.Duration(flagRpcMaxConnectionAgeGrace),
+			Time:                  cliCtx.Duration(flagRpcKeepaliveTime),
+			Timeout:               cliCtx.Duration(flagRpcKeepaliveTimeout),
+		}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             cliCtx.Duration(flagRpcKeepaliveMinTime),
+			PermitWithoutStream: cliCtx.Bool(flagRpcKeepalivePermitNoStream),
+		}),
+	}
+
+	if maxConcurrentStreams > 0 {
+		options = append(options, grpc.MaxConcurrentStreams(uint32(maxConcurrentStreams)))
+	}
+	return options, nil
+}
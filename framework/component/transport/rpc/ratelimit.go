@@ -0,0 +1,66 @@
+package microrpc
+
+import (
+	"context"
+	"strings"
+
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/ratelimit"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+const headerForwardedFor = "x-forwarded-for"
+
+func (s *rpcServer) rateLimit(ctx context.Context, fullMethod string) error {
+	if s.limiter == nil {
+		return nil
+	}
+
+	client := &ratelimit.Client{
+		IP: s.clientIP(ctx),
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(headerApiKey); len(values) > 0 {
+			client.APIKey = values[0]
+		}
+	}
+	if principal := RequestPrincipal(ctx); principal != nil {
+		client.Principal = principal.Subject
+	}
+	return s.limiter.LimitRPC(fullMethod, client)
+}
+
+// clientIP returns the address of the peer, or the client address forwarded by the local gateway or a trusted proxy.
+func (s *rpcServer) clientIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	var forwardedFor []string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		forwardedFor = md.Get(headerForwardedFor)
+	}
+
+	// The local gateway forwards the client address it resolved from its own trusted proxies
+	if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok && s.tlsConfig.isLocal(tlsInfo.State.PeerCertificates) && len(forwardedFor) > 0 {
+		return strings.TrimSpace(forwardedFor[len(forwardedFor)-1])
+	}
+	return s.trustedProxies.ClientIP(p.Addr.String(), forwardedFor)
+}
+
+func (s *rpcServer) rateLimitUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := s.rateLimit(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *rpcServer) rateLimitStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.rateLimit(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
@@ -7,40 +7,122 @@ import (
 
 	"github.com/rs/zerolog"
 	"github.com/ubiqueworks/go-clean-architecture/framework"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/auth"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/idempotency"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/ratelimit"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/validation"
 	"github.com/ubiqueworks/go-clean-architecture/framework/util"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/reflection"
 	"gopkg.in/urfave/cli.v1"
 )
 
 const (
 	Component = "rpc-server"
 
-	envRpcPort  = "RPC_PORT"
-	flagRpcPort = "rpc-port"
+	envRpcPort               = "RPC_PORT"
+	envRpcTlsCert            = "RPC_TLS_CERT"
+	envRpcTlsKey             = "RPC_TLS_KEY"
+	envRpcTlsCA              = "RPC_TLS_CA"
+	envRpcTlsClientAuth      = "RPC_TLS_CLIENT_AUTH"
+	envRpcTlsAllowedSubjects = "RPC_TLS_ALLOWED_SUBJECTS"
+	envRpcTrustedProxies     = "RPC_TRUSTED_PROXIES"
+
+	flagRpcPort               = "rpc-port"
+	flagRpcTlsCert            = "rpc-tls-cert"
+	flagRpcTlsKey             = "rpc-tls-key"
+	flagRpcTlsCA              = "rpc-tls-ca"
+	flagRpcTlsClientAuth      = "rpc-tls-client-auth"
+	flagRpcTlsAllowedSubjects = "rpc-tls-allowed-subjects"
+	flagRpcTrustedProxies     = "rpc-trusted-proxies"
 )
 
-var cliFlags = []cli.Flag{
+var cliFlags = append([]cli.Flag{
 	cli.IntFlag{
 		Name:   flagRpcPort,
 		EnvVar: envRpcPort,
 		Value:  framework.DefaultRpcPort,
 		Usage:  "rpc server port",
 	},
-}
+	cli.StringFlag{
+		Name:   flagRpcTlsCert,
+		EnvVar: envRpcTlsCert,
+		Usage:  "tls certificate file, enables tls when set",
+	},
+	cli.StringFlag{
+		Name:   flagRpcTlsKey,
+		EnvVar: envRpcTlsKey,
+		Usage:  "tls private key file",
+	},
+	cli.StringFlag{
+		Name:   flagRpcTlsCA,
+		EnvVar: envRpcTlsCA,
+		Usage:  "ca bundle used to verify peer certificates, enables mtls when set",
+	},
+	cli.StringFlag{
+		Name:   flagRpcTlsClientAuth,
+		EnvVar: envRpcTlsClientAuth,
+		Value:  "require",
+		Usage:  "client certificate policy when mtls is enabled (require, request)",
+	},
+	cli.StringSliceFlag{
+		Name:   flagRpcTlsAllowedSubjects,
+		EnvVar: envRpcTlsAllowedSubjects,
+		Usage:  "peer certificate subject names allowed to connect",
+	},
+	cli.StringSliceFlag{
+		Name:   flagRpcTrustedProxies,
+		EnvVar: envRpcTrustedProxies,
+		Usage:  "addresses or cidr networks of the proxies trusted to set x-forwarded-for, the client being the peer address otherwise",
+	},
+}, optionFlags...)
 
 type InitServerFunc func(framework.Service, framework.Component, *grpc.Server) error
 
-func Create(initFunc InitServerFunc) framework.Component {
-	return &rpcServer{
+func Create(initFunc InitServerFunc, options ...Option) framework.Component {
+	server := &rpcServer{
 		initFunc: initFunc,
 	}
+	for _, option := range options {
+		option(server)
+	}
+	return server
+}
+
+func Get(service framework.Service) (Server, error) {
+	component, err := service.Component(Component)
+	if err != nil {
+		return nil, err
+	}
+	return component.(Server), nil
+}
+
+type Server interface {
+	GrpcServer() *grpc.Server
+	DialLocal(...grpc.DialOption) (*grpc.ClientConn, error)
 }
 
 type rpcServer struct {
-	initFunc   InitServerFunc
-	grpcServer *grpc.Server
-	logger     *zerolog.Logger
-	port       int
+	initFunc           InitServerFunc
+	grpcServer         *grpc.Server
+	logger             *zerolog.Logger
+	port               int
+	tlsConfig          *TLSConfig
+	trustedProxies     util.TrustedProxies
+	authenticator      auth.Authenticator
+	authorizer         auth.Authorizer
+	limiter            ratelimit.Limiter
+	idempotency        idempotency.Guard
+	validator          validation.Validator
+	serverOptions      []grpc.ServerOption
+	reflection         bool
+	unaryInterceptors  []grpc.UnaryServerInterceptor
+	streamInterceptors []grpc.StreamServerInterceptor
+}
+
+func (s *rpcServer) GrpcServer() *grpc.Server {
+	return s.grpcServer
 }
 
 func (s *rpcServer) ID() string {
@@ -69,6 +151,47 @@ func (s *rpcServer) Configure(service framework.Service, cliCtx *cli.Context) er
 	}
 	s.port = rpcPort
 
+	s.tlsConfig = &TLSConfig{
+		CertFile:        cliCtx.String(flagRpcTlsCert),
+		KeyFile:         cliCtx.String(flagRpcTlsKey),
+		CAFile:          cliCtx.String(flagRpcTlsCA),
+		ClientAuth:      cliCtx.String(flagRpcTlsClientAuth),
+		AllowedSubjects: cliCtx.StringSlice(flagRpcTlsAllowedSubjects),
+	}
+	if err := s.tlsConfig.load(); err != nil {
+		return err
+	}
+
+	trustedProxies, err := util.ParseTrustedProxies(cliCtx.StringSlice(flagRpcTrustedProxies))
+	if err != nil {
+		return err
+	}
+	s.trustedProxies = trustedProxies
+
+	serverOptions, err := parseServerOptions(cliCtx)
+	if err != nil {
+		return err
+	}
+	s.serverOptions = serverOptions
+	s.reflection = cliCtx.Bool(flagRpcReflection)
+
+	// Authentication and authorization are enabled when the service registers the auth component
+	if authenticator, err := auth.Get(service); err == nil {
+		s.authenticator = authenticator
+	}
+	if authorizer, err := auth.GetAuthorizer(service); err == nil {
+		s.authorizer = authorizer
+	}
+	if limiter, err := ratelimit.Get(service); err == nil {
+		s.limiter = limiter
+	}
+	if guard, err := idempotency.Get(service); err == nil {
+		s.idempotency = guard
+	}
+	if validator, err := validation.Get(service); err == nil {
+		s.validator = validator
+	}
+
 	if err := s.configureServer(service); err != nil {
 		return err
 	}
@@ -111,11 +234,52 @@ func (s *rpcServer) configureServer(service framework.Service) error {
 		return fmt.Errorf("missing init function for RPC server")
 	}
 
-	grpcServer := grpc.NewServer()
+	unaryInterceptors := append([]grpc.UnaryServerInterceptor{
+		s.requestUnaryInterceptor,
+		errorUnaryInterceptor,
+		recoveryUnaryInterceptor,
+		s.authUnaryInterceptor,
+		s.rateLimitUnaryInterceptor,
+		s.validationUnaryInterceptor,
+		s.idempotencyUnaryInterceptor,
+	}, s.unaryInterceptors...)
+
+	streamInterceptors := append([]grpc.StreamServerInterceptor{
+		s.requestStreamInterceptor,
+		errorStreamInterceptor,
+		recoveryStreamInterceptor,
+		s.authStreamInterceptor,
+		s.rateLimitStreamInterceptor,
+		s.validationStreamInterceptor,
+	}, s.streamInterceptors...)
+
+	options := append([]grpc.ServerOption{
+		grpc.UnaryInterceptor(chainUnaryInterceptors(unaryInterceptors...)),
+		grpc.StreamInterceptor(chainStreamInterceptors(streamInterceptors...)),
+	}, s.serverOptions...)
+
+	if s.tlsConfig.Enabled() {
+		tlsConfig, err := s.tlsConfig.ServerConfig()
+		if err != nil {
+			return err
+		}
+		options = append(options, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	grpcServer := grpc.NewServer(options...)
+	RegisterServiceInfoServer(grpcServer, &serviceInfoServer{
+		service: service,
+	})
+
 	s.logger.Info().Msg("configuring rpc server...")
 	if err := s.initFunc(service, s, grpcServer); err != nil {
 		return err
 	}
+
+	if s.reflection {
+		s.logger.Info().Msg("registering server reflection")
+		reflection.Register(grpcServer)
+	}
 	s.grpcServer = grpcServer
 
 	return nil
@@ -0,0 +1,167 @@
+package microrpc
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+
+	"github.com/ubiqueworks/go-clean-architecture/framework"
+	"github.com/ubiqueworks/go-clean-architecture/framework/util"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// TLSConfig holds the transport security settings shared by the RPC server and its clients.
+type TLSConfig struct {
+	CertFile        string
+	KeyFile         string
+	CAFile          string
+	ClientAuth      string
+	AllowedSubjects []string
+
+	reloader *util.CertReloader
+	pool     *x509.CertPool
+}
+
+func (c *TLSConfig) Enabled() bool {
+	return c != nil && c.reloader != nil
+}
+
+func (c *TLSConfig) load() error {
+	if c.CertFile == "" && c.KeyFile == "" {
+		if c.CAFile != "" || len(c.AllowedSubjects) > 0 {
+			return fmt.Errorf("client certificate verification requires tls to be enabled")
+		}
+		return nil
+	}
+	if c.CertFile == "" || c.KeyFile == "" {
+		return fmt.Errorf("both tls certificate and key are required")
+	}
+	if len(c.AllowedSubjects) > 0 && c.CAFile == "" {
+		return fmt.Errorf("allowed certificate subjects require a client ca")
+	}
+
+	reloader, err := util.NewCertReloader(c.CertFile, c.KeyFile)
+	if err != nil {
+		return err
+	}
+	c.reloader = reloader
+
+	if c.CAFile != "" {
+		pool, err := util.LoadCertPool(c.CAFile)
+		if err != nil {
+			return err
+		}
+		c.pool = pool
+	}
+	return nil
+}
+
+func (c *TLSConfig) ServerConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: c.reloader.GetCertificate,
+	}
+
+	if c.pool != nil {
+		authType, err := util.ParseClientAuth(c.ClientAuth)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = c.pool
+		tlsConfig.ClientAuth = authType
+		tlsConfig.VerifyPeerCertificate = c.verifySubject
+	}
+	return tlsConfig, nil
+}
+
+func (c *TLSConfig) ClientConfig(serverName string) *tls.Config {
+	return &tls.Config{
+		MinVersion:           tls.VersionTLS12,
+		ServerName:           serverName,
+		RootCAs:              c.pool,
+		GetClientCertificate: c.reloader.GetClientCertificate,
+	}
+}
+
+func (c *TLSConfig) verifySubject(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+	if len(c.AllowedSubjects) == 0 {
+		return nil
+	}
+	// Peers that did not present a certificate have no subject to allow
+	if len(verifiedChains) == 0 {
+		return fmt.Errorf("client certificate required")
+	}
+
+	leaf := verifiedChains[0][0]
+	names := append([]string{leaf.Subject.CommonName}, leaf.DNSNames...)
+	for _, allowed := range c.AllowedSubjects {
+		for _, name := range names {
+			if name == allowed {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("certificate subject not allowed: %s", leaf.Subject.CommonName)
+}
+
+// isLocal reports whether the peer presented the certificate of this server.
+func (c *TLSConfig) isLocal(peerCerts []*x509.Certificate) bool {
+	if !c.Enabled() || len(peerCerts) == 0 {
+		return false
+	}
+	cert, _ := c.reloader.GetCertificate(nil)
+	return bytes.Equal(peerCerts[0].Raw, cert.Certificate[0])
+}
+
+func (c *TLSConfig) localClientConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// The local server certificate is pinned instead of being verified against a ca
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			cert, _ := c.reloader.GetCertificate(nil)
+			if len(rawCerts) == 0 || !bytes.Equal(rawCerts[0], cert.Certificate[0]) {
+				return fmt.Errorf("unexpected local server certificate")
+			}
+			return nil
+		},
+		GetClientCertificate: c.reloader.GetClientCertificate,
+	}
+}
+
+// DialLocal connects to the server through the loopback interface.
+func (s *rpcServer) DialLocal(opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	target := fmt.Sprintf("localhost:%d", s.port)
+	if !s.tlsConfig.Enabled() {
+		return grpc.Dial(target, append([]grpc.DialOption{grpc.WithInsecure()}, opts...)...)
+	}
+
+	creds := credentials.NewTLS(s.tlsConfig.localClientConfig())
+	return grpc.Dial(target, append([]grpc.DialOption{grpc.WithTransportCredentials(creds)}, opts...)...)
+}
+
+// Dial connects to an RPC server using the transport security settings of the service RPC component.
+func Dial(service framework.Service, target string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	component, err := service.Component(Component)
+	if err != nil {
+		return nil, err
+	}
+	return DialWithTLS(component.(*rpcServer).tlsConfig, target, opts...)
+}
+
+func DialWithTLS(tlsConfig *TLSConfig, target string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	if !tlsConfig.Enabled() {
+		return grpc.Dial(target, append([]grpc.DialOption{grpc.WithInsecure()}, opts...)...)
+	}
+
+	serverName, _, err := net.SplitHostPort(target)
+	if err != nil {
+		serverName = target
+	}
+	creds := credentials.NewTLS(tlsConfig.ClientConfig(serverName))
+
+	return grpc.Dial(target, append([]grpc.DialOption{grpc.WithTransportCredentials(creds)}, opts...)...)
+}
@@ -0,0 +1,46 @@
+package microrpc
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+)
+
+func (s *rpcServer) validate(req interface{}) error {
+	if s.validator == nil {
+		return nil
+	}
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return nil
+	}
+	return s.validator.Validate(msg)
+}
+
+func (s *rpcServer) validationUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := s.validate(req); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *rpcServer) validationStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if s.validator == nil {
+		return handler(srv, ss)
+	}
+	return handler(srv, &validatingStream{ServerStream: ss, server: s})
+}
+
+// validatingStream validates the messages received from the client.
+type validatingStream struct {
+	grpc.ServerStream
+	server *rpcServer
+}
+
+func (s *validatingStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return s.server.validate(m)
+}
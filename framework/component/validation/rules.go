@@ -0,0 +1,201 @@
+package validation
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
+)
+
+// Rule constrains the value of a message field. Lengths count characters for strings and items for repeated fields.
+type Rule struct {
+	Required bool     `yaml:"required,omitempty"`
+	MinLen   int      `yaml:"min_len,omitempty"`
+	MaxLen   int      `yaml:"max_len,omitempty"`
+	Pattern  string   `yaml:"pattern,omitempty"`
+	Min      *float64 `yaml:"min,omitempty"`
+	Max      *float64 `yaml:"max,omitempty"`
+	In       []string `yaml:"in,omitempty"`
+}
+
+// fieldRule is a rule bound to the struct fields on the path to the value it constrains.
+type fieldRule struct {
+	path    string
+	indexes []int
+	rule    Rule
+	pattern *regexp.Regexp
+}
+
+func newFieldRule(t reflect.Type, path string, rule Rule) (*fieldRule, error) {
+	f := &fieldRule{
+		path: path,
+		rule: rule,
+	}
+
+	for _, name := range strings.Split(path, ".") {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			return nil, fmt.Errorf("invalid field path %s", path)
+		}
+
+		index, field, found := -1, reflect.StructField{}, false
+		for i := 0; i < t.NumField() && !found; i++ {
+			for _, part := range strings.Split(t.Field(i).Tag.Get("protobuf"), ",") {
+				if part == "name="+name || part == "json="+name {
+					index, field, found = i, t.Field(i), true
+					break
+				}
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown field %s", path)
+		}
+
+		f.indexes = append(f.indexes, index)
+		t = field.Type
+	}
+
+	if rule.Pattern != "" {
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern for %s: %v", path, err)
+		}
+		f.pattern = pattern
+	}
+	return f, nil
+}
+
+// check returns the violations of the rule by the message, nested rules are skipped when a parent message is missing.
+func (f *fieldRule) check(msg reflect.Value) []errors.FieldViolation {
+	value := msg
+	for _, index := range f.indexes {
+		for value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				return nil
+			}
+			value = value.Elem()
+		}
+		value = value.Field(index)
+	}
+
+	violation := func(tag string, param interface{}) []errors.FieldViolation {
+		return []errors.FieldViolation{{Field: f.path, Description: Describe(tag, fmt.Sprint(param))}}
+	}
+
+	rule := f.rule
+	if isZero(value) {
+		if rule.Required {
+			return violation("required", "")
+		}
+		return nil
+	}
+
+	// Optional scalars of proto2 messages are pointers
+	if value.Kind() == reflect.Ptr {
+		value = value.Elem()
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		str := value.String()
+		length := utf8.RuneCountInString(str)
+		switch {
+		case rule.MinLen > 0 && length < rule.MinLen:
+			return violation("min", rule.MinLen)
+		case rule.MaxLen > 0 && length > rule.MaxLen:
+			return violation("max", rule.MaxLen)
+		case f.pattern != nil && !f.pattern.MatchString(str):
+			return violation("pattern", rule.Pattern)
+		case len(rule.In) > 0 && !contains(rule.In, str):
+			return violation("in", strings.Join(rule.In, " "))
+		}
+
+	case reflect.Slice, reflect.Map:
+		length := value.Len()
+		switch {
+		case rule.MinLen > 0 && length < rule.MinLen:
+			return violation("min_items", rule.MinLen)
+		case rule.MaxLen > 0 && length > rule.MaxLen:
+			return violation("max_items", rule.MaxLen)
+		}
+
+	case reflect.Int32, reflect.Int64, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		n := number(value)
+		switch {
+		case rule.Min != nil && n < *rule.Min:
+			return violation("gte", *rule.Min)
+		case rule.Max != nil && n > *rule.Max:
+			return violation("lte", *rule.Max)
+		}
+	}
+	return nil
+}
+
+func isZero(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return value.IsNil()
+	case reflect.Slice, reflect.Map, reflect.String:
+		return value.Len() == 0
+	}
+	return value.Interface() == reflect.Zero(value.Type()).Interface()
+}
+
+func number(value reflect.Value) float64 {
+	switch value.Kind() {
+	case reflect.Int32, reflect.Int64:
+		return float64(value.Int())
+	case reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint())
+	}
+	return value.Float()
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Describe returns the description of a failed validation rule, shared by the struct tags of HTTP bodies and the message rules.
+func Describe(tag, param string) string {
+	switch tag {
+	case "required":
+		return "is required"
+	case "min":
+		return fmt.Sprintf("must be at least %s characters long", param)
+	case "max":
+		return fmt.Sprintf("must be at most %s characters long", param)
+	case "gte":
+		return fmt.Sprintf("must be greater than or equal to %s", param)
+	case "lte":
+		return fmt.Sprintf("must be less than or equal to %s", param)
+	case "len":
+		return fmt.Sprintf("must be %s characters long", param)
+	case "gt":
+		return fmt.Sprintf("must be greater than %s", param)
+	case "lt":
+		return fmt.Sprintf("must be less than %s", param)
+	case "min_items":
+		return fmt.Sprintf("must have at least %s items", param)
+	case "max_items":
+		return fmt.Sprintf("must have at most %s items", param)
+	case "pattern":
+		return fmt.Sprintf("must match %s", param)
+	case "in":
+		return fmt.Sprintf("must be one of %s", param)
+	case "email":
+		return "must be a valid email address"
+	case "url":
+		return "must be a valid url"
+	}
+	return fmt.Sprintf("failed on the '%s' rule", tag)
+}
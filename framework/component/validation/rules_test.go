@@ -0,0 +1,138 @@
+package validation
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/golang/protobuf/ptypes/duration"
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
+)
+
+func float(f float64) *float64 {
+	return &f
+}
+
+func TestFieldRuleCheck(t *testing.T) {
+	file := func(update func(*descriptor.FileDescriptorProto)) *descriptor.FileDescriptorProto {
+		msg := &descriptor.FileDescriptorProto{
+			Name:       proto.String("test.proto"),
+			Dependency: []string{"a.proto"},
+			Syntax:     proto.String("proto3"),
+			Options:    &descriptor.FileOptions{JavaPackage: proto.String("com.example")},
+		}
+		update(msg)
+		return msg
+	}
+	valid := func(*descriptor.FileDescriptorProto) {}
+
+	tests := []struct {
+		name     string
+		path     string
+		rule     Rule
+		msg      proto.Message
+		expected string
+	}{
+		{"required", "name", Rule{Required: true}, file(func(m *descriptor.FileDescriptorProto) { m.Name = nil }), "is required"},
+		{"optional", "name", Rule{MinLen: 20}, file(func(m *descriptor.FileDescriptorProto) { m.Name = nil }), ""},
+		{"min length", "name", Rule{MinLen: 20}, file(valid), "must be at least 20 characters long"},
+		{"max length", "name", Rule{MaxLen: 5}, file(valid), "must be at most 5 characters long"},
+		{"length in characters", "name", Rule{MaxLen: 6}, file(func(m *descriptor.FileDescriptorProto) { m.Name = proto.String("ééé.go") }), ""},
+		{"pattern", "name", Rule{Pattern: `\.proto$`}, file(func(m *descriptor.FileDescriptorProto) { m.Name = proto.String("test.go") }), `must match \.proto$`},
+		{"in", "syntax", Rule{In: []string{"proto2", "proto3"}}, file(func(m *descriptor.FileDescriptorProto) { m.Syntax = proto.String("proto4") }), "must be one of proto2 proto3"},
+		{"max items", "dependency", Rule{MaxLen: 1}, file(func(m *descriptor.FileDescriptorProto) { m.Dependency = append(m.Dependency, "b.proto") }), "must have at most 1 items"},
+		{"min items", "dependency", Rule{MinLen: 2}, file(valid), "must have at least 2 items"},
+		{"nested by json name", "options.javaPackage", Rule{Required: true}, file(func(m *descriptor.FileDescriptorProto) { m.Options.JavaPackage = nil }), "is required"},
+		{"missing parent", "options.java_package", Rule{Required: true}, file(func(m *descriptor.FileDescriptorProto) { m.Options = nil }), ""},
+		{"zero number", "seconds", Rule{Min: float(1)}, &duration.Duration{}, ""},
+		{"below min", "nanos", Rule{Min: float(10)}, &duration.Duration{Nanos: 5}, "must be greater than or equal to 10"},
+		{"above max", "seconds", Rule{Max: float(60)}, &duration.Duration{Seconds: 61}, "must be less than or equal to 60"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := newFieldRule(reflect.TypeOf(test.msg), test.path, test.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			violations := f.check(reflect.ValueOf(test.msg))
+			switch {
+			case test.expected == "" && len(violations) > 0:
+				t.Errorf("unexpected violations %+v", violations)
+			case test.expected != "" && (len(violations) != 1 || violations[0].Description != test.expected || violations[0].Field != test.path):
+				t.Errorf("expected %s, got %+v", test.expected, violations)
+			}
+		})
+	}
+}
+
+func TestNewFieldRuleInvalid(t *testing.T) {
+	tests := []struct {
+		path string
+		rule Rule
+	}{
+		{"unknown", Rule{}},
+		{"name.length", Rule{}},
+		{"options.unknown", Rule{}},
+		{"name", Rule{Pattern: "("}},
+	}
+
+	for _, test := range tests {
+		if _, err := newFieldRule(reflect.TypeOf(&descriptor.FileDescriptorProto{}), test.path, test.rule); err == nil {
+			t.Errorf("%s: expected an error", test.path)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	messages, err := compile(Rules{
+		"google.protobuf.Duration": {
+			"seconds": {Required: true, Max: float(60)},
+			"nanos":   {Min: float(0)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &validator{messages: messages}
+
+	if err := v.Validate(&duration.Duration{Seconds: 30}); err != nil {
+		t.Errorf("expected a valid message, got %v", err)
+	}
+	if err := v.Validate(&descriptor.FileDescriptorProto{}); err != nil {
+		t.Errorf("expected messages without rules to be valid, got %v", err)
+	}
+
+	e := errors.From(v.Validate(&duration.Duration{Nanos: -1}))
+	if e == nil || e.Kind != errors.KindInvalidArgument || e.Code != "invalid_request" {
+		t.Fatalf("expected an invalid request, got %v", e)
+	}
+	if len(e.Fields) != 2 || e.Fields[0].Field != "nanos" || e.Fields[1].Field != "seconds" {
+		t.Errorf("expected the violations of every field, got %+v", e.Fields)
+	}
+}
+
+func TestCompileInvalid(t *testing.T) {
+	for name, rules := range map[string]Rules{
+		"unknown message": {"test.Unknown": {"name": {Required: true}}},
+		"unknown field":   {"google.protobuf.Duration": {"minutes": {Required: true}}},
+	} {
+		if _, err := compile(rules); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	rules := Rules{"google.protobuf.Duration": {"seconds": {Required: true}, "nanos": {Required: true}}}
+	merge(rules, Rules{"google.protobuf.Duration": {"seconds": {MinLen: 1}}, "google.protobuf.Timestamp": {"seconds": {Required: true}}})
+
+	if rules["google.protobuf.Duration"]["seconds"].Required || !rules["google.protobuf.Duration"]["nanos"].Required {
+		t.Errorf("expected the field rule to be replaced, got %+v", rules["google.protobuf.Duration"])
+	}
+	if _, exists := rules["google.protobuf.Timestamp"]; !exists {
+		t.Error("expected the message rules to be added")
+	}
+}
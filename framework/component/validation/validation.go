@@ -0,0 +1,194 @@
+package validation
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/rs/zerolog"
+	"github.com/ubiqueworks/go-clean-architecture/framework"
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
+	"gopkg.in/urfave/cli.v1"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	Component = "validator"
+
+	envValidationRulesFile  = "VALIDATION_RULES_FILE"
+	flagValidationRulesFile = "validation-rules-file"
+)
+
+var cliFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:   flagValidationRulesFile,
+		EnvVar: envValidationRulesFile,
+		Usage:  "yaml file of message validation rules, merged over the ones declared by the service",
+	},
+}
+
+// Rules maps proto message names to the rules of their fields, keyed by dotted field paths
+// made of proto or JSON field names, e.g. "message.name".
+type Rules map[string]map[string]Rule
+
+// Create returns the validator component, enforcing the rules declared by the service on incoming messages.
+func Create(rules Rules) framework.Component {
+	return &validator{
+		rules: rules,
+	}
+}
+
+func Get(service framework.Service) (Validator, error) {
+	component, err := service.Component(Component)
+	if err != nil {
+		return nil, err
+	}
+	return component.(Validator), nil
+}
+
+type Validator interface {
+	// Validate checks the message against the rules of its type, returning an invalid argument error listing the violations.
+	Validate(msg proto.Message) error
+}
+
+type validator struct {
+	logger    *zerolog.Logger
+	rules     Rules
+	rulesFile string
+	messages  map[string][]*fieldRule
+}
+
+func (v *validator) Validate(msg proto.Message) error {
+	if msg == nil {
+		return nil
+	}
+
+	fields, exists := v.messages[proto.MessageName(msg)]
+	if !exists {
+		return nil
+	}
+
+	var violations []errors.FieldViolation
+	for _, f := range fields {
+		violations = append(violations, f.check(reflect.ValueOf(msg))...)
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+
+	e := errors.InvalidArgument("invalid_request", "invalid request")
+	e.Fields = violations
+	return e
+}
+
+func (v *validator) ID() string {
+	return Component
+}
+
+func (v *validator) DependsOn() []string {
+	return nil
+}
+
+func (v *validator) Flags() []cli.Flag {
+	return cliFlags
+}
+
+func (v *validator) Logger() *zerolog.Logger {
+	return v.logger
+}
+
+func (v *validator) Configure(service framework.Service, cliCtx *cli.Context) error {
+	logger := service.Logger().With().Str("component", Component).Logger()
+	v.logger = &logger
+
+	rules := make(Rules)
+	merge(rules, v.rules)
+
+	if rulesFile := cliCtx.String(flagValidationRulesFile); rulesFile != "" {
+		fileRules, err := loadRules(rulesFile)
+		if err != nil {
+			return err
+		}
+		merge(rules, fileRules)
+		v.rulesFile = rulesFile
+	}
+
+	messages, err := compile(rules)
+	if err != nil {
+		return err
+	}
+	v.messages = messages
+	return nil
+}
+
+func (v *validator) Initialize(wg *sync.WaitGroup, startedCh chan<- struct{}, shutdownCh <-chan struct{}, errCh chan<- error) {
+	defer wg.Done()
+
+	names := make([]string, 0, len(v.messages))
+	for name := range v.messages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	v.logger.Info().
+		Strs("messages", names).
+		Str("rules_file", v.rulesFile).
+		Msg("message validation enabled")
+	close(startedCh)
+
+	<-shutdownCh
+}
+
+func loadRules(file string) (Rules, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules Rules
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("invalid validation rules file %s: %v", file, err)
+	}
+	return rules, nil
+}
+
+// merge copies the field rules of src over the ones of dst.
+func merge(dst, src Rules) {
+	for message, fields := range src {
+		if dst[message] == nil {
+			dst[message] = make(map[string]Rule)
+		}
+		for path, rule := range fields {
+			dst[message][path] = rule
+		}
+	}
+}
+
+// compile checks the rules against the registered message types so that mistakes fail at startup.
+func compile(rules Rules) (map[string][]*fieldRule, error) {
+	messages := make(map[string][]*fieldRule)
+	for message, fields := range rules {
+		t := proto.MessageType(message)
+		if t == nil {
+			return nil, fmt.Errorf("invalid validation rules: unknown message %s", message)
+		}
+
+		paths := make([]string, 0, len(fields))
+		for path := range fields {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		for _, path := range paths {
+			f, err := newFieldRule(t, path, fields[path])
+			if err != nil {
+				return nil, fmt.Errorf("invalid validation rules for %s: %v", message, err)
+			}
+			messages[message] = append(messages[message], f)
+		}
+	}
+	return messages, nil
+}
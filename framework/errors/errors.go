@@ -0,0 +1,161 @@
+package errors
+
+import (
+	stderrors "errors"
+	"fmt"
+	"time"
+)
+
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindInvalidArgument
+	KindNotFound
+	KindConflict
+	KindUnavailable
+	KindUnauthenticated
+	KindPermissionDenied
+	KindResourceExhausted
+)
+
+var kindNames = map[Kind]string{
+	KindInternal:          "internal",
+	KindInvalidArgument:   "invalid_argument",
+	KindNotFound:          "not_found",
+	KindConflict:          "conflict",
+	KindUnavailable:       "unavailable",
+	KindUnauthenticated:   "unauthenticated",
+	KindPermissionDenied:  "permission_denied",
+	KindResourceExhausted: "resource_exhausted",
+}
+
+func (k Kind) String() string {
+	if name, exists := kindNames[k]; exists {
+		return name
+	}
+	return kindNames[KindInternal]
+}
+
+// ParseKind returns the kind with the given name, unknown names being internal.
+func ParseKind(name string) Kind {
+	for kind, kindName := range kindNames {
+		if kindName == name {
+			return kind
+		}
+	}
+	return KindInternal
+}
+
+type FieldViolation struct {
+	Field       string
+	Description string
+}
+
+type Error struct {
+	Kind       Kind
+	Code       string
+	Message    string
+	Details    map[string]string
+	Fields     []FieldViolation
+	RetryAfter time.Duration
+	Cause      error
+}
+
+func (e *Error) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Cause)
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+func (e *Error) WithDetail(key, value string) *Error {
+	if e.Details == nil {
+		e.Details = make(map[string]string)
+	}
+	e.Details[key] = value
+	return e
+}
+
+func (e *Error) WithField(field, description string) *Error {
+	e.Fields = append(e.Fields, FieldViolation{
+		Field:       field,
+		Description: description,
+	})
+	return e
+}
+
+// WithRetryAfter hints clients to wait at least d before retrying.
+func (e *Error) WithRetryAfter(d time.Duration) *Error {
+	e.RetryAfter = d
+	return e
+}
+
+func New(kind Kind, code string, format string, args ...interface{}) *Error {
+	return &Error{
+		Kind:    kind,
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+func Wrap(err error, kind Kind, code string, format string, args ...interface{}) *Error {
+	e := New(kind, code, format, args...)
+	e.Cause = err
+	return e
+}
+
+func Internal(code string, format string, args ...interface{}) *Error {
+	return New(KindInternal, code, format, args...)
+}
+
+func InvalidArgument(code string, format string, args ...interface{}) *Error {
+	return New(KindInvalidArgument, code, format, args...)
+}
+
+func NotFound(code string, format string, args ...interface{}) *Error {
+	return New(KindNotFound, code, format, args...)
+}
+
+func Conflict(code string, format string, args ...interface{}) *Error {
+	return New(KindConflict, code, format, args...)
+}
+
+func Unavailable(code string, format string, args ...interface{}) *Error {
+	return New(KindUnavailable, code, format, args...)
+}
+
+func Unauthenticated(code string, format string, args ...interface{}) *Error {
+	return New(KindUnauthenticated, code, format, args...)
+}
+
+func PermissionDenied(code string, format string, args ...interface{}) *Error {
+	return New(KindPermissionDenied, code, format, args...)
+}
+
+func ResourceExhausted(code string, format string, args ...interface{}) *Error {
+	return New(KindResourceExhausted, code, format, args...)
+}
+
+// From returns err as a typed error, unwrapping it to find one, treating untyped errors as internal.
+func From(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if stderrors.As(err, &e) {
+		return e
+	}
+	return Wrap(err, KindInternal, kindNames[KindInternal], "internal error")
+}
+
+func KindOf(err error) Kind {
+	if e := From(err); e != nil {
+		return e.Kind
+	}
+	return KindInternal
+}
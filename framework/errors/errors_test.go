@@ -0,0 +1,40 @@
+package errors
+
+import (
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestFrom(t *testing.T) {
+	notFound := NotFound("message_not_found", "message %s not found", "42")
+
+	tests := []struct {
+		name string
+		err  error
+		kind Kind
+		code string
+	}{
+		{"typed", notFound, KindNotFound, "message_not_found"},
+		{"wrapped", fmt.Errorf("loading message: %w", notFound), KindNotFound, "message_not_found"},
+		{"wrapped twice", fmt.Errorf("handler: %w", fmt.Errorf("loading message: %w", notFound)), KindNotFound, "message_not_found"},
+		{"untyped", io.EOF, KindInternal, "internal"},
+		{"formatted", fmt.Errorf("loading message: %v", notFound), KindInternal, "internal"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := From(tt.err)
+			if e.Kind != tt.kind || e.Code != tt.code {
+				t.Errorf("From() = %s/%s, want %s/%s", e.Kind, e.Code, tt.kind, tt.code)
+			}
+			if KindOf(tt.err) != tt.kind {
+				t.Errorf("KindOf() = %s, want %s", KindOf(tt.err), tt.kind)
+			}
+		})
+	}
+
+	if From(nil) != nil {
+		t.Error("From(nil) should be nil")
+	}
+}
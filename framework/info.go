@@ -0,0 +1,107 @@
+package framework
+
+import (
+	"os"
+	"runtime"
+	"sort"
+	"time"
+)
+
+type ServiceInfo struct {
+	Name       string    `json:"name"`
+	Version    string    `json:"version"`
+	Build      string    `json:"build"`
+	GoVersion  string    `json:"go_version"`
+	VCS        VCSInfo   `json:"vcs"`
+	Host       string    `json:"host,omitempty"`
+	StartTime  time.Time `json:"start_time"`
+	Uptime     string    `json:"uptime,omitempty"`
+	Components []string  `json:"components,omitempty"`
+	Health     Health    `json:"health"`
+}
+
+// Health reports the errors of the components checking their health.
+type Health struct {
+	Healthy bool              `json:"healthy"`
+	Errors  map[string]string `json:"errors,omitempty"`
+}
+
+// HealthChecker is implemented by the components able to tell whether they work, e.g. from the state of their connection.
+type HealthChecker interface {
+	Health() error
+}
+
+type VCSInfo struct {
+	Revision string `json:"revision,omitempty"`
+	Time     string `json:"time,omitempty"`
+	Modified bool   `json:"modified,omitempty"`
+}
+
+// VCS details stamped at link time by the x_defs of the service binaries, from workspace-status.sh
+var (
+	vcsTime      string
+	vcsTreeState string
+)
+
+func newServiceInfo(name, version, build string) *ServiceInfo {
+	info := &ServiceInfo{
+		Name:      name,
+		Version:   version,
+		Build:     build,
+		GoVersion: runtime.Version(),
+		VCS: VCSInfo{
+			Revision: build,
+			Time:     vcsTime,
+			Modified: vcsTreeState == "dirty",
+		},
+	}
+
+	if host, err := os.Hostname(); err == nil {
+		info.Host = host
+	}
+	return info
+}
+
+func (svc *service) Info() ServiceInfo {
+	info := *svc.info
+
+	if !info.StartTime.IsZero() {
+		info.Uptime = time.Since(info.StartTime).Round(time.Second).String()
+	}
+
+	svc.componentsLock.Lock()
+	components := make([]string, 0, len(svc.components))
+	for id := range svc.components {
+		components = append(components, id)
+	}
+	svc.componentsLock.Unlock()
+
+	sort.Strings(components)
+	info.Components = components
+	info.Health = svc.Health()
+
+	return info
+}
+
+func (svc *service) Health() Health {
+	svc.componentsLock.Lock()
+	var checkers []Component
+	for _, component := range svc.components {
+		if _, ok := component.(HealthChecker); ok {
+			checkers = append(checkers, component)
+		}
+	}
+	svc.componentsLock.Unlock()
+
+	health := Health{Healthy: true}
+	for _, component := range checkers {
+		if err := component.(HealthChecker).Health(); err != nil {
+			if health.Errors == nil {
+				health.Errors = make(map[string]string)
+			}
+			health.Errors[component.ID()] = err.Error()
+			health.Healthy = false
+		}
+	}
+	return health
+}
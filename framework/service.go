@@ -2,12 +2,14 @@ package framework
 
 import (
 	"encoding/json"
+	"expvar"
 	"fmt"
 	"os"
 	"os/signal"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/deckarep/golang-set"
 	"github.com/hashicorp/go-multierror"
@@ -53,12 +55,8 @@ func Create(name, version, build string, handler Component) (Service, error) {
 		cliFlags:       defaultFlags,
 		components:     make(map[string]Component),
 		componentsDeps: make(map[string]mapset.Set),
-		info: &versionInfo{
-			Name:    name,
-			Version: version,
-			Build:   build,
-		},
-		shutdownCh: make(chan struct{}, 1),
+		info:           newServiceInfo(name, version, build),
+		shutdownCh:     make(chan struct{}, 1),
 	}
 
 	if handler == nil {
@@ -89,17 +87,13 @@ type Service interface {
 	Component(string) (Component, error)
 	DebugMode() bool
 	Handler() Component
+	Health() Health
+	Info() ServiceInfo
 	Logger() *zerolog.Logger
 	Name() string
 	Shutdown()
 }
 
-type versionInfo struct {
-	Name    string `json:"name"`
-	Version string `json:"version"`
-	Build   string `json:"build"`
-}
-
 type service struct {
 	name             string
 	cliFlags         []cli.Flag
@@ -108,7 +102,7 @@ type service struct {
 	componentsLock   sync.Mutex
 	debugMode        bool
 	humanReadableLog bool
-	info             *versionInfo
+	info             *ServiceInfo
 	logger           *zerolog.Logger
 	shutdownLock     sync.Mutex
 	shutdownCh       chan struct{}
@@ -192,6 +186,7 @@ func (svc *service) Shutdown() {
 }
 
 func (svc *service) bootstrapInternal(_ *cli.Context) error {
+	svc.info.StartTime = time.Now()
 	svc.logger.Info().Msg("bootstrapping service components...")
 
 	bootstrapSequence, err := svc.computeBootstrapSequence()
@@ -222,7 +217,17 @@ func (svc *service) bootstrapInternal(_ *cli.Context) error {
 		}
 	}
 
-	svc.logger.Info().Msg("service bootstrap completed")
+	info := svc.Info()
+	svc.logger.Info().
+		Str("go_version", info.GoVersion).
+		Str("vcs_revision", info.VCS.Revision).
+		Str("host", info.Host).
+		Strs("components", info.Components).
+		Msg("service bootstrap completed")
+
+	expvar.Publish("service_info", expvar.Func(func() interface{} {
+		return svc.Info()
+	}))
 
 	quit := make(chan os.Signal)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
@@ -234,6 +239,7 @@ func (svc *service) bootstrapInternal(_ *cli.Context) error {
 			goto quit
 		case err = <-errCh:
 			svc.logger.Error().Err(err).Msg("caught service error")
+			svc.Shutdown()
 			goto quit
 		}
 	}
@@ -263,6 +269,13 @@ func (svc *service) configure(cliCtx *cli.Context) error {
 	if err := configErr.ErrorOrNil(); err != nil {
 		return cli.NewExitError(err, 1)
 	}
+
+	// Dependencies can follow the configuration, e.g. the store selected on the command line
+	for id, c := range svc.components {
+		for _, dep := range c.DependsOn() {
+			svc.componentsDeps[id].Add(dep)
+		}
+	}
 	return nil
 }
 
@@ -320,6 +333,6 @@ func (svc *service) setupLogger() {
 }
 
 func (svc *service) versionPrinter(_ *cli.Context) {
-	versionString, _ := json.Marshal(svc.info)
+	versionString, _ := json.Marshal(svc.Info())
 	fmt.Printf("%s\n", versionString)
 }
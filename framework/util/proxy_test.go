@@ -0,0 +1,39 @@
+package util
+
+import "testing"
+
+func TestTrustedProxiesClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		expected     string
+	}{
+		{"direct client", "203.0.113.7:4321", nil, "203.0.113.7"},
+		{"spoofed by untrusted peer", "203.0.113.7:4321", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted proxy", "10.1.2.3:4321", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"client prepends a fake address", "10.1.2.3:4321", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+		{"chain of trusted proxies", "192.168.1.1:4321", []string{"198.51.100.1", "10.0.0.2"}, "198.51.100.1"},
+		{"invalid forwarded address", "10.1.2.3:4321", []string{"garbage"}, "10.1.2.3"},
+		{"trusted proxy without header", "10.1.2.3:4321", nil, "10.1.2.3"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if ip := proxies.ClientIP(test.remoteAddr, test.forwardedFor); ip != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, ip)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxiesInvalid(t *testing.T) {
+	if _, err := ParseTrustedProxies([]string{"not-an-ip"}); err == nil {
+		t.Error("expected an error")
+	}
+}
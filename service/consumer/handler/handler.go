@@ -3,9 +3,10 @@ package handler
 import (
 	"sync"
 
-	"github.com/nats-io/go-nats"
+	"github.com/golang/protobuf/proto"
 	"github.com/rs/zerolog"
 	"github.com/ubiqueworks/go-clean-architecture/framework"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/inbox"
 	"github.com/ubiqueworks/go-clean-architecture/framework/component/natsbroker"
 	"github.com/ubiqueworks/go-clean-architecture/service/consumer/usecase"
 	"github.com/ubiqueworks/go-clean-architecture/service/shared/messaging"
@@ -29,6 +30,7 @@ func (h *serviceHandler) ID() string {
 func (h *serviceHandler) DependsOn() []string {
 	return []string{
 		natsbroker.Component,
+		inbox.Component,
 	}
 }
 
@@ -59,32 +61,39 @@ func (h *serviceHandler) Initialize(wg *sync.WaitGroup, startedCh chan<- struct{
 		return
 	}
 
+	messageInbox, err := inbox.Get(h.service)
+	if err != nil {
+		errCh <- err
+		return
+	}
+
 	h.handleMessage = usecase.NewHandleMessageUseCase().Execute
 
-	go h.monitorEvents(broker, shutdownCh)
+	// Redelivered and republished events are handled once, recognized by the id of the outbox event
+	onUserMessage := messageInbox.Wrap(h.onUserMessage, nil)
+	sub, err := broker.QueueSubscribe(messaging.ChannelUserMessage, h.service.Name(), newUserMessage, onUserMessage)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("error subscribing to user message channel")
+		errCh <- err
+		return
+	}
+	h.logger.Info().Msg("start monitoring topics")
 	close(startedCh)
 
 	// Wait for shutdown
 	<-shutdownCh
-}
-
-func (h *serviceHandler) monitorEvents(broker natsbroker.Broker, shutdownCh <-chan struct{}) {
-	h.logger.Info().Msg("start monitoring topics")
-
-	client := broker.Client()
 
-	userMessageCh := make(chan *nats.Msg, 10)
-	if _, err := client.ChanQueueSubscribe(messaging.ChannelUserMessage, h.service.Name(), userMessageCh); err != nil {
-		h.logger.Error().Err(err).Msg("error subscribing to user message channel")
+	// Let the messages already received be handled before reporting stopped
+	if err := sub.Drain(); err != nil {
+		h.logger.Warn().Err(err).Msg("error draining user message channel")
 	}
+	h.logger.Info().Msg("stopped monitoring topics")
+}
 
-	for {
-		select {
-		case msg := <-userMessageCh:
-			go h.handleMessage(h.logger, msg)
-		case <-shutdownCh:
-			h.logger.Info().Msg("stopped monitoring topics")
-			return
-		}
-	}
+func newUserMessage() proto.Message {
+	return &messaging.EventUserMessage{}
+}
+
+func (h *serviceHandler) onUserMessage(msg *natsbroker.Message) error {
+	return h.handleMessage(h.logger, msg.Payload.(*messaging.EventUserMessage))
 }
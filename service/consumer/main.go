@@ -2,6 +2,7 @@ package main
 
 import (
 	"github.com/ubiqueworks/go-clean-architecture/framework"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/inbox"
 	"github.com/ubiqueworks/go-clean-architecture/framework/component/natsbroker"
 	"github.com/ubiqueworks/go-clean-architecture/service/consumer/handler"
 )
@@ -20,5 +21,6 @@ func main() {
 	}
 
 	service.AddComponent(natsbroker.Create())
+	service.AddComponent(inbox.Create())
 	service.Bootstrap()
 }
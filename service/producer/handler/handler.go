@@ -6,7 +6,7 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/ubiqueworks/go-clean-architecture/framework"
 	"github.com/ubiqueworks/go-clean-architecture/framework/component/cloudstore"
-	"github.com/ubiqueworks/go-clean-architecture/framework/component/natsbroker"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/outbox"
 	"github.com/ubiqueworks/go-clean-architecture/service/producer/repository"
 	"github.com/ubiqueworks/go-clean-architecture/service/producer/usecase"
 	"gopkg.in/urfave/cli.v1"
@@ -30,7 +30,7 @@ func (h *serviceHandler) ID() string {
 func (h *serviceHandler) DependsOn() []string {
 	return []string{
 		cloudstore.Component,
-		natsbroker.Component,
+		outbox.Component,
 	}
 }
 
@@ -53,7 +53,7 @@ func (h *serviceHandler) Configure(service framework.Service, cliCtx *cli.Contex
 func (h *serviceHandler) Initialize(wg *sync.WaitGroup, startedCh chan<- struct{}, shutdownCh <-chan struct{}, errCh chan<- error) {
 	defer wg.Done()
 
-	broker, err := natsbroker.Get(h.service)
+	eventOutbox, err := outbox.Get(h.service)
 	if err != nil {
 		errCh <- err
 		return
@@ -65,10 +65,10 @@ func (h *serviceHandler) Initialize(wg *sync.WaitGroup, startedCh chan<- struct{
 		return
 	}
 
-	messageRepo := repository.NewMessageRepository(h.logger, datastore)
+	messageRepo := repository.NewMessageRepository(h.logger, datastore, eventOutbox)
 
 	h.getMessages = usecase.NewGetMessagesUseCase(messageRepo).Execute
-	h.storeAndPublishMessage = usecase.NewStoreAndPublishMessageUseCase(broker, messageRepo).Execute
+	h.storeAndPublishMessage = usecase.NewStoreAndPublishMessageUseCase(messageRepo).Execute
 
 	close(startedCh)
 
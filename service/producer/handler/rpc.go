@@ -4,28 +4,66 @@ import (
 	"context"
 
 	"github.com/golang/protobuf/ptypes"
-	"github.com/rs/zerolog"
 	"github.com/ubiqueworks/go-clean-architecture/framework"
-	"github.com/ubiqueworks/go-clean-architecture/framework/util"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/auth"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/ratelimit"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/transport/rpc"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/validation"
 	"github.com/ubiqueworks/go-clean-architecture/service/producer/domain"
 	"google.golang.org/grpc"
 )
 
-func InitRpcFunc(service framework.Service, component framework.Component, server *grpc.Server) error {
+// AccessRules declare the scopes required by the ProducerRPC methods, HTTP routes are covered through the gateway
+var AccessRules = []auth.Rule{
+	{
+		RPC:    "/handler.ProducerRPC/GetMessages",
+		Scopes: []string{"messages:read"},
+	},
+	{
+		RPC:    "/handler.ProducerRPC/PublishMessage",
+		Scopes: []string{"messages:write"},
+	},
+}
+
+// RateLimits protect the datastore and the broker from clients flooding PublishMessage
+var RateLimits = []ratelimit.Rule{
+	{
+		RPC:   "/handler.ProducerRPC/PublishMessage",
+		Key:   ratelimit.KeyPrincipal,
+		Rate:  5,
+		Burst: 10,
+	},
+}
+
+// IdempotentMethods honour the idempotency-key metadata so that retries don't publish duplicate messages
+var IdempotentMethods = []string{
+	"/handler.ProducerRPC/PublishMessage",
+}
+
+// ValidationRules reject incomplete messages before they reach the handlers
+var ValidationRules = validation.Rules{
+	"handler.PublishMessageRequest": {
+		"message":         {Required: true},
+		"message.name":    {Required: true, MaxLen: 100},
+		"message.message": {Required: true, MaxLen: 2000},
+	},
+}
+
+func InitRpcFunc(service framework.Service, _ framework.Component, server *grpc.Server) error {
 	RegisterProducerRPCServer(server, &rpcServer{
-		logger:  component.Logger(),
 		handler: service.Handler().(*serviceHandler),
 	})
 	return nil
 }
 
 type rpcServer struct {
-	logger  *zerolog.Logger
 	handler *serviceHandler
 }
 
-func (s *rpcServer) GetMessages(context.Context, *Empty) (*GetMessagesReply, error) {
-	messageEntities, err := s.handler.getMessages(s.logger)
+func (s *rpcServer) GetMessages(ctx context.Context, _ *Empty) (*GetMessagesReply, error) {
+	logger := microrpc.RequestLogger(ctx)
+
+	messageEntities, err := s.handler.getMessages(logger)
 	if err != nil {
 		return nil, err
 	}
@@ -47,11 +85,12 @@ func (s *rpcServer) GetMessages(context.Context, *Empty) (*GetMessagesReply, err
 }
 
 func (s *rpcServer) PublishMessage(ctx context.Context, req *PublishMessageRequest) (*Empty, error) {
-	requestId := util.NewUUID()
+	logger := microrpc.RequestLogger(ctx)
+	requestId := microrpc.RequestId(ctx)
 
-	message := domain.NewMessage(req.GetMessage().Name, req.GetMessage().Message)
-	if err := s.handler.storeAndPublishMessage(s.logger, requestId, message); err != nil {
-		s.logger.Error().Err(err).Msg("server error")
+	message := domain.NewMessage(req.GetMessage().GetName(), req.GetMessage().GetMessage())
+	if err := s.handler.storeAndPublishMessage(logger, requestId, message); err != nil {
+		logger.Error().Err(err).Msg("server error")
 		return nil, err
 	}
 	return &Empty{}, nil
@@ -2,10 +2,15 @@ package main
 
 import (
 	"github.com/ubiqueworks/go-clean-architecture/framework"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/auth"
 	"github.com/ubiqueworks/go-clean-architecture/framework/component/cloudstore"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/idempotency"
 	"github.com/ubiqueworks/go-clean-architecture/framework/component/natsbroker"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/outbox"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/ratelimit"
 	"github.com/ubiqueworks/go-clean-architecture/framework/component/transport/http"
 	"github.com/ubiqueworks/go-clean-architecture/framework/component/transport/rpc"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/validation"
 	"github.com/ubiqueworks/go-clean-architecture/service/producer/handler"
 )
 
@@ -22,9 +27,14 @@ func main() {
 		panic(err)
 	}
 
+	service.AddComponent(auth.Create(handler.AccessRules...))
 	service.AddComponent(cloudstore.Create())
 	service.AddComponent(natsbroker.Create())
-	service.AddComponent(microhttp.Create(handler.InitHttpFunc), framework.HandlerComponent)
+	service.AddComponent(outbox.Create())
+	service.AddComponent(idempotency.Create(handler.IdempotentMethods...))
+	service.AddComponent(ratelimit.Create(handler.RateLimits...))
+	service.AddComponent(validation.Create(handler.ValidationRules))
+	service.AddComponent(microhttp.Create(handler.InitHttpFunc), framework.HandlerComponent, microrpc.Component)
 	service.AddComponent(microrpc.Create(handler.InitRpcFunc), framework.HandlerComponent)
 	service.Bootstrap()
 }
@@ -4,25 +4,31 @@ import (
 	"context"
 
 	"cloud.google.com/go/datastore"
+	"github.com/golang/protobuf/proto"
 	"github.com/rs/zerolog"
 	"github.com/ubiqueworks/go-clean-architecture/framework/component/cloudstore"
+	"github.com/ubiqueworks/go-clean-architecture/framework/component/outbox"
 	"github.com/ubiqueworks/go-clean-architecture/service/producer/domain"
 )
 
-func NewMessageRepository(logger *zerolog.Logger, cloudstore cloudstore.Store) MessageRepository {
+func NewMessageRepository(logger *zerolog.Logger, cloudstore cloudstore.Store, outbox outbox.Outbox) MessageRepository {
 	return &messageRepository{
 		logger:     logger,
 		cloudstore: cloudstore,
+		outbox:     outbox,
 	}
 }
 
 type MessageRepository interface {
 	GetAll() ([]domain.Message, error)
 	Store(*domain.Message) error
+	// StoreWithEvent stores the message and records the event in the outbox within the same transaction.
+	StoreWithEvent(entity *domain.Message, topic string, event proto.Message) error
 }
 
 type messageRepository struct {
 	cloudstore cloudstore.Store
+	outbox     outbox.Outbox
 	logger     *zerolog.Logger
 }
 
@@ -45,3 +51,15 @@ func (r *messageRepository) Store(entity *domain.Message) error {
 	}
 	return nil
 }
+
+func (r *messageRepository) StoreWithEvent(entity *domain.Message, topic string, event proto.Message) error {
+	client := r.cloudstore.Client()
+
+	_, err := client.RunInTransaction(context.Background(), func(tx *datastore.Transaction) error {
+		if _, err := tx.Put(entity.ID, entity); err != nil {
+			return err
+		}
+		return r.outbox.Add(tx, topic, event)
+	})
+	return err
+}
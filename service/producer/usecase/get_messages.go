@@ -1,9 +1,8 @@
 package usecase
 
 import (
-	"fmt"
-
 	"github.com/rs/zerolog"
+	"github.com/ubiqueworks/go-clean-architecture/framework/errors"
 	"github.com/ubiqueworks/go-clean-architecture/service/producer/domain"
 	"github.com/ubiqueworks/go-clean-architecture/service/producer/repository"
 )
@@ -25,7 +24,7 @@ func (uc *getMessagesUseCase) Execute(logger *zerolog.Logger) ([]domain.Message,
 
 	messages, err := uc.repo.GetAll()
 	if err != nil {
-		return nil, fmt.Errorf("error loading messages from repository: %v", err)
+		return nil, errors.Wrap(err, errors.KindUnavailable, "messages_unavailable", "error loading messages from repository")
 	}
 	return messages, nil
 }
@@ -1,3 +1,9 @@
 #!/bin/bash
 
 echo GIT_COMMIT $(git rev-parse --short HEAD)
+echo GIT_COMMIT_TIME $(git log -1 --format=%cI HEAD)
+if git diff --quiet HEAD; then
+    echo GIT_TREE_STATE clean
+else
+    echo GIT_TREE_STATE dirty
+fi